@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// leaseStates are the lease states that are always exported, so that
+// their series don't disappear when a subnet has no such leases.
+var leaseStates = []omapi.LeaseState{
+	omapi.LeaseStateFree,
+	omapi.LeaseStateActive,
+	omapi.LeaseStateExpired,
+	omapi.LeaseStateReleased,
+	omapi.LeaseStateAbandoned,
+	omapi.LeaseStateReset,
+	omapi.LeaseStateBackup,
+	omapi.LeaseStateReserved,
+	omapi.LeaseStateBootp,
+}
+
+// failoverStates are the failover states that are always exported.
+var failoverStates = []omapi.FailoverState{
+	omapi.FailoverStateStartup,
+	omapi.FailoverStateNormal,
+	omapi.FailoverStateCommunicationsInterrupted,
+	omapi.FailoverStatePartnerDown,
+	omapi.FailoverStatePotentialConflict,
+	omapi.FailoverStateRecover,
+	omapi.FailoverStatePaused,
+	omapi.FailoverStateShutdown,
+	omapi.FailoverStateRecoverDone,
+	omapi.FailoverStateResolutionInterrupted,
+	omapi.FailoverStateConflictDone,
+	omapi.FailoverStateRecoverWait,
+}
+
+// snapshot is the state of a server as of its last scrape.
+type snapshot struct {
+	up        bool
+	failovers map[string]omapi.Failover
+	leases    map[netip.Prefix]map[omapi.LeaseState]int
+}
+
+// collector periodically scrapes a single server. Its query metrics
+// accumulate over the exporter's lifetime, its snapshot is replaced by
+// each scrape.
+type collector struct {
+	cfg  serverConfig
+	conn *omapi.Connection // only used by the scrape loop
+
+	durations     prometheus.ObserverVec // by query type
+	errors        *prometheus.CounterVec // by status code
+	connectErrors prometheus.Counter
+
+	mu   sync.Mutex
+	last snapshot
+}
+
+func newCollector(cfg serverConfig, m *metrics) *collector {
+	server := prometheus.Labels{"server": cfg.Name}
+	c := &collector{
+		cfg:           cfg,
+		durations:     m.queryDuration.MustCurryWith(server),
+		errors:        m.queryErrors.MustCurryWith(server),
+		connectErrors: m.connectErrors.With(server),
+	}
+
+	// Export the histograms before the first queries.
+	c.durations.WithLabelValues("failover")
+	c.durations.WithLabelValues("lease")
+
+	return c
+}
+
+// run scrapes the server every interval until ctx is done.
+func (c *collector) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		scrapeCtx, cancel := context.WithTimeout(ctx, interval)
+		c.scrape(scrapeCtx)
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if c.conn != nil {
+				c.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+func (c *collector) scrape(ctx context.Context) {
+	snap := snapshot{
+		failovers: make(map[string]omapi.Failover),
+		leases:    make(map[netip.Prefix]map[omapi.LeaseState]int),
+	}
+
+	if err := c.connect(ctx); err != nil {
+		log.Printf("%s: %s", c.cfg.Name, err)
+		c.setSnapshot(snap)
+		return
+	}
+
+	snap.up = true
+
+	for _, name := range c.cfg.Failover {
+		start := time.Now()
+		failover, err := c.conn.FindFailoverContext(ctx, name)
+		c.observe("failover", time.Since(start), err)
+
+		if err != nil {
+			log.Printf("%s: failover-state %q: %s", c.cfg.Name, name, err)
+			snap.up = false
+			continue
+		}
+		snap.failovers[name] = failover
+	}
+
+	for _, prefix := range c.cfg.prefixes {
+		counts, err := c.countLeases(ctx, prefix)
+		if err != nil {
+			log.Printf("%s: scanning %s: %s", c.cfg.Name, prefix, err)
+			snap.up = false
+			continue
+		}
+		snap.leases[prefix] = counts
+	}
+
+	c.setSnapshot(snap)
+}
+
+// connect (re)connects to the server if there is no working
+// connection. Like the rest of the scrape, the dial is bounded by ctx.
+func (c *collector) connect(ctx context.Context) error {
+	if c.conn != nil && c.conn.Err() == nil {
+		return nil
+	}
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	conn, err := omapi.DialContext(ctx, c.cfg.Address, c.cfg.KeyName, c.cfg.Key)
+	if err != nil {
+		c.connectErrors.Inc()
+
+		return fmt.Errorf("connecting to %s: %w", c.cfg.Address, err)
+	}
+	c.conn = conn
+
+	return nil
+}
+
+// countLeases counts the leases in a prefix by state. The counts are
+// only valid if the whole prefix could be scanned.
+func (c *collector) countLeases(ctx context.Context, prefix netip.Prefix) (map[omapi.LeaseState]int, error) {
+	opts := omapi.ScanOptions{
+		Concurrency: c.cfg.Concurrency,
+		Observe: func(result omapi.ScanResult, d time.Duration) {
+			err := result.Err
+			if errors.Is(err, omapi.ErrNotFound) { // addresses without a lease are expected
+				err = nil
+			}
+			c.observe("lease", d, err)
+		},
+	}
+
+	results, err := c.conn.ScanLeases(ctx, prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[omapi.LeaseState]int)
+	var lastErr error
+	for result := range results {
+		if result.Err != nil {
+			lastErr = result.Err
+			continue
+		}
+		counts[result.Lease.State]++
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, lastErr
+}
+
+// observe records the duration and outcome of a query.
+func (c *collector) observe(query string, d time.Duration, err error) {
+	c.durations.WithLabelValues(query).Observe(d.Seconds())
+
+	var status omapi.Status
+	if errors.As(err, &status) && status.IsError() {
+		// One series per code, whatever the server's explanation.
+		c.errors.WithLabelValues(strconv.Itoa(int(status.Code)), omapi.StatusForCode(status.Code).Message).Inc()
+	}
+}
+
+func (c *collector) setSnapshot(snap snapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.last = snap
+}
+
+// snapshot returns the state of the server as of the last scrape.
+func (c *collector) snapshot() snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.last
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// config is the exporter's config file.
+type config struct {
+	Listen   string         `json:"listen"`
+	Interval duration       `json:"interval"`
+	Servers  []serverConfig `json:"servers"`
+}
+
+// serverConfig describes a server to scrape and what to scrape.
+type serverConfig struct {
+	// Name identifies the server in the server label. It defaults to
+	// the address.
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	KeyName string `json:"key-name"`
+	Key     string `json:"key"` // base64 encoded secret
+
+	// Failover lists the names of the failover-states to query.
+	Failover []string `json:"failover"`
+
+	// Subnets lists the IPv4 prefixes whose leases are counted.
+	Subnets []string `json:"subnets"`
+
+	// Concurrency is the number of lease lookups in flight during a
+	// scan.
+	Concurrency int `json:"concurrency"`
+
+	prefixes []netip.Prefix
+}
+
+// duration is a time.Duration written like "30s" in the config file.
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+
+	return nil
+}
+
+func loadConfig(path string) (config, error) {
+	cfg := config{Listen: ":9367", Interval: duration(time.Minute)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Interval <= 0 {
+		return cfg, errors.New("interval must be positive")
+	}
+	if len(cfg.Servers) == 0 {
+		return cfg, errors.New("no servers configured")
+	}
+
+	names := make(map[string]bool)
+	for i := range cfg.Servers {
+		server := &cfg.Servers[i]
+		if server.Address == "" {
+			return cfg, fmt.Errorf("server %d has no address", i+1)
+		}
+		if server.Name == "" {
+			server.Name = server.Address
+		}
+		if names[server.Name] {
+			return cfg, fmt.Errorf("duplicate server %q", server.Name)
+		}
+		names[server.Name] = true
+
+		if server.Concurrency < 1 {
+			server.Concurrency = 4
+		}
+
+		for _, subnet := range server.Subnets {
+			prefix, err := netip.ParsePrefix(subnet)
+			if err != nil || !prefix.Addr().Is4() {
+				return cfg, fmt.Errorf("server %q: invalid IPv4 subnet %q", server.Name, subnet)
+			}
+			server.prefixes = append(server.prefixes, prefix.Masked())
+		}
+	}
+
+	return cfg, nil
+}
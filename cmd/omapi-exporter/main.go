@@ -0,0 +1,128 @@
+// Command omapi-exporter exports the failover and lease state of ISC
+// DHCP servers as Prometheus metrics, by querying them over OMAPI.
+//
+// Usage:
+//
+//	omapi-exporter -config FILE [-listen ADDRESS]
+//
+// The config file is JSON and lists the servers to scrape:
+//
+//	{
+//		"listen": ":9367",
+//		"interval": "1m",
+//		"servers": [
+//			{
+//				"name": "dhcp1",
+//				"address": "dhcp1.example.net:7911",
+//				"key-name": "omapi_key",
+//				"key": "c2VjcmV0",
+//				"failover": ["dhcp-failover"],
+//				"subnets": ["10.0.0.0/24"],
+//				"concurrency": 8
+//			}
+//		]
+//	}
+//
+// Each server is scraped every interval in the background, and the
+// metrics of the last scrape are served on /metrics. OMAPI can't list
+// leases, so counting the leases of a subnet looks up every address of
+// it; the interval and concurrency should be chosen accordingly.
+//
+// The exported metrics are:
+//
+//	omapi_up                            whether the last scrape succeeded
+//	omapi_failover_local_state          local failover state, one series per state
+//	omapi_failover_partner_state        partner failover state, one series per state
+//	omapi_failover_skew_seconds         clock skew to the failover partner
+//	omapi_failover_cur_unacked_updates  updates not yet acknowledged by the partner
+//	omapi_failover_mclt_seconds         maximum client lead time
+//	omapi_leases                        leases per subnet and state
+//	omapi_query_duration_seconds        histogram of query durations
+//	omapi_query_errors_total            failed queries by status code
+//	omapi_connect_errors_total          failed connection attempts
+//
+// It is a separate module, so that users of the omapi package don't
+// depend on the Prometheus client.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	var cfgPath, listen string
+
+	flag.StringVar(&cfgPath, "config", "", "config `file`")
+	flag.StringVar(&listen, "listen", "", "`address` to serve metrics on (default from the config file, else :9367)")
+	flag.Parse()
+
+	if cfgPath == "" || flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(cfgPath)
+	if err != nil {
+		log.Fatalf("reading config: %s", err)
+	}
+	if listen != "" {
+		cfg.Listen = listen
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	registry := prometheus.NewRegistry()
+	m := newMetrics(registry)
+
+	var (
+		collectors []*collector
+		wg         sync.WaitGroup
+	)
+	for _, server := range cfg.Servers {
+		c := newCollector(server, m)
+		collectors = append(collectors, c)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(ctx, time.Duration(cfg.Interval))
+		}()
+	}
+
+	registry.MustRegister(stateCollector{collectors})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `<html><body><a href="/metrics">Metrics</a></body></html>`)
+	})
+
+	server := &http.Server{Addr: cfg.Listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Serving metrics on %s", cfg.Listen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// durationBuckets are the upper bounds of the query duration
+// histogram, in seconds.
+var durationBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// metrics are the metrics that the collectors update as they query
+// their servers. The servers' state is exported by stateCollector.
+type metrics struct {
+	queryDuration *prometheus.HistogramVec
+	queryErrors   *prometheus.CounterVec
+	connectErrors *prometheus.CounterVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "omapi_query_duration_seconds",
+			Help:    "Duration of OMAPI queries by query type.",
+			Buckets: durationBuckets,
+		}, []string{"server", "query"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "omapi_query_errors_total",
+			Help: "Number of OMAPI queries that failed, by status code. Lease lookups of addresses without a lease don't count.",
+		}, []string{"server", "code", "status"}),
+		connectErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "omapi_connect_errors_total",
+			Help: "Number of failed attempts to connect to the server.",
+		}, []string{"server"}),
+	}
+
+	reg.MustRegister(m.queryDuration, m.queryErrors, m.connectErrors)
+
+	return m
+}
+
+var (
+	upDesc = prometheus.NewDesc("omapi_up",
+		"Whether the last scrape of the server succeeded completely.",
+		[]string{"server"}, nil)
+	localStateDesc = prometheus.NewDesc("omapi_failover_local_state",
+		"Local failover state, 1 for the current state and 0 for all others.",
+		[]string{"server", "failover", "state"}, nil)
+	partnerStateDesc = prometheus.NewDesc("omapi_failover_partner_state",
+		"Partner failover state as seen by the server, 1 for the current state and 0 for all others.",
+		[]string{"server", "failover", "state"}, nil)
+	leasesDesc = prometheus.NewDesc("omapi_leases",
+		"Number of leases in a subnet by state.",
+		[]string{"server", "subnet", "state"}, nil)
+)
+
+// failoverGauges are the numeric values of a failover state that are
+// exported as they are.
+var failoverGauges = []struct {
+	desc  *prometheus.Desc
+	value func(omapi.Failover) int32
+}{
+	{
+		prometheus.NewDesc("omapi_failover_skew_seconds", "Clock skew between the server and its failover partner.", []string{"server", "failover"}, nil),
+		func(f omapi.Failover) int32 { return f.Skew },
+	},
+	{
+		prometheus.NewDesc("omapi_failover_cur_unacked_updates", "Number of updates not yet acknowledged by the failover partner.", []string{"server", "failover"}, nil),
+		func(f omapi.Failover) int32 { return f.CurUnackedUpdates },
+	},
+	{
+		prometheus.NewDesc("omapi_failover_mclt_seconds", "Maximum client lead time of the failover relationship.", []string{"server", "failover"}, nil),
+		func(f omapi.Failover) int32 { return f.Mclt },
+	},
+}
+
+// stateCollector is a prometheus.Collector for the state of the
+// servers as of their collectors' last scrape.
+type stateCollector struct {
+	collectors []*collector
+}
+
+func (s stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upDesc
+	ch <- localStateDesc
+	ch <- partnerStateDesc
+	ch <- leasesDesc
+	for _, gauge := range failoverGauges {
+		ch <- gauge.desc
+	}
+}
+
+func (s stateCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range s.collectors {
+		snap := c.snapshot()
+		server := c.cfg.Name
+
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, boolToFloat(snap.up), server)
+
+		for name, failover := range snap.failovers {
+			collectFailoverState(ch, localStateDesc, failover.LocalState, server, name)
+			collectFailoverState(ch, partnerStateDesc, failover.PartnerState, server, name)
+
+			for _, gauge := range failoverGauges {
+				ch <- prometheus.MustNewConstMetric(gauge.desc, prometheus.GaugeValue, float64(gauge.value(failover)), server, name)
+			}
+		}
+
+		for prefix, counts := range snap.leases {
+			for _, state := range leaseStates {
+				ch <- prometheus.MustNewConstMetric(leasesDesc, prometheus.GaugeValue, float64(counts[state]), server, prefix.String(), state.String())
+			}
+		}
+	}
+}
+
+// collectFailoverState collects a failover state as one sample per
+// state, the current state being 1.
+func collectFailoverState(ch chan<- prometheus.Metric, desc *prometheus.Desc, current omapi.FailoverState, server, failover string) {
+	known := false
+	for _, state := range failoverStates {
+		known = known || state == current
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, boolToFloat(state == current), server, failover, stateLabel(state))
+	}
+
+	if !known {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, server, failover, stateLabel(current))
+	}
+}
+
+// stateLabel returns the label value for a failover state, such as
+// "communications-interrupted".
+func stateLabel(state omapi.FailoverState) string {
+	if s := state.String(); s != "" {
+		return strings.ReplaceAll(s, " ", "-")
+	}
+
+	return strconv.Itoa(int(state))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := newMetrics(registry)
+	c := newCollector(serverConfig{Name: "dhcp1"}, m)
+	registry.MustRegister(stateCollector{[]*collector{c}})
+
+	c.last = snapshot{
+		up:        true,
+		failovers: map[string]omapi.Failover{"peer": {LocalState: omapi.FailoverStateNormal, PartnerState: 99, Mclt: 3600}},
+	}
+	c.observe("lease", 3*time.Millisecond, omapi.ErrNotFound)
+	c.observe("lease", time.Millisecond, errors.New("connection reset"))
+	c.observe("failover", time.Millisecond, nil)
+
+	expected := `
+# HELP omapi_failover_mclt_seconds Maximum client lead time of the failover relationship.
+# TYPE omapi_failover_mclt_seconds gauge
+omapi_failover_mclt_seconds{failover="peer",server="dhcp1"} 3600
+# HELP omapi_query_errors_total Number of OMAPI queries that failed, by status code. Lease lookups of addresses without a lease don't count.
+# TYPE omapi_query_errors_total counter
+omapi_query_errors_total{code="23",server="dhcp1",status="not found"} 1
+# HELP omapi_up Whether the last scrape of the server succeeded completely.
+# TYPE omapi_up gauge
+omapi_up{server="dhcp1"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "omapi_up", "omapi_failover_mclt_seconds", "omapi_query_errors_total"); err != nil {
+		t.Error(err)
+	}
+
+	// Every known state is exported, plus the unknown current one.
+	if n, err := testutil.GatherAndCount(registry, "omapi_failover_partner_state"); err != nil || n != len(failoverStates)+1 {
+		t.Errorf("got %d partner state series (%v), want %d", n, err, len(failoverStates)+1)
+	}
+
+	// Both query types are exported, whether or not they were made.
+	if n := testutil.CollectAndCount(m.queryDuration); n != 2 {
+		t.Errorf("got %d query duration series, want 2", n)
+	}
+	if n := testutil.CollectAndCount(m.connectErrors); n != 1 {
+		t.Errorf("got %d connect error series, want 1", n)
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// maxBodySize is the largest request body the API reads.
+const maxBodySize = 64 << 10
+
+// api serves the HTTP API, using connections of the pool for each
+// request. Requests must carry token as a bearer token.
+type api struct {
+	pool          *omapi.Pool
+	token         string
+	allowShutdown bool
+}
+
+func (a *api) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /hosts/{name}", a.getHost)
+	mux.HandleFunc("GET /hosts", a.findHost)
+	mux.HandleFunc("POST /hosts", a.createHost)
+	mux.HandleFunc("DELETE /hosts/{name}", a.deleteHost)
+	mux.HandleFunc("GET /leases/{ip}", a.getLease)
+	mux.HandleFunc("POST /leases/{ip}/release", a.releaseLease)
+	mux.HandleFunc("GET /failover/{name}", a.getFailover)
+	mux.HandleFunc("POST /failover/{name}/partner-down", a.partnerDown)
+	mux.HandleFunc("POST /control/shutdown", a.shutdown)
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPI)
+	})
+
+	return a.authenticate(mux)
+}
+
+// authenticate rejects requests that don't carry the API's token.
+func (a *api) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || a.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, &apiError{status: http.StatusUnauthorized, message: "missing or invalid token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiError is an error with an HTTP status code and, for errors
+// returned by the OMAPI server, the OMAPI status code.
+type apiError struct {
+	status  int
+	code    int32
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...any) error {
+	return &apiError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+// httpStatus maps OMAPI status codes to HTTP status codes. Anything not
+// listed is the server failing to do what the gateway asked, which is
+// a bad gateway.
+var httpStatus = map[int32]int{
+	2:  http.StatusGatewayTimeout, // timed out
+	6:  http.StatusForbidden,      // permission denied
+	18: http.StatusConflict,       // already exists
+	20: http.StatusGatewayTimeout, // operation canceled
+	23: http.StatusNotFound,       // not found
+	27: http.StatusNotImplemented, // not implemented
+	39: http.StatusBadRequest,     // invalid argument
+	43: http.StatusConflict,       // more than one object matches key
+	44: http.StatusConflict,       // key conflict
+	46: http.StatusBadRequest,     // no key specified
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	body := struct {
+		Error string `json:"error"`
+		Code  int32  `json:"code,omitempty"`
+	}{Error: err.Error()}
+
+	code := http.StatusBadGateway
+
+	var (
+		apiErr *apiError
+		status omapi.Status
+	)
+	switch {
+	case errors.As(err, &apiErr):
+		code = apiErr.status
+		body.Code = apiErr.code
+	case errors.As(err, &status):
+		if c, ok := httpStatus[status.Code]; ok {
+			code = c
+		}
+		body.Code = status.Code
+	case errors.Is(err, omapi.ErrPoolClosed):
+		code = http.StatusServiceUnavailable
+	}
+
+	if code >= 500 {
+		log.Printf("Request failed: %s", err)
+	}
+
+	writeJSON(w, code, body)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// host is the JSON representation of a host, both for requests and
+// responses.
+type host struct {
+	Name                 string `json:"name"`
+	HardwareAddress      string `json:"hardware-address,omitempty"`
+	HardwareType         string `json:"hardware-type,omitempty"`
+	IP                   string `json:"ip-address,omitempty"`
+	DHCPClientIdentifier string `json:"dhcp-client-identifier,omitempty"`
+	Statements           string `json:"statements,omitempty"`
+}
+
+func newHost(h omapi.Host) host {
+	v := host{
+		Name:                 h.Name,
+		DHCPClientIdentifier: omapi.FormatIdentifier(h.DHCPClientIdentifier),
+	}
+	if h.HardwareAddress != nil {
+		v.HardwareAddress = h.HardwareAddress.String()
+		v.HardwareType = h.HardwareType.Name()
+	}
+	if len(h.IP) > 0 {
+		v.IP = h.IP.String()
+	}
+
+	return v
+}
+
+func (v host) toHost() (omapi.Host, error) {
+	h := omapi.Host{Name: v.Name, Statements: v.Statements}
+
+	if v.HardwareAddress != "" {
+		mac, err := net.ParseMAC(v.HardwareAddress)
+		if err != nil {
+			return h, badRequest("invalid hardware-address %q", v.HardwareAddress)
+		}
+		h.HardwareAddress = mac
+
+		if h.HardwareType, err = parseHardwareType(v.HardwareType); err != nil {
+			return h, err
+		}
+	}
+
+	if v.IP != "" {
+		if h.IP = net.ParseIP(v.IP).To4(); h.IP == nil {
+			return h, badRequest("invalid ip-address %q", v.IP)
+		}
+	}
+
+	h.DHCPClientIdentifier = omapi.ParseIdentifier(v.DHCPClientIdentifier)
+
+	return h, nil
+}
+
+// parseHardwareType parses a hardware-type, which defaults to
+// ethernet.
+func parseHardwareType(s string) (omapi.HardwareType, error) {
+	if s == "" {
+		return omapi.Ethernet, nil
+	}
+
+	hw, err := omapi.ParseHardwareType(s)
+	if err != nil {
+		return 0, badRequest("%v", err)
+	}
+
+	return hw, nil
+}
+
+type lease struct {
+	IP                   string `json:"ip-address"`
+	State                string `json:"state"`
+	HardwareAddress      string `json:"hardware-address,omitempty"`
+	HardwareType         string `json:"hardware-type,omitempty"`
+	DHCPClientIdentifier string `json:"dhcp-client-identifier,omitempty"`
+	ClientHostname       string `json:"client-hostname,omitempty"`
+	Starts               string `json:"starts,omitempty"`
+	Ends                 string `json:"ends,omitempty"`
+	Cltt                 string `json:"cltt,omitempty"`
+}
+
+func newLease(l omapi.Lease) lease {
+	v := lease{
+		IP:                   l.IP.String(),
+		State:                l.State.String(),
+		DHCPClientIdentifier: omapi.FormatIdentifier(l.DHCPClientIdentifier),
+		ClientHostname:       l.ClientHostname,
+		Starts:               omapi.FormatTime(l.Starts),
+		Ends:                 omapi.FormatTime(l.Ends),
+		Cltt:                 omapi.FormatTime(l.Cltt),
+	}
+	if l.HardwareAddress != nil {
+		v.HardwareAddress = l.HardwareAddress.String()
+		v.HardwareType = l.HardwareType.Name()
+	}
+
+	return v
+}
+
+type failover struct {
+	Name                  string `json:"name"`
+	Hierarchy             string `json:"hierarchy"`
+	LocalState            string `json:"local-state"`
+	PartnerState          string `json:"partner-state"`
+	LocalAddress          string `json:"local-address,omitempty"`
+	PartnerAddress        string `json:"partner-address,omitempty"`
+	LocalStos             string `json:"local-stos,omitempty"`
+	PartnerStos           string `json:"partner-stos,omitempty"`
+	Mclt                  int32  `json:"mclt"`
+	Skew                  int32  `json:"skew"`
+	CurUnackedUpdates     int32  `json:"cur-unacked-updates"`
+	MaxOutstandingUpdates int32  `json:"max-outstanding-updates"`
+	LastPacketSent        string `json:"last-packet-sent,omitempty"`
+	LastTimestampReceived string `json:"last-timestamp-received,omitempty"`
+}
+
+func newFailover(f omapi.Failover) failover {
+	return failover{
+		Name:                  f.Name,
+		Hierarchy:             f.Hierarchy.String(),
+		LocalState:            f.LocalState.String(),
+		PartnerState:          f.PartnerState.String(),
+		LocalAddress:          omapi.FormatAddress(f.LocalAddress, f.LocalPort),
+		PartnerAddress:        omapi.FormatAddress(f.PartnerAddress, f.PartnerPort),
+		LocalStos:             omapi.FormatTime(f.LocalStos),
+		PartnerStos:           omapi.FormatTime(f.PartnerStos),
+		Mclt:                  f.Mclt,
+		Skew:                  f.Skew,
+		CurUnackedUpdates:     f.CurUnackedUpdates,
+		MaxOutstandingUpdates: f.MaxOutstandingUpdates,
+		LastPacketSent:        omapi.FormatTime(f.LastPacketSent),
+		LastTimestampReceived: omapi.FormatTime(f.LastTimestampReceived),
+	}
+}
+
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return nil, badRequest("invalid IPv4 address %q", s)
+	}
+
+	return ip, nil
+}
+
+func (a *api) getHost(w http.ResponseWriter, r *http.Request) {
+	h, err := a.lookupHost(r.Context(), omapi.Host{Name: r.PathValue("name")})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newHost(h))
+}
+
+func (a *api) findHost(w http.ResponseWriter, r *http.Request) {
+	query, err := hostQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	h, err := a.lookupHost(r.Context(), query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newHost(h))
+}
+
+// hostQuery builds a host lookup from the mac, hw-type and ip query
+// parameters.
+func hostQuery(params url.Values) (omapi.Host, error) {
+	var query omapi.Host
+
+	if mac := params.Get("mac"); mac != "" {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return query, badRequest("invalid hardware address %q", mac)
+		}
+		query.HardwareAddress = hw
+
+		if query.HardwareType, err = parseHardwareType(params.Get("hw-type")); err != nil {
+			return query, err
+		}
+	}
+
+	if ip := params.Get("ip"); ip != "" {
+		var err error
+		if query.IP, err = parseIP(ip); err != nil {
+			return query, err
+		}
+	}
+
+	if query.HardwareAddress == nil && query.IP == nil {
+		return query, badRequest("one of the mac and ip parameters is required")
+	}
+
+	return query, nil
+}
+
+func (a *api) lookupHost(ctx context.Context, query omapi.Host) (omapi.Host, error) {
+	con, err := a.pool.Conn()
+	if err != nil {
+		return omapi.Host{}, err
+	}
+
+	return con.FindHostContext(ctx, query)
+}
+
+func (a *api) createHost(w http.ResponseWriter, r *http.Request) {
+	var v host
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, &apiError{status: http.StatusRequestEntityTooLarge, message: fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit)})
+			return
+		}
+		writeError(w, badRequest("invalid host: %s", err))
+		return
+	}
+	if v.Name == "" {
+		writeError(w, badRequest("name is required"))
+		return
+	}
+
+	h, err := v.toHost()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	con, err := a.pool.Conn()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	created, err := con.CreateHostContext(r.Context(), h)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/hosts/"+url.PathEscape(created.Name))
+	writeJSON(w, http.StatusCreated, newHost(created))
+}
+
+func (a *api) deleteHost(w http.ResponseWriter, r *http.Request) {
+	con, err := a.pool.Conn()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	h, err := con.FindHostContext(r.Context(), omapi.Host{Name: r.PathValue("name")})
+	if err == nil {
+		err = con.DeleteContext(r.Context(), h.Handle)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) getLease(w http.ResponseWriter, r *http.Request) {
+	ip, err := parseIP(r.PathValue("ip"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	con, err := a.pool.Conn()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	l, err := con.FindLeaseContext(r.Context(), omapi.Lease{IP: ip})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newLease(l))
+}
+
+func (a *api) releaseLease(w http.ResponseWriter, r *http.Request) {
+	ip, err := parseIP(r.PathValue("ip"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	con, err := a.pool.Conn()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := con.ReleaseLeaseContext(r.Context(), omapi.Lease{IP: ip}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) getFailover(w http.ResponseWriter, r *http.Request) {
+	con, err := a.pool.Conn()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	f, err := con.FindFailoverContext(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newFailover(f))
+}
+
+func (a *api) partnerDown(w http.ResponseWriter, r *http.Request) {
+	con, err := a.pool.Conn()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := con.SetFailoverStateContext(r.Context(), r.PathValue("name"), omapi.FailoverStatePartnerDown); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) shutdown(w http.ResponseWriter, r *http.Request) {
+	if !a.allowShutdown {
+		writeError(w, &apiError{status: http.StatusForbidden, message: "shutdown is not allowed"})
+		return
+	}
+
+	con, err := a.pool.Conn()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := con.ShutdownContext(r.Context()); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
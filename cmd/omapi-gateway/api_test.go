@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestAPI(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	pool := omapi.NewPool(server.Addr, "", "", 1)
+	defer pool.Close()
+
+	handler := (&api{pool: pool, token: "secret"}).handler()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		want   int
+	}{
+		{"no token", "GET", "/hosts/a", "", "", http.StatusUnauthorized},
+		{"wrong token", "GET", "/hosts/a", "wrong", "", http.StatusUnauthorized},
+		{"unknown host", "GET", "/hosts/a", "secret", "", http.StatusNotFound},
+		{"create", "POST", "/hosts", "secret", `{"name": "a", "hardware-address": "00:11:22:33:44:55"}`, http.StatusCreated},
+		{"create duplicate", "POST", "/hosts", "secret", `{"name": "a"}`, http.StatusConflict},
+		{"known host", "GET", "/hosts/a", "secret", "", http.StatusOK},
+		{"body too large", "POST", "/hosts", "secret", `{"name": "` + strings.Repeat("b", maxBodySize) + `"}`, http.StatusRequestEntityTooLarge},
+		{"delete", "DELETE", "/hosts/a", "secret", "", http.StatusNoContent},
+		{"shutdown not allowed", "POST", "/control/shutdown", "secret", "", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.want {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+		}
+	}
+}
+
+func TestAPIWithoutToken(t *testing.T) {
+	handler := (&api{}).handler()
+
+	r := httptest.NewRequest("GET", "/openapi.json", nil)
+	r.Header.Set("Authorization", "Bearer ")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d without a configured token, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,147 @@
+// Command omapi-gateway exposes an ISC DHCP server's OMAPI as a
+// JSON over HTTP API, for clients that can't speak OMAPI themselves.
+//
+// Usage:
+//
+//	omapi-gateway [-listen ADDRESS] [-server ADDRESS] [-key-name NAME] [-pool-size N] [-allow-shutdown] [-audit-log FILE]
+//
+// The key's base64 encoded secret is read from the OMAPI_KEY
+// environment variable, so that it doesn't show up in the process
+// list. The server and key name can also be given as OMAPI_SERVER and
+// OMAPI_KEY_NAME.
+//
+// Clients authenticate with a bearer token, which is read from the
+// OMAPI_GATEWAY_TOKEN environment variable and is required:
+//
+//	Authorization: Bearer TOKEN
+//
+// The API listens on the loopback interface by default; it should
+// only be exposed to other hosts behind TLS.
+//
+// With -audit-log, every create, update and delete is appended to the
+// file as a line of JSON, see omapi.AuditRecord.
+//
+// The resources are:
+//
+//	GET    /hosts/{name}                  look up a host by name
+//	GET    /hosts?mac=MAC|ip=IP           look up a host by hardware or IP address
+//	POST   /hosts                         create a host
+//	DELETE /hosts/{name}                  delete a host
+//	GET    /leases/{ip}                   look up a lease
+//	POST   /leases/{ip}/release           release a lease
+//	GET    /failover/{name}               show a failover-state
+//	POST   /failover/{name}/partner-down  put a failover-state into partner-down state
+//	POST   /control/shutdown              shut the server down, if -allow-shutdown is set
+//	GET    /openapi.json                  the OpenAPI description of the above
+//
+// Request bodies are limited to 64 KiB.
+//
+// Errors are returned as {"error": "...", "code": N}, where code is the
+// OMAPI status code if the server returned one. Status codes map to
+// HTTP status codes: "not found" to 404, "already exists" to 409,
+// "permission denied" to 403 and so on.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// config is what main reads from flags and the environment.
+type config struct {
+	listen, server, keyName, key, token, auditPath string
+	poolSize                                       int
+	allowShutdown                                  bool
+}
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.listen, "listen", "127.0.0.1:8080", "`address` to serve the API on")
+	flag.StringVar(&cfg.server, "server", envOr("OMAPI_SERVER", "localhost:7911"), "OMAPI server `address`")
+	flag.StringVar(&cfg.keyName, "key-name", os.Getenv("OMAPI_KEY_NAME"), "`name` of the key to authenticate with")
+	flag.IntVar(&cfg.poolSize, "pool-size", 4, "`number` of connections to the OMAPI server")
+	flag.BoolVar(&cfg.allowShutdown, "allow-shutdown", false, "allow shutting the DHCP server down")
+	flag.StringVar(&cfg.auditPath, "audit-log", "", "`file` to append a record of every change to")
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg.key = os.Getenv("OMAPI_KEY")
+	cfg.token = os.Getenv("OMAPI_GATEWAY_TOKEN")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := run(ctx, cfg)
+	stop()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the API until ctx is done and the server has shut down.
+// It returns instead of exiting on errors, so that the pool and the
+// audit log are closed.
+func run(ctx context.Context, cfg config) error {
+	if cfg.token == "" {
+		return errors.New("OMAPI_GATEWAY_TOKEN must be set to the token clients authenticate with")
+	}
+
+	pool := omapi.NewPool(cfg.server, cfg.keyName, cfg.key, cfg.poolSize)
+	defer pool.Close()
+
+	if cfg.auditPath != "" {
+		auditLog, err := omapi.OpenAuditLog(cfg.auditPath)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+
+		auditor := &omapi.Auditor{
+			Sink:    auditLog,
+			OnError: func(err error) { log.Printf("Writing audit record failed: %s", err) },
+		}
+		pool.Dialer.Interceptors = append(pool.Dialer.Interceptors, auditor.Intercept)
+	}
+
+	api := &api{pool: pool, token: cfg.token, allowShutdown: cfg.allowShutdown}
+
+	s := &http.Server{Addr: cfg.listen, Handler: api.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	// Requests in flight when ctx is done get to finish, and run waits
+	// for them before closing the pool.
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		shutdown <- s.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving OMAPI server %s on %s", cfg.server, cfg.listen)
+	if err := s.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return <-shutdown
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return fallback
+}
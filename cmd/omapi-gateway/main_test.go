@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config
+	}{
+		{"no token", config{listen: "127.0.0.1:0"}},
+		{"bad audit log", config{listen: "127.0.0.1:0", token: "secret", auditPath: filepath.Join(t.TempDir(), "missing", "audit.log")}},
+		{"bad listen address", config{listen: "127.0.0.1:-1", token: "secret"}},
+	}
+
+	for _, tt := range tests {
+		if err := run(context.Background(), tt.cfg); err == nil {
+			t.Errorf("%s: run succeeded", tt.name)
+		}
+	}
+}
+
+func TestRunShutdown(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+	server.PutHost(omapi.Host{Name: "a"})
+
+	// Find a free port for the gateway.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := ln.Addr().String()
+	ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, config{listen: listen, server: server.Addr, token: "secret", poolSize: 1})
+	}()
+
+	// Wait for the gateway to listen.
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", listen)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A request that is in flight when the gateway is told to stop
+	// still gets its response, and run only returns afterwards.
+	server.SetLatency(200 * time.Millisecond)
+
+	req, err := http.NewRequest("GET", "http://"+listen+"/hosts/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+
+	responses := make(chan int, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			responses <- 0
+			return
+		}
+		resp.Body.Close()
+		responses <- resp.StatusCode
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		t.Fatalf("run returned %v while a request was in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if code := <-responses; code != http.StatusOK {
+		t.Errorf("in-flight request got status %d, want %d", code, http.StatusOK)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("run returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return after shutdown")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// config is what omapictl needs to connect to a server. It is read
+// from a config file, then the environment, then flags, with later
+// sources taking precedence.
+type config struct {
+	Server  string `json:"server"`
+	KeyName string `json:"key-name"`
+	Key     string `json:"key"` // base64 encoded secret
+}
+
+// defaultConfigPath returns the config file that is used if none is
+// given, which doesn't have to exist.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "omapictl", "config.json")
+}
+
+// loadConfig reads the config file at path. A missing file is only an
+// error if it was given explicitly.
+func loadConfig(path string, explicit bool) (config, error) {
+	cfg := config{Server: "localhost:7911"}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// applyEnv overrides the config with the OMAPI_SERVER, OMAPI_KEY_NAME
+// and OMAPI_KEY environment variables.
+func (cfg *config) applyEnv() {
+	if v := os.Getenv("OMAPI_SERVER"); v != "" {
+		cfg.Server = v
+	}
+	if v := os.Getenv("OMAPI_KEY_NAME"); v != "" {
+		cfg.KeyName = v
+	}
+	if v := os.Getenv("OMAPI_KEY"); v != "" {
+		cfg.Key = v
+	}
+}
+
+// applyFlags overrides the config with the flags that were set.
+func (cfg *config) applyFlags(flags *flag.FlagSet, server, keyName, key string) {
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server":
+			cfg.Server = server
+		case "key-name":
+			cfg.KeyName = keyName
+		case "key":
+			cfg.Key = key
+		}
+	})
+}
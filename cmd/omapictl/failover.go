@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+func failoverStatus(ctx context.Context, a *app, args []string) error {
+	if len(args) != 1 {
+		return usageErrorf("expected the name of a failover-state")
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	failover, err := conn.FindFailoverContext(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	return a.out.printFailover(failover)
+}
+
+func failoverPartnerDown(ctx context.Context, a *app, args []string) error {
+	if len(args) != 1 {
+		return usageErrorf("expected the name of a failover-state")
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	return conn.SetFailoverStateContext(ctx, args[0], omapi.FailoverStatePartnerDown)
+}
+
+func controlShutdown(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return usageErrorf("unexpected arguments")
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	return conn.ShutdownContext(ctx)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// hostFlags are the flags that describe a host, for looking it up or
+// creating it.
+type hostFlags struct {
+	name, mac, hwType, ip, clientID, statements string
+}
+
+func (f *hostFlags) register(flags *flag.FlagSet, create bool) {
+	flags.StringVar(&f.name, "name", "", "host `name`")
+	flags.StringVar(&f.mac, "mac", "", "hardware `address`")
+	flags.StringVar(&f.hwType, "hw-type", "ethernet", "hardware `type`: ethernet, token-ring or fddi")
+	flags.StringVar(&f.ip, "ip", "", "IP `address`")
+	if create {
+		flags.StringVar(&f.clientID, "client-id", "", "DHCP client `identifier`, as colon-separated hex or a string")
+		flags.StringVar(&f.statements, "statements", "", "host `statements`")
+	}
+}
+
+func (f *hostFlags) host() (omapi.Host, error) {
+	host := omapi.Host{Name: f.name, Statements: f.statements}
+
+	if f.mac != "" {
+		mac, err := net.ParseMAC(f.mac)
+		if err != nil {
+			return host, usageErrorf("invalid hardware address %q", f.mac)
+		}
+		host.HardwareAddress = mac
+
+		if host.HardwareType, err = parseHardwareType(f.hwType); err != nil {
+			return host, err
+		}
+	}
+
+	if f.ip != "" {
+		if host.IP = net.ParseIP(f.ip).To4(); host.IP == nil {
+			return host, usageErrorf("invalid IPv4 address %q", f.ip)
+		}
+	}
+
+	host.DHCPClientIdentifier = omapi.ParseIdentifier(f.clientID)
+
+	return host, nil
+}
+
+func parseHardwareType(s string) (omapi.HardwareType, error) {
+	hw, err := omapi.ParseHardwareType(s)
+	if err != nil {
+		return 0, usageErrorf("%v", err)
+	}
+
+	return hw, nil
+}
+
+// findHost parses the flags of a host lookup and finds the host.
+func findHost(ctx context.Context, a *app, name string, args []string) (*omapi.Connection, omapi.Host, error) {
+	var f hostFlags
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.register(flags, false)
+
+	if _, err := parseFlags(flags, args); err != nil {
+		return nil, omapi.Host{}, err
+	}
+
+	query, err := f.host()
+	if err != nil {
+		return nil, omapi.Host{}, err
+	}
+	if query.Name == "" && query.HardwareAddress == nil && query.IP == nil {
+		return nil, omapi.Host{}, usageErrorf("one of -name, -mac and -ip is required")
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return nil, omapi.Host{}, err
+	}
+
+	host, err := conn.FindHostContext(ctx, query)
+
+	return conn, host, err
+}
+
+func hostGet(ctx context.Context, a *app, args []string) error {
+	_, host, err := findHost(ctx, a, "host get", args)
+	if err != nil {
+		return err
+	}
+
+	return a.out.printHosts(host)
+}
+
+func hostCreate(ctx context.Context, a *app, args []string) error {
+	var f hostFlags
+	flags := flag.NewFlagSet("host create", flag.ContinueOnError)
+	f.register(flags, true)
+
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	host, err := f.host()
+	if err != nil {
+		return err
+	}
+	if host.Name == "" {
+		return usageErrorf("-name is required")
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	created, err := conn.CreateHostContext(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	return a.out.printHosts(created)
+}
+
+func hostDelete(ctx context.Context, a *app, args []string) error {
+	conn, host, err := findHost(ctx, a, "host delete", args)
+	if err != nil {
+		return err
+	}
+
+	return conn.DeleteContext(ctx, host.Handle)
+}
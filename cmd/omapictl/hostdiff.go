@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+type hostDiffView struct {
+	Host    string             `json:"host"`
+	Result  omapi.HostDiffKind `json:"result"`
+	A       *hostView          `json:"a,omitempty"`
+	B       *hostView          `json:"b,omitempty"`
+	Fields  []string           `json:"fields,omitempty"`
+	Error   string             `json:"error,omitempty"`
+	Repair  string             `json:"repair,omitempty"`
+	details string
+}
+
+func hostDiff(ctx context.Context, a *app, args []string) error {
+	var (
+		peer, peerKeyName, peerKey, hwType string
+		repair, all                        bool
+	)
+
+	flags := flag.NewFlagSet("host diff", flag.ContinueOnError)
+	flags.StringVar(&peer, "peer", "", "`address` of the server to compare with")
+	flags.StringVar(&peerKeyName, "peer-key-name", "", "`name` of the key for the peer (default the same as -key-name)")
+	flags.StringVar(&peerKey, "peer-key", "", "base64 encoded `secret` of the key for the peer (default the same as -key)")
+	flags.StringVar(&hwType, "hw-type", "ethernet", "hardware `type` of the given hardware addresses")
+	flags.BoolVar(&repair, "repair", false, "create hosts that exist on only one server on the other")
+	flags.BoolVar(&all, "all", false, "also list hosts that are the same on both servers")
+
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if peer == "" {
+		return usageErrorf("-peer is required")
+	}
+	if len(args) == 0 {
+		return usageErrorf("expected host names or hardware addresses")
+	}
+
+	hardwareType, err := parseHardwareType(hwType)
+	if err != nil {
+		return err
+	}
+
+	// Failover peers usually share a key.
+	if peerKeyName == "" && peerKey == "" {
+		peerKeyName, peerKey = a.cfg.KeyName, a.cfg.Key
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	peerConn, err := a.dial(ctx, peer, peerKeyName, peerKey)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", peer, err)
+	}
+	defer peerConn.Close()
+
+	diffs, err := omapi.CompareHosts(ctx, conn, peerConn, hostQueries(args, hardwareType))
+	if err != nil {
+		return err
+	}
+
+	var (
+		views    []hostDiffView
+		rows     [][]string
+		problems int
+	)
+	for i, diff := range diffs {
+		view := hostDiffView{Host: args[i], Result: diff.Kind, Fields: diff.Fields}
+
+		switch diff.Kind {
+		case omapi.HostSame:
+			if !all {
+				continue
+			}
+		case omapi.HostOnlyInA, omapi.HostOnlyInB:
+			if !repair {
+				problems++
+				break
+			}
+			if _, err := omapi.RepairHost(ctx, conn, peerConn, diff); err != nil {
+				view.Repair = "failed: " + err.Error()
+				problems++
+			} else {
+				view.Repair = "created"
+			}
+		case omapi.HostDiffers:
+			problems++
+			details := make([]string, len(diff.Fields))
+			for i, field := range diff.Fields {
+				details[i] = fmt.Sprintf("%s: %s != %s", field, hostField(diff.A, field), hostField(diff.B, field))
+			}
+			view.details = strings.Join(details, ", ")
+		case omapi.HostError:
+			problems++
+			view.Error = diff.Err.Error()
+			view.details = view.Error
+		case omapi.HostMissing:
+			// Both servers agree that the host doesn't exist.
+		}
+
+		if diff.Kind != omapi.HostMissing && diff.Kind != omapi.HostError {
+			if diff.A.Name != "" {
+				v := newHostView(diff.A)
+				view.A = &v
+			}
+			if diff.B.Name != "" {
+				v := newHostView(diff.B)
+				view.B = &v
+			}
+		}
+
+		if view.Repair != "" {
+			view.details = "repair " + view.Repair
+		}
+
+		views = append(views, view)
+		rows = append(rows, []string{view.Host, view.Result.String(), view.details})
+	}
+
+	if views == nil {
+		views = []hostDiffView{}
+	}
+	if err := a.out.print(views, []string{"HOST", "RESULT", "DETAILS"}, rows); err != nil {
+		return err
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d of %d hosts differ between %s and %s", problems, len(diffs), a.cfg.Server, peer)
+	}
+
+	return nil
+}
+
+// hostField returns a host's value for one of the fields that
+// CompareHosts reports.
+func hostField(host omapi.Host, field string) string {
+	view := newHostView(host)
+
+	var value string
+	switch field {
+	case "ip-address":
+		value = view.IP
+	case "hardware-address":
+		value = view.HardwareAddress
+	case "hardware-type":
+		value = view.HardwareType
+	case "dhcp-client-identifier":
+		value = view.DHCPClientIdentifier
+	}
+
+	if value == "" {
+		return "(none)"
+	}
+
+	return value
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestHostDiff(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	mac := func(s string) net.HardwareAddr {
+		addr, err := net.ParseMAC(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+	same := omapi.Host{Name: "same", HardwareAddress: mac("00:11:22:33:44:01"), HardwareType: omapi.Ethernet}
+	onlyA := omapi.Host{Name: "only-a", HardwareAddress: mac("00:11:22:33:44:02"), HardwareType: omapi.Ethernet}
+	differs := omapi.Host{Name: "differs", HardwareAddress: mac("00:11:22:33:44:03"), HardwareType: omapi.Ethernet}
+	differsB := differs
+	differsB.IP = net.ParseIP("10.0.0.3")
+
+	tests := []struct {
+		name    string
+		flags   []string
+		hosts   []string
+		code    int
+		results map[string]string
+	}{
+		{
+			// Hosts that are on neither server aren't a difference.
+			name:    "agree",
+			flags:   []string{"-all"},
+			hosts:   []string{"same", "missing"},
+			results: map[string]string{"same": "same", "missing": "missing"},
+		},
+		{
+			name:    "same hosts not listed",
+			hosts:   []string{"same", "missing"},
+			results: map[string]string{"missing": "missing"},
+		},
+		{
+			name:    "only in a",
+			hosts:   []string{"same", "only-a", "00:11:22:33:44:02"},
+			code:    exitFailure,
+			results: map[string]string{"only-a": "only-in-a", "00:11:22:33:44:02": "only-in-a"},
+		},
+		{
+			name:    "differs",
+			hosts:   []string{"differs", "missing"},
+			code:    exitFailure,
+			results: map[string]string{"differs": "differs", "missing": "missing"},
+		},
+		{
+			name:    "repair",
+			flags:   []string{"-repair"},
+			hosts:   []string{"only-a", "missing"},
+			results: map[string]string{"only-a": "only-in-a, repair created", "missing": "missing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := omapitest.NewServer(), omapitest.NewServer()
+			defer a.Close()
+			defer b.Close()
+
+			a.PutHost(same)
+			a.PutHost(onlyA)
+			a.PutHost(differs)
+			b.PutHost(same)
+			b.PutHost(differsB)
+
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"-server", a.Addr, "-o", "json", "host", "diff", "-peer", b.Addr}, tt.flags...)
+			code := run(context.Background(), append(args, tt.hosts...), &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("exit status %d, want %d: %s", code, tt.code, stderr.String())
+			}
+
+			var views []struct {
+				Host, Result, Repair string
+			}
+			if err := json.Unmarshal(stdout.Bytes(), &views); err != nil {
+				t.Fatalf("%v: %s", err, stdout.String())
+			}
+			results := make(map[string]string)
+			for _, view := range views {
+				results[view.Host] = view.Result
+				if view.Repair != "" {
+					results[view.Host] += ", repair " + view.Repair
+				}
+			}
+			if len(results) != len(tt.results) {
+				t.Errorf("results = %v, want %v", results, tt.results)
+			}
+			for host, want := range tt.results {
+				if results[host] != want {
+					t.Errorf("result of %s = %q, want %q", host, results[host], want)
+				}
+			}
+
+			if len(tt.flags) > 0 && tt.flags[0] == "-repair" && len(b.Hosts()) != 3 {
+				t.Errorf("%d hosts on the peer after the repair, want 3", len(b.Hosts()))
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// hostRecord is a host in an import or export file. CSV files have a
+// header line with the same names as the JSON fields, in any order;
+// only name is required.
+type hostRecord struct {
+	Name                 string `json:"name"`
+	HardwareAddress      string `json:"hardware-address,omitempty"`
+	HardwareType         string `json:"hardware-type,omitempty"`
+	IP                   string `json:"ip-address,omitempty"`
+	DHCPClientIdentifier string `json:"dhcp-client-identifier,omitempty"`
+	Statements           string `json:"statements,omitempty"`
+}
+
+var hostRecordFields = []string{"name", "hardware-address", "hardware-type", "ip-address", "dhcp-client-identifier", "statements"}
+
+func newHostRecord(host omapi.Host) hostRecord {
+	record := hostRecord{
+		Name:                 host.Name,
+		DHCPClientIdentifier: omapi.FormatIdentifier(host.DHCPClientIdentifier),
+		Statements:           host.Statements,
+	}
+	if host.HardwareAddress != nil {
+		record.HardwareAddress = host.HardwareAddress.String()
+		record.HardwareType = host.HardwareType.Name()
+	}
+	if len(host.IP) > 0 {
+		record.IP = host.IP.String()
+	}
+
+	return record
+}
+
+func (r hostRecord) fields() []string {
+	return []string{r.Name, r.HardwareAddress, r.HardwareType, r.IP, r.DHCPClientIdentifier, r.Statements}
+}
+
+func (r hostRecord) host() (omapi.Host, error) {
+	if r.Name == "" {
+		return omapi.Host{}, errors.New("missing name")
+	}
+
+	f := hostFlags{name: r.Name, mac: r.HardwareAddress, hwType: r.HardwareType, ip: r.IP, clientID: r.DHCPClientIdentifier, statements: r.Statements}
+	if f.hwType == "" {
+		f.hwType = "ethernet"
+	}
+
+	return f.host()
+}
+
+// fileFormat returns the format of a host file: the format flag if
+// given, else the file's extension.
+func fileFormat(format, path string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+
+	switch format {
+	case "csv", "json":
+		return format, nil
+	case "":
+		return "", usageErrorf("-format is required when reading standard input")
+	}
+
+	return "", usageErrorf("unknown format %q, expected csv or json", format)
+}
+
+// readHostRecords reads host records in the given format.
+func readHostRecords(r io.Reader, format string) ([]hostRecord, error) {
+	if format == "json" {
+		var records []hostRecord
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, fmt.Errorf("parsing JSON: %w", err)
+		}
+		return records, nil
+	}
+
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("CSV header has no name column")
+	}
+
+	var records []hostRecord
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+
+		records = append(records, hostRecord{
+			Name:                 field("name"),
+			HardwareAddress:      field("hardware-address"),
+			HardwareType:         field("hardware-type"),
+			IP:                   field("ip-address"),
+			DHCPClientIdentifier: field("dhcp-client-identifier"),
+			Statements:           field("statements"),
+		})
+	}
+}
+
+// writeHostRecords writes host records in the given format.
+func writeHostRecords(w io.Writer, format string, records []hostRecord) error {
+	if format == "json" {
+		if records == nil {
+			records = []hostRecord{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(hostRecordFields)
+	for _, record := range records {
+		cw.Write(record.fields())
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+type importView struct {
+	Row     int                 `json:"row"`
+	Name    string              `json:"name"`
+	Outcome omapi.ImportOutcome `json:"result"`
+	Error   string              `json:"error,omitempty"`
+}
+
+func hostImport(ctx context.Context, a *app, args []string) error {
+	var (
+		format      string
+		concurrency int
+		overwrite   bool
+	)
+
+	flags := flag.NewFlagSet("host import", flag.ContinueOnError)
+	flags.StringVar(&format, "format", "", "file `format`: csv or json (default from the file extension)")
+	flags.IntVar(&concurrency, "concurrency", 4, "`number` of hosts created at the same time")
+	flags.BoolVar(&overwrite, "overwrite", false, "replace hosts that already exist instead of skipping them")
+
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usageErrorf("expected a single file")
+	}
+
+	if format, err = fileFormat(format, args[0]); err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	records, err := readHostRecords(in, format)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	// Rows are numbered like in the file: the first host of a CSV
+	// file is on line 2, the first host of a JSON file is element 1.
+	first := 1
+	if format == "csv" {
+		first = 2
+	}
+
+	hosts := make([]omapi.Host, len(records))
+	for i, record := range records {
+		if hosts[i], err = record.host(); err != nil {
+			// Not a usage error, the file is wrong.
+			return fmt.Errorf("%s: row %d: %s", args[0], first+i, err)
+		}
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	results := omapi.ImportHosts(ctx, conn, hosts, omapi.ImportOptions{Concurrency: concurrency, Overwrite: overwrite})
+
+	views := make([]importView, len(results))
+	rows := make([][]string, len(results))
+	failed := 0
+	for i, result := range results {
+		views[i] = importView{Row: first + result.Index, Name: hosts[result.Index].Name, Outcome: result.Outcome}
+		if result.Err != nil {
+			views[i].Error = result.Err.Error()
+			failed++
+		}
+		rows[i] = []string{strconv.Itoa(views[i].Row), views[i].Name, result.Outcome.String(), views[i].Error}
+	}
+
+	if err := a.out.print(views, []string{"ROW", "NAME", "RESULT", "ERROR"}, rows); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d hosts failed", failed, len(results))
+	}
+
+	return nil
+}
+
+func hostExport(ctx context.Context, a *app, args []string) error {
+	var (
+		format string
+		hwType string
+	)
+
+	flags := flag.NewFlagSet("host export", flag.ContinueOnError)
+	flags.StringVar(&format, "format", "csv", "output `format`: csv or json")
+	flags.StringVar(&hwType, "hw-type", "ethernet", "hardware `type` of the given hardware addresses")
+
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usageErrorf("expected host names or hardware addresses")
+	}
+	if format != "csv" && format != "json" {
+		return usageErrorf("unknown format %q, expected csv or json", format)
+	}
+
+	hardwareType, err := parseHardwareType(hwType)
+	if err != nil {
+		return err
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		records []hostRecord
+		lastErr error
+	)
+	for i, query := range hostQueries(args, hardwareType) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		host, err := conn.FindHostContext(ctx, query)
+		if err != nil {
+			fmt.Fprintf(a.stderr, "%s: %s\n", args[i], err)
+			lastErr = err
+			continue
+		}
+
+		records = append(records, newHostRecord(host))
+	}
+
+	if err := writeHostRecords(a.out.w, format, records); err != nil {
+		return err
+	}
+
+	return lastErr
+}
+
+// hostQueries turns arguments into host lookups. Anything that parses
+// as a hardware address is one, anything else is a name.
+func hostQueries(args []string, hardwareType omapi.HardwareType) []omapi.Host {
+	queries := make([]omapi.Host, len(args))
+	for i, arg := range args {
+		queries[i] = omapi.Host{Name: arg}
+		if mac, err := net.ParseMAC(arg); err == nil {
+			queries[i] = omapi.Host{HardwareAddress: mac, HardwareType: hardwareType}
+		}
+	}
+
+	return queries
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// leaseQuery parses the flags of a lease lookup.
+func leaseQuery(name string, args []string) (omapi.Lease, error) {
+	var (
+		lease                   omapi.Lease
+		ip, mac, hwType, client string
+	)
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&ip, "ip", "", "IP `address`")
+	flags.StringVar(&mac, "mac", "", "hardware `address`")
+	flags.StringVar(&hwType, "hw-type", "ethernet", "hardware `type`: ethernet, token-ring or fddi")
+	flags.StringVar(&client, "client-id", "", "DHCP client `identifier`, as colon-separated hex or a string")
+
+	if _, err := parseFlags(flags, args); err != nil {
+		return lease, err
+	}
+
+	if ip != "" {
+		if lease.IP = net.ParseIP(ip).To4(); lease.IP == nil {
+			return lease, usageErrorf("invalid IPv4 address %q", ip)
+		}
+	}
+
+	if mac != "" {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return lease, usageErrorf("invalid hardware address %q", mac)
+		}
+		lease.HardwareAddress = hw
+
+		if lease.HardwareType, err = parseHardwareType(hwType); err != nil {
+			return lease, err
+		}
+	}
+
+	lease.DHCPClientIdentifier = omapi.ParseIdentifier(client)
+
+	if lease.IP == nil && lease.HardwareAddress == nil && lease.DHCPClientIdentifier == nil {
+		return lease, usageErrorf("one of -ip, -mac and -client-id is required")
+	}
+
+	return lease, nil
+}
+
+func leaseGet(ctx context.Context, a *app, args []string) error {
+	query, err := leaseQuery("lease get", args)
+	if err != nil {
+		return err
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	lease, err := conn.FindLeaseContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return a.out.printLease(lease)
+}
+
+func leaseRelease(ctx context.Context, a *app, args []string) error {
+	query, err := leaseQuery("lease release", args)
+	if err != nil {
+		return err
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	return conn.ReleaseLeaseContext(ctx, query)
+}
+
+func leaseScan(ctx context.Context, a *app, args []string) error {
+	var (
+		concurrency int
+		progress    bool
+	)
+
+	flags := flag.NewFlagSet("lease scan", flag.ContinueOnError)
+	flags.IntVar(&concurrency, "concurrency", 4, "`number` of lookups in flight")
+	flags.BoolVar(&progress, "progress", false, "report progress on standard error")
+
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usageErrorf("expected a single prefix")
+	}
+
+	prefix, err := netip.ParsePrefix(args[0])
+	if err != nil {
+		return usageErrorf("invalid prefix %q", args[0])
+	}
+
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	opts := omapi.ScanOptions{Concurrency: concurrency}
+	if progress {
+		opts.Progress = func(done, total int) {
+			if done%256 == 0 || done == total {
+				fmt.Fprintf(a.stderr, "scanned %d/%d addresses\n", done, total)
+			}
+		}
+	}
+
+	results, err := conn.ScanLeases(ctx, prefix, opts)
+	if err != nil {
+		return usageErrorf("%s", err)
+	}
+
+	var (
+		views   []leaseView
+		rows    [][]string
+		lastErr error
+	)
+	for result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(a.stderr, "%s: %s\n", result.Addr, result.Err)
+			lastErr = result.Err
+			continue
+		}
+
+		view := newLeaseView(result.Lease)
+		views = append(views, view)
+		rows = append(rows, view.row())
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if views == nil {
+		views = []leaseView{}
+	}
+	if err := a.out.print(views, leaseHeader, rows); err != nil {
+		return err
+	}
+
+	return lastErr
+}
@@ -0,0 +1,270 @@
+// Command omapictl queries and modifies an ISC DHCP server over OMAPI.
+// Unlike omshell, it is meant to be used from scripts.
+//
+// Usage:
+//
+//	omapictl [flags] <command> [<subcommand>] [flags] [arguments]
+//
+// The commands are:
+//
+//	host get         look up a host by name, hardware address or IP
+//	host create      create a host
+//	host delete      delete a host
+//	host import      create hosts from a CSV or JSON file
+//	host export      write hosts as CSV or JSON
+//	host diff        compare hosts between two servers, such as failover peers
+//	lease get        look up a lease by IP, hardware address or client identifier
+//	lease release    release a lease
+//	lease scan       look up the leases of all addresses in a prefix
+//	failover status  show a failover-state
+//	failover partner-down
+//	                 put a failover-state into partner-down state
+//	control shutdown shut the server down
+//	shell            run omshell commands interactively or from a script
+//
+// The server and key are read from a JSON config file with the fields
+// "server", "key-name" and "key", then from the OMAPI_SERVER,
+// OMAPI_KEY_NAME and OMAPI_KEY environment variables, then from flags.
+// The key is the base64 encoded secret, as in dhcpd.conf.
+//
+// Results are printed as a table or, with -o json, as JSON.
+//
+// The -timeout flag bounds connecting and every request to the server,
+// 30 seconds by default; 0 disables it. An interrupt cancels whatever
+// is in flight.
+//
+// The exit status is 0 on success and the OMAPI status code if the
+// server returned an error, such as 23 for "not found" or 18 for
+// "already exists". Usage errors exit with 100, other errors with 101.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+const (
+	exitUsage   = 100
+	exitFailure = 101
+)
+
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, app *app, args []string) error
+}
+
+var commands = map[string]command{
+	"host get":              {"-name NAME | -mac MAC [-hw-type TYPE] | -ip IP", hostGet},
+	"host create":           {"-name NAME [-mac MAC] [-hw-type TYPE] [-ip IP] [-client-id ID] [-statements STATEMENTS]", hostCreate},
+	"host delete":           {"-name NAME | -mac MAC [-hw-type TYPE] | -ip IP", hostDelete},
+	"host import":           {"[-format csv|json] [-concurrency N] [-overwrite] FILE", hostImport},
+	"host export":           {"[-format csv|json] [-hw-type TYPE] NAME|MAC...", hostExport},
+	"host diff":             {"-peer ADDRESS [-peer-key-name NAME -peer-key KEY] [-hw-type TYPE] [-repair] [-all] NAME|MAC...", hostDiff},
+	"lease get":             {"-ip IP | -mac MAC [-hw-type TYPE] | -client-id ID", leaseGet},
+	"lease release":         {"-ip IP | -mac MAC [-hw-type TYPE] | -client-id ID", leaseRelease},
+	"lease scan":            {"[-concurrency N] [-progress] PREFIX", leaseScan},
+	"failover status":       {"NAME", failoverStatus},
+	"failover partner-down": {"NAME", failoverPartnerDown},
+	"control shutdown":      {"", controlShutdown},
+	"shell":                 {"[SCRIPT]", shellCommand},
+}
+
+// app holds what commands share: the configuration, the output, the
+// timeout and the connection, which is established on first use.
+type app struct {
+	cfg     config
+	out     *printer
+	stderr  io.Writer
+	timeout time.Duration
+	conn    *omapi.Connection
+}
+
+func (a *app) connect(ctx context.Context) (*omapi.Connection, error) {
+	if a.conn != nil {
+		return a.conn, nil
+	}
+
+	conn, err := a.dial(ctx, a.cfg.Server, a.cfg.KeyName, a.cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", a.cfg.Server, err)
+	}
+	a.conn = conn
+
+	return conn, nil
+}
+
+// dial connects to a server. With a timeout, it bounds the dial and
+// every query on the connection.
+func (a *app) dial(ctx context.Context, addr, keyName, key string) (*omapi.Connection, error) {
+	var d omapi.Dialer
+
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+
+		d.Interceptors = []omapi.Interceptor{a.queryTimeout}
+	}
+
+	return d.DialContext(ctx, addr, keyName, key)
+}
+
+// queryTimeout is an interceptor that bounds a query by the timeout.
+func (a *app) queryTimeout(ctx context.Context, msg *omapi.Message, _ *omapi.Connection, invoke omapi.Invoker) (*omapi.Message, omapi.Status) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	return invoke(ctx, msg)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var (
+		cfgPath, server, keyName, key, output string
+		timeout                               time.Duration
+	)
+
+	flags := flag.NewFlagSet("omapictl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&cfgPath, "config", defaultConfigPath(), "config `file`")
+	flags.StringVar(&server, "server", "", "server `address` (default localhost:7911)")
+	flags.StringVar(&keyName, "key-name", "", "`name` of the key to authenticate with")
+	flags.StringVar(&key, "key", "", "base64 encoded `secret` of the key")
+	flags.StringVar(&output, "o", "table", "output `format`, table or json")
+	flags.DurationVar(&timeout, "timeout", 30*time.Second, "`duration` to wait for connecting and for each request, 0 for no limit")
+	flags.Usage = func() { usage(flags) }
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	explicitConfig := false
+	flags.Visit(func(f *flag.Flag) { explicitConfig = explicitConfig || f.Name == "config" })
+
+	cfg, err := loadConfig(cfgPath, explicitConfig)
+	if err != nil {
+		fmt.Fprintf(stderr, "omapictl: reading config: %s\n", err)
+		return exitFailure
+	}
+	cfg.applyEnv()
+	cfg.applyFlags(flags, server, keyName, key)
+
+	if output != "table" && output != "json" {
+		fmt.Fprintf(stderr, "omapictl: unknown output format %q\n", output)
+		return exitUsage
+	}
+
+	// Commands are either a single word or a command and a
+	// subcommand.
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	cmdArgs := flags.Args()[min(1, flags.NArg()):]
+	if !ok && flags.NArg() >= 2 {
+		name = flags.Arg(0) + " " + flags.Arg(1)
+		cmd, ok = commands[name]
+		cmdArgs = flags.Args()[2:]
+	}
+	if !ok {
+		if name != "" {
+			fmt.Fprintf(stderr, "omapictl: unknown command %q\n", name)
+		}
+		usage(flags)
+		return exitUsage
+	}
+
+	a := &app{cfg: cfg, out: &printer{format: output, w: stdout}, stderr: stderr, timeout: timeout}
+	defer func() {
+		if a.conn != nil {
+			a.conn.Close()
+		}
+	}()
+
+	err = cmd.run(ctx, a, cmdArgs)
+	if err != nil {
+		fmt.Fprintf(stderr, "omapictl %s: %s\n", name, err)
+
+		var usageErr *usageError
+		if errors.As(err, &usageErr) {
+			fmt.Fprintf(stderr, "usage: omapictl %s %s\n", name, cmd.usage)
+		}
+	}
+
+	return exitCode(err)
+}
+
+func usage(flags *flag.FlagSet) {
+	out := flags.Output()
+
+	fmt.Fprintf(out, "usage: omapictl [flags] <command> [<subcommand>] [flags] [arguments]\n\ncommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s %s\n", name, commands[name].usage)
+	}
+
+	fmt.Fprintf(out, "\nflags:\n")
+	flags.PrintDefaults()
+}
+
+// exitCode maps an error to the exit status: OMAPI statuses exit with
+// their code.
+func exitCode(err error) int {
+	var (
+		status   omapi.Status
+		usageErr *usageError
+	)
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &status) && status.IsError():
+		return int(status.Code)
+	}
+
+	return exitFailure
+}
+
+// parseFlags parses the flags of a subcommand and returns the
+// remaining arguments.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return nil, usageErrorf("%s", err)
+	}
+
+	return flags.Args(), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestTimeout(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	// A server that accepts connections but never sends its startup
+	// message.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	slow := omapitest.NewServer()
+	defer slow.Close()
+	slow.SetLatency(time.Second)
+
+	tests := []struct {
+		name   string
+		server string
+	}{
+		{"dial", ln.Addr().String()},
+		{"query", slow.Addr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			start := time.Now()
+			code := run(context.Background(), []string{"-server", test.server, "-timeout", "100ms", "host", "get", "-name", "printer"}, &stdout, &stderr)
+			if code == 0 {
+				t.Fatalf("exit status 0, want an error")
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("took %v with a timeout of 100ms", elapsed)
+			}
+			if !strings.Contains(stderr.String(), "omapictl host get:") {
+				t.Errorf("stderr = %q", stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// printer prints results either as JSON or as a table.
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+// print prints v as JSON, or header and rows as a table.
+func (p *printer) print(v any, header []string, rows [][]string) error {
+	if p.format == "json" {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	if header != nil {
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+type hostView struct {
+	Name                 string `json:"name"`
+	HardwareAddress      string `json:"hardware-address,omitempty"`
+	HardwareType         string `json:"hardware-type,omitempty"`
+	IP                   string `json:"ip-address,omitempty"`
+	DHCPClientIdentifier string `json:"dhcp-client-identifier,omitempty"`
+	Handle               int32  `json:"handle"`
+}
+
+var hostHeader = []string{"NAME", "HARDWARE-ADDRESS", "HARDWARE-TYPE", "IP-ADDRESS", "CLIENT-ID"}
+
+func newHostView(host omapi.Host) hostView {
+	view := hostView{
+		Name:                 host.Name,
+		HardwareAddress:      host.HardwareAddress.String(),
+		HardwareType:         host.HardwareType.String(),
+		DHCPClientIdentifier: omapi.FormatIdentifier(host.DHCPClientIdentifier),
+		Handle:               host.Handle,
+	}
+	if len(host.IP) > 0 {
+		view.IP = host.IP.String()
+	}
+
+	return view
+}
+
+func (v hostView) row() []string {
+	return []string{v.Name, v.HardwareAddress, v.HardwareType, v.IP, v.DHCPClientIdentifier}
+}
+
+func (p *printer) printHosts(hosts ...omapi.Host) error {
+	views := make([]hostView, len(hosts))
+	rows := make([][]string, len(hosts))
+	for i, host := range hosts {
+		views[i] = newHostView(host)
+		rows[i] = views[i].row()
+	}
+
+	if len(hosts) == 1 {
+		return p.print(views[0], hostHeader, rows)
+	}
+
+	return p.print(views, hostHeader, rows)
+}
+
+type leaseView struct {
+	IP                   string `json:"ip-address"`
+	State                string `json:"state"`
+	HardwareAddress      string `json:"hardware-address,omitempty"`
+	HardwareType         string `json:"hardware-type,omitempty"`
+	DHCPClientIdentifier string `json:"dhcp-client-identifier,omitempty"`
+	ClientHostname       string `json:"client-hostname,omitempty"`
+	Starts               string `json:"starts,omitempty"`
+	Ends                 string `json:"ends,omitempty"`
+	Cltt                 string `json:"cltt,omitempty"`
+	Handle               int32  `json:"handle"`
+}
+
+var leaseHeader = []string{"IP-ADDRESS", "STATE", "HARDWARE-ADDRESS", "CLIENT-ID", "CLIENT-HOSTNAME", "STARTS", "ENDS"}
+
+func newLeaseView(lease omapi.Lease) leaseView {
+	return leaseView{
+		IP:                   lease.IP.String(),
+		State:                lease.State.String(),
+		HardwareAddress:      lease.HardwareAddress.String(),
+		HardwareType:         lease.HardwareType.String(),
+		DHCPClientIdentifier: omapi.FormatIdentifier(lease.DHCPClientIdentifier),
+		ClientHostname:       lease.ClientHostname,
+		Starts:               omapi.FormatTime(lease.Starts),
+		Ends:                 omapi.FormatTime(lease.Ends),
+		Cltt:                 omapi.FormatTime(lease.Cltt),
+		Handle:               lease.Handle,
+	}
+}
+
+func (v leaseView) row() []string {
+	return []string{v.IP, v.State, v.HardwareAddress, v.DHCPClientIdentifier, v.ClientHostname, v.Starts, v.Ends}
+}
+
+func (p *printer) printLease(lease omapi.Lease) error {
+	view := newLeaseView(lease)
+
+	return p.print(view, leaseHeader, [][]string{view.row()})
+}
+
+type failoverView struct {
+	Name                  string `json:"name"`
+	Hierarchy             string `json:"hierarchy"`
+	LocalState            string `json:"local-state"`
+	PartnerState          string `json:"partner-state"`
+	LocalAddress          string `json:"local-address"`
+	PartnerAddress        string `json:"partner-address"`
+	LocalStos             string `json:"local-stos,omitempty"`
+	PartnerStos           string `json:"partner-stos,omitempty"`
+	Mclt                  int32  `json:"mclt"`
+	Skew                  int32  `json:"skew"`
+	CurUnackedUpdates     int32  `json:"cur-unacked-updates"`
+	MaxOutstandingUpdates int32  `json:"max-outstanding-updates"`
+	LastPacketSent        string `json:"last-packet-sent,omitempty"`
+	LastTimestampReceived string `json:"last-timestamp-received,omitempty"`
+}
+
+func (p *printer) printFailover(failover omapi.Failover) error {
+	view := failoverView{
+		Name:                  failover.Name,
+		Hierarchy:             failover.Hierarchy.String(),
+		LocalState:            failover.LocalState.String(),
+		PartnerState:          failover.PartnerState.String(),
+		LocalAddress:          omapi.FormatAddress(failover.LocalAddress, failover.LocalPort),
+		PartnerAddress:        omapi.FormatAddress(failover.PartnerAddress, failover.PartnerPort),
+		LocalStos:             omapi.FormatTime(failover.LocalStos),
+		PartnerStos:           omapi.FormatTime(failover.PartnerStos),
+		Mclt:                  failover.Mclt,
+		Skew:                  failover.Skew,
+		CurUnackedUpdates:     failover.CurUnackedUpdates,
+		MaxOutstandingUpdates: failover.MaxOutstandingUpdates,
+		LastPacketSent:        omapi.FormatTime(failover.LastPacketSent),
+		LastTimestampReceived: omapi.FormatTime(failover.LastTimestampReceived),
+	}
+
+	rows := [][]string{
+		{"name", view.Name},
+		{"hierarchy", view.Hierarchy},
+		{"local-state", view.LocalState},
+		{"partner-state", view.PartnerState},
+		{"local-address", view.LocalAddress},
+		{"partner-address", view.PartnerAddress},
+		{"local-stos", view.LocalStos},
+		{"partner-stos", view.PartnerStos},
+		{"mclt", fmt.Sprint(view.Mclt)},
+		{"skew", fmt.Sprint(view.Skew)},
+		{"cur-unacked-updates", fmt.Sprint(view.CurUnackedUpdates)},
+		{"max-outstanding-updates", fmt.Sprint(view.MaxOutstandingUpdates)},
+		{"last-packet-sent", view.LastPacketSent},
+		{"last-timestamp-received", view.LastTimestampReceived},
+	}
+
+	return p.print(view, nil, rows)
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// shell runs omshell commands, either interactively or from a script.
+// It understands the same commands and value syntax as omshell:
+//
+//	server 10.0.0.1
+//	port 7911
+//	key omapi_key "c2VjcmV0"
+//	connect
+//	new host
+//	set name = "foo"
+//	set hardware-address = 00:11:22:33:44:55
+//	set hardware-type = 1
+//	set ip-address = 10.0.0.5
+//	create
+//	remove
+//	close
+type shell struct {
+	app         *app
+	interactive bool
+	obj         *shellObject
+}
+
+// shellObject is the object being worked on, as created by "new".
+type shellObject struct {
+	typeName string
+	handle   int32
+	values   map[string][]byte
+	changed  map[string][]byte // values set since the last open or refresh
+}
+
+func shellCommand(ctx context.Context, a *app, args []string) error {
+	if len(args) > 1 {
+		return usageErrorf("expected at most one script")
+	}
+
+	var (
+		in          io.Reader = os.Stdin
+		interactive bool
+	)
+
+	if len(args) == 1 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	} else if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		interactive = true
+	}
+
+	sh := &shell{app: a, interactive: interactive}
+
+	return sh.run(ctx, in)
+}
+
+func (sh *shell) run(ctx context.Context, in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+
+	for line := 1; ; line++ {
+		if sh.interactive {
+			fmt.Fprint(sh.app.out.w, "> ")
+		}
+
+		if !scanner.Scan() {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := sh.execute(ctx, scanner.Text())
+		switch {
+		case err == nil:
+		case sh.interactive:
+			fmt.Fprintf(sh.app.stderr, "error: %s\n", err)
+		default:
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (sh *shell) execute(ctx context.Context, line string) error {
+	words, err := splitShellLine(line)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	cmd, args := words[0], words[1:]
+	switch cmd {
+	case "server":
+		return sh.setServer(args, func(host, port string, arg string) string { return net.JoinHostPort(arg, port) })
+	case "port":
+		return sh.setServer(args, func(host, port string, arg string) string { return net.JoinHostPort(host, arg) })
+	case "key":
+		if len(args) != 2 {
+			return errors.New("usage: key <name> <secret>")
+		}
+		sh.app.cfg.KeyName, sh.app.cfg.Key = unquote(args[0]), unquote(args[1])
+		return nil
+	case "key-algorithm":
+		if len(args) != 1 || !strings.EqualFold(args[0], "hmac-md5") {
+			return errors.New("only hmac-md5 is supported")
+		}
+		return nil
+	case "connect":
+		if len(args) != 0 {
+			return errors.New("usage: connect")
+		}
+		return sh.connect(ctx)
+	case "close":
+		if len(args) != 0 {
+			return errors.New("usage: close")
+		}
+		return sh.close()
+	case "new":
+		if len(args) != 1 {
+			return errors.New("usage: new <type>")
+		}
+		sh.obj = &shellObject{typeName: unquote(args[0]), values: make(map[string][]byte), changed: make(map[string][]byte)}
+		return nil
+	case "set":
+		if len(args) != 3 || args[1] != "=" {
+			return errors.New("usage: set <name> = <value>")
+		}
+		if sh.obj == nil {
+			return errors.New("no object, use new first")
+		}
+		value, err := parseShellValue(args[2])
+		if err != nil {
+			return err
+		}
+		name := unquote(args[0])
+		sh.obj.values[name] = value
+		sh.obj.changed[name] = value
+		return nil
+	case "unset":
+		if len(args) != 1 {
+			return errors.New("usage: unset <name>")
+		}
+		if sh.obj == nil {
+			return errors.New("no object, use new first")
+		}
+		name := unquote(args[0])
+		delete(sh.obj.values, name)
+		delete(sh.obj.changed, name)
+		return nil
+	case "open", "create", "refresh", "update", "remove":
+		if len(args) != 0 {
+			return fmt.Errorf("usage: %s", cmd)
+		}
+		if sh.obj == nil {
+			return errors.New("no object, use new first")
+		}
+		return sh.objectCommand(ctx, cmd)
+	}
+
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+func (sh *shell) setServer(args []string, combine func(host, port, arg string) string) error {
+	if len(args) != 1 {
+		return errors.New("expected a single argument")
+	}
+
+	host, port, err := net.SplitHostPort(sh.app.cfg.Server)
+	if err != nil {
+		host, port = sh.app.cfg.Server, strconv.Itoa(omapi.DefaultPort)
+	}
+	sh.app.cfg.Server = combine(host, port, unquote(args[0]))
+
+	return nil
+}
+
+// connect (re)connects using the current server and key.
+func (sh *shell) connect(ctx context.Context) error {
+	if sh.app.conn != nil {
+		sh.app.conn.Close()
+		sh.app.conn = nil
+	}
+
+	_, err := sh.app.connect(ctx)
+
+	return err
+}
+
+// close closes the connection. The object is kept, but its handle is
+// only valid on the connection it was opened on.
+func (sh *shell) close() error {
+	if sh.app.conn == nil {
+		return errors.New("not connected")
+	}
+
+	err := sh.app.conn.Close()
+	sh.app.conn = nil
+	if sh.obj != nil {
+		sh.obj.handle = 0
+	}
+
+	return err
+}
+
+func (sh *shell) objectCommand(ctx context.Context, cmd string) error {
+	conn, err := sh.app.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	obj := sh.obj
+	if cmd != "open" && cmd != "create" && obj.handle == 0 {
+		return errors.New("object has not been opened or created")
+	}
+
+	var response *omapi.Message
+
+	switch cmd {
+	case "open":
+		response, err = conn.OpenObjectContext(ctx, obj.typeName, obj.values)
+	case "create":
+		response, err = conn.CreateObjectContext(ctx, obj.typeName, obj.values)
+	case "refresh":
+		response, err = conn.RefreshObjectContext(ctx, obj.handle)
+	case "update":
+		if err = conn.UpdateContext(ctx, obj.handle, obj.changed); err == nil {
+			response, err = conn.RefreshObjectContext(ctx, obj.handle)
+		}
+	case "remove":
+		if err = conn.DeleteContext(ctx, obj.handle); err == nil {
+			obj.handle = 0
+		}
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	obj.handle = response.Handle
+	obj.values = response.Object
+	obj.changed = make(map[string][]byte)
+
+	return sh.print()
+}
+
+// print prints the object like omshell does, or as JSON.
+func (sh *shell) print() error {
+	obj := sh.obj
+
+	keys := make([]string, 0, len(obj.values))
+	for key := range obj.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if sh.app.out.format == "json" {
+		values := make(map[string]string)
+		for _, key := range keys {
+			if value := obj.values[key]; isPrintable(value) {
+				values[key] = string(value)
+			} else {
+				values[key] = omapi.FormatIdentifier(value)
+			}
+		}
+
+		return json.NewEncoder(sh.app.out.w).Encode(struct {
+			Type   string            `json:"type"`
+			Handle int32             `json:"handle"`
+			Values map[string]string `json:"values"`
+		}{obj.typeName, obj.handle, values})
+	}
+
+	fmt.Fprintf(sh.app.out.w, "obj: %s\n", obj.typeName)
+	for _, key := range keys {
+		fmt.Fprintf(sh.app.out.w, "%s = %s\n", key, formatShellValue(obj.values[key]))
+	}
+
+	return nil
+}
+
+// splitShellLine splits a line into words and quoted strings, dropping
+// comments. In set commands, equal signs are words of their own even
+// without spaces around them, as in "set name=value"; elsewhere they
+// are part of words, such as the padding of base64 keys.
+func splitShellLine(line string) ([]string, error) {
+	var words []string
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		isSet := len(words) > 0 && words[0] == "set"
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			return words, nil
+		case c == '=' && isSet:
+			words = append(words, "=")
+			i++
+		case c == '"':
+			// Quoted strings keep their quotes, so that values can
+			// tell them apart from other syntax.
+			var b strings.Builder
+			b.WriteByte('"')
+			for i++; ; i++ {
+				if i >= len(line) {
+					return nil, errors.New("unterminated string")
+				}
+				if line[i] == '"' {
+					i++
+					break
+				}
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			b.WriteByte('"')
+			words = append(words, b.String())
+		default:
+			delimiters := " \t\r#\""
+			if isSet {
+				delimiters += "="
+			}
+			start := i
+			for i < len(line) && !strings.ContainsRune(delimiters, rune(line[i])) {
+				i++
+			}
+			words = append(words, line[start:i])
+		}
+	}
+
+	return words, nil
+}
+
+// unquote returns the contents of a quoted word, or the word itself.
+func unquote(word string) string {
+	if len(word) >= 2 && word[0] == '"' {
+		return word[1 : len(word)-1]
+	}
+
+	return word
+}
+
+// parseShellValue parses a value in omshell syntax: a quoted string,
+// colon-separated hex bytes, a decimal integer, which becomes four
+// bytes, or a dotted IPv4 address.
+func parseShellValue(word string) ([]byte, error) {
+	if strings.HasPrefix(word, "\"") {
+		return []byte(unquote(word)), nil
+	}
+
+	if ip := net.ParseIP(word).To4(); ip != nil && strings.Count(word, ".") == 3 {
+		return []byte(ip), nil
+	}
+
+	if n, err := strconv.ParseInt(word, 10, 32); err == nil {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(n))
+		return b, nil
+	}
+
+	if strings.Contains(word, ":") {
+		return omapi.ParseColonHex(word)
+	}
+
+	return nil, fmt.Errorf("invalid value %q, expected a quoted string, colon-separated hex, an integer or an IP address", word)
+}
+
+// formatShellValue formats a value like omshell: printable values as
+// quoted strings, others as colon-separated hex.
+func formatShellValue(value []byte) string {
+	if !isPrintable(value) {
+		return omapi.FormatIdentifier(value)
+	}
+
+	return strconv.Quote(string(value))
+}
+
+func isPrintable(value []byte) bool {
+	for _, b := range value {
+		if b < ' ' || b > '~' {
+			return false
+		}
+	}
+
+	return true
+}
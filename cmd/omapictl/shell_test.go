@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestSplitShellLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"connect # to the server", []string{"connect"}},
+		{"set name = \"foo\"", []string{"set", "name", "=", "\"foo\""}},
+		{"set name=\"foo bar\"", []string{"set", "name", "=", "\"foo bar\""}},
+		{"set hardware-type=1", []string{"set", "hardware-type", "=", "1"}},
+		{"key omapi_key AbCdEf==", []string{"key", "omapi_key", "AbCdEf=="}},
+		{"key omapi_key \"AbCdEf==\"", []string{"key", "omapi_key", "\"AbCdEf==\""}},
+		{"set name = \"say \\\"hi\\\"\"", []string{"set", "name", "=", "\"say \"hi\"\""}},
+	}
+
+	for _, tt := range tests {
+		got, err := splitShellLine(tt.line)
+		if err != nil {
+			t.Errorf("splitShellLine(%q): %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitShellLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	if _, err := splitShellLine("set name = \"foo"); err == nil {
+		t.Error("unterminated string was accepted")
+	}
+}
+
+func TestShell(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.AddKey("omapi_key", "c2VjcmV0LWtleQ==")
+
+	var out bytes.Buffer
+	a := &app{out: &printer{w: &out}, stderr: &out}
+	defer func() {
+		if a.conn != nil {
+			a.conn.Close()
+		}
+	}()
+
+	script := strings.Join([]string{
+		"server 127.0.0.1",
+		"port " + server.Addr[strings.LastIndex(server.Addr, ":")+1:],
+		"key omapi_key c2VjcmV0LWtleQ==",
+		"connect",
+		"new host",
+		"set name=\"printer\"",
+		"set hardware-address = 00:11:22:33:44:55",
+		"set hardware-type = 1",
+		"create",
+		"close",
+		"new host",
+		"set name = \"printer\"",
+		"open",
+		"remove",
+	}, "\n")
+
+	sh := &shell{app: a}
+	if err := sh.run(context.Background(), strings.NewReader(script)); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+
+	if !strings.Contains(out.String(), "name = \"printer\"") {
+		t.Errorf("output doesn't show the host:\n%s", out.String())
+	}
+	if hosts := server.Hosts(); len(hosts) != 0 {
+		t.Errorf("server has hosts %+v after remove", hosts)
+	}
+
+	if err := sh.execute(context.Background(), "close"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.execute(context.Background(), "close"); err == nil {
+		t.Error("closing twice succeeded")
+	}
+	if err := sh.execute(context.Background(), "refresh"); err == nil {
+		t.Error("refreshing a closed object succeeded")
+	}
+}
@@ -1,7 +1,3 @@
 module github.com/loopinternet/dhcp-management
 
 go 1.22.5
-
-require github.com/sirupsen/logrus v1.9.3
-
-require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
@@ -0,0 +1,276 @@
+package omapi
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// An AuditRecord describes a create, update or delete sent to a server.
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	Server string    `json:"server"`
+
+	// KeyName is the name of the key the connection is authenticated
+	// with, if any.
+	KeyName string `json:"key-name,omitempty"`
+
+	// Operation is "create", "update" or "delete".
+	Operation string `json:"operation"`
+
+	// ObjectType is the type of the object, such as "host", if it is
+	// known. For updates and deletes it is only known if the handle
+	// was opened through the same Auditor.
+	ObjectType string `json:"object-type,omitempty"`
+	Handle     int32  `json:"handle,omitempty"`
+
+	// Keys are the values that identify the object, such as its name
+	// or hardware address.
+	Keys map[string]any `json:"keys,omitempty"`
+
+	// Before and After are the object's values before and after the
+	// operation, as far as they could be fetched. Before is empty for
+	// creates and After for deletes.
+	Before map[string]any `json:"before,omitempty"`
+	After  map[string]any `json:"after,omitempty"`
+
+	StatusCode int32  `json:"status-code"`
+	Status     string `json:"status"`
+
+	// StatusText is the server's explanation of an error, if it gave
+	// one.
+	StatusText string `json:"status-text,omitempty"`
+}
+
+// An AuditSink receives the records of an Auditor.
+type AuditSink interface {
+	RecordAudit(ctx context.Context, record AuditRecord) error
+}
+
+// AuditSinkFunc adapts a function to an AuditSink.
+type AuditSinkFunc func(ctx context.Context, record AuditRecord) error
+
+func (f AuditSinkFunc) RecordAudit(ctx context.Context, record AuditRecord) error {
+	return f(ctx, record)
+}
+
+// keyFields are the values that identify objects, in AuditRecord.Keys.
+var keyFields = []string{"name", "ip-address", "hardware-address", "hardware-type", "dhcp-client-identifier"}
+
+// maxAuditHandles limits how many handles an Auditor remembers the
+// object type of. When the limit is reached, the least recently used
+// handle is forgotten.
+const maxAuditHandles = 4096
+
+type auditHandle struct {
+	con    *Connection
+	handle int32
+}
+
+type auditType struct {
+	key      auditHandle
+	typeName string
+}
+
+// An Auditor records every create, update and delete sent through the
+// connections it intercepts, for compliance. Before updates and
+// deletes it refreshes the object to record its previous values,
+// which costs an extra query.
+//
+// Example:
+//
+//	auditLog, err := omapi.OpenAuditLog("/var/log/omapi-audit.jsonl")
+//	auditor := &omapi.Auditor{Sink: auditLog}
+//
+//	dialer := omapi.Dialer{Interceptors: []omapi.Interceptor{auditor.Intercept}}
+type Auditor struct {
+	Sink AuditSink
+
+	// OnError, if set, is called with errors returned by the sink.
+	OnError func(err error)
+
+	mu    sync.Mutex
+	types map[auditHandle]*list.Element
+	lru   *list.List // of auditType, the most recently used first
+}
+
+// Intercept is an Interceptor that records mutations.
+func (a *Auditor) Intercept(ctx context.Context, msg *Message, con *Connection, invoke Invoker) (*Message, Status) {
+	record := AuditRecord{Server: con.RemoteAddr().String(), KeyName: con.KeyName(), Handle: msg.Handle}
+
+	switch {
+	case msg.Opcode == OpOpen && isTrue(msg.Message["create"]):
+		record.Operation = "create"
+		record.ObjectType = string(msg.Message["type"])
+	case msg.Opcode == OpUpdate:
+		record.Operation = "update"
+	case msg.Opcode == OpDelete:
+		record.Operation = "delete"
+	default:
+		response, status := invoke(ctx, msg)
+		if msg.Opcode == OpOpen && !status.IsError() {
+			a.remember(con, response.Handle, string(msg.Message["type"]))
+		}
+		return response, status
+	}
+
+	var before map[string][]byte
+	if msg.Opcode != OpOpen {
+		record.ObjectType = a.objectType(con, msg.Handle)
+
+		refresh := NewMessage()
+		refresh.Opcode = OpRefresh
+		refresh.Handle = msg.Handle
+		if response, status := invoke(ctx, refresh); !status.IsError() && response.Opcode == OpUpdate {
+			before = response.Object
+		}
+	}
+
+	response, status := invoke(ctx, msg)
+
+	var after map[string][]byte
+	if msg.Opcode != OpDelete && !status.IsError() && response.Opcode == OpUpdate {
+		after = response.Object
+		if msg.Opcode == OpOpen {
+			record.Handle = response.Handle
+			a.remember(con, response.Handle, record.ObjectType)
+		}
+	}
+	if msg.Opcode == OpDelete && !status.IsError() {
+		a.forget(con, msg.Handle)
+	}
+
+	record.Time = time.Now()
+	record.Keys = auditKeys(msg.Object, before, after)
+	if before != nil {
+		record.Before = displayValues(before)
+	}
+	if after != nil {
+		record.After = displayValues(after)
+	}
+	record.StatusCode, record.Status, record.StatusText = status.Code, status.Message, status.Text
+
+	if err := a.Sink.RecordAudit(ctx, record); err != nil && a.OnError != nil {
+		a.OnError(err)
+	}
+
+	return response, status
+}
+
+func (a *Auditor) remember(con *Connection, handle int32, typeName string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.types == nil {
+		a.types = make(map[auditHandle]*list.Element)
+		a.lru = list.New()
+	}
+
+	key := auditHandle{con, handle}
+	if e, ok := a.types[key]; ok {
+		e.Value = auditType{key, typeName}
+		a.lru.MoveToFront(e)
+		return
+	}
+
+	a.types[key] = a.lru.PushFront(auditType{key, typeName})
+	if a.lru.Len() > maxAuditHandles {
+		oldest := a.lru.Remove(a.lru.Back()).(auditType)
+		delete(a.types, oldest.key)
+	}
+}
+
+// forget forgets the object type of a deleted object's handle.
+func (a *Auditor) forget(con *Connection, handle int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if e, ok := a.types[auditHandle{con, handle}]; ok {
+		a.lru.Remove(e)
+		delete(a.types, auditHandle{con, handle})
+	}
+}
+
+func (a *Auditor) objectType(con *Connection, handle int32) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.types[auditHandle{con, handle}]
+	if !ok {
+		return ""
+	}
+	a.lru.MoveToFront(e)
+
+	return e.Value.(auditType).typeName
+}
+
+// auditKeys returns the key fields of an object, taken from the first
+// of the value maps that has each.
+func auditKeys(values ...map[string][]byte) map[string]any {
+	keys := make(map[string][]byte)
+	for _, field := range keyFields {
+		for _, v := range values {
+			if value, ok := v[field]; ok && len(value) > 0 {
+				keys[field] = value
+				break
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return displayValues(keys)
+}
+
+// An AuditLog is an AuditSink that writes records as JSON, one per
+// line. It is safe for concurrent use.
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAuditLog returns an AuditLog that writes to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// OpenAuditLog opens a file for an AuditLog, appending to it if it
+// exists.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAuditLog(f), nil
+}
+
+func (l *AuditLog) RecordAudit(_ context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.w.Write(line)
+
+	return err
+}
+
+// Close closes the underlying writer, if it is an io.Closer.
+func (l *AuditLog) Close() error {
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
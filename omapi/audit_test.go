@@ -0,0 +1,138 @@
+package omapi_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+// auditRecords collects the records of an Auditor.
+type auditRecords struct {
+	mu      sync.Mutex
+	records []omapi.AuditRecord
+}
+
+func (r *auditRecords) RecordAudit(_ context.Context, record omapi.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = append(r.records, record)
+
+	return nil
+}
+
+func (r *auditRecords) last() omapi.AuditRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.records[len(r.records)-1]
+}
+
+// dialAudited returns a connection to server whose mutations are
+// recorded in the returned records.
+func dialAudited(t *testing.T, server *omapitest.Server) (*omapi.Connection, *auditRecords) {
+	t.Helper()
+
+	records := new(auditRecords)
+	auditor := &omapi.Auditor{Sink: records}
+	dialer := &omapi.Dialer{Interceptors: []omapi.Interceptor{auditor.Intercept}}
+
+	con, err := dialer.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { con.Close() })
+
+	return con, records
+}
+
+func TestAuditor(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	con, records := dialAudited(t, server)
+
+	created, err := con.CreateHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := records.last()
+	if record.Operation != "create" || record.ObjectType != "host" || record.Keys["name"] != "a" || record.After == nil {
+		t.Errorf("got create record %+v", record)
+	}
+
+	if err := con.Update(created.Handle, map[string][]byte{"statements": []byte("option routers 10.0.0.1;")}); err != nil {
+		t.Fatal(err)
+	}
+	record = records.last()
+	if record.Operation != "update" || record.ObjectType != "host" || record.Handle != created.Handle || record.Before == nil {
+		t.Errorf("got update record %+v", record)
+	}
+
+	if err := con.Delete(created.Handle); err != nil {
+		t.Fatal(err)
+	}
+	record = records.last()
+	if record.Operation != "delete" || record.ObjectType != "host" || record.Keys["name"] != "a" || record.After != nil {
+		t.Errorf("got delete record %+v", record)
+	}
+
+	// Failures are recorded with the server's explanation.
+	if err := con.Delete(created.Handle); !errors.Is(err, omapi.ErrNotFound) {
+		t.Fatalf("deleting a deleted host returned %v", err)
+	}
+	record = records.last()
+	if record.StatusCode != 23 || record.Status != "not found" || record.StatusText != "invalid handle" {
+		t.Errorf("got failed delete record %+v", record)
+	}
+}
+
+func TestAuditorRemembersRecentHandles(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet})
+
+	con, records := dialAudited(t, server)
+
+	open := func() int32 {
+		t.Helper()
+		host, err := con.FindHost(omapi.Host{Name: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return host.Handle
+	}
+	update := func(handle int32) string {
+		t.Helper()
+		if err := con.Update(handle, map[string][]byte{"statements": []byte("")}); err != nil {
+			t.Fatal(err)
+		}
+		return records.last().ObjectType
+	}
+
+	// Opening as many handles as the Auditor remembers, and one more,
+	// only makes it forget the least recently used one.
+	first, second := open(), open()
+	for i := 2; i < 4096; i++ {
+		open()
+	}
+	if typeName := update(first); typeName != "host" {
+		t.Fatalf("update of the first handle has object type %q, want host", typeName)
+	}
+	last := open()
+
+	if typeName := update(first); typeName != "host" {
+		t.Errorf("update of the recently used handle has object type %q, want host", typeName)
+	}
+	if typeName := update(last); typeName != "host" {
+		t.Errorf("update of the last handle has object type %q, want host", typeName)
+	}
+	if typeName := update(second); typeName != "" {
+		t.Errorf("update of the least recently used handle has object type %q, want none", typeName)
+	}
+}
@@ -54,7 +54,11 @@ func (auth *hmacMD5Authenticator) Sign(m *Message) []byte {
 
 	// The signature's length is part of the message that we are
 	// signing, so initialize the signature with the correct length.
-	m.Signature = bytes.Repeat([]byte("\x00"), int(auth.AuthLen()))
+	// An existing signature of the right length is kept, so that
+	// received messages can be verified.
+	if len(m.Signature) != int(auth.AuthLen()) {
+		m.Signature = bytes.Repeat([]byte("\x00"), int(auth.AuthLen()))
+	}
 	hmac.Write(m.Bytes(true))
 
 	return hmac.Sum(nil)
@@ -4,8 +4,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"sort"
-
-	log "github.com/sirupsen/logrus"
 )
 
 type buffer struct {
@@ -22,7 +20,9 @@ func (b *buffer) addBytes(data []byte) {
 
 func (b *buffer) add(data interface{}) {
 	if err := binary.Write(b.buffer, binary.BigEndian, data); err != nil {
-		log.Fatal(err)
+		// Only happens for types that binary can't encode, which
+		// is a bug.
+		panic(err)
 	}
 }
 
@@ -0,0 +1,285 @@
+package omapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	protocolVersion = 100
+	headerSize      = 24 // authid + authlen + opcode + handle + tid + rid
+)
+
+// WriteStartup writes the startup message that both sides of an OMAPI
+// connection send before any other message.
+func WriteStartup(w io.Writer) error {
+	buf := newBuffer()
+	buf.add(int32(protocolVersion))
+	buf.add(int32(headerSize))
+
+	_, err := w.Write(buf.bytes())
+
+	return err
+}
+
+// ReadStartup reads the startup message of the other side of an OMAPI
+// connection and checks that it speaks the same protocol. It reads
+// exactly the startup message, so a Decoder can be created for r
+// afterwards.
+func ReadStartup(r io.Reader) error {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return err
+	}
+
+	if bytesToInt32(b[0:4]) != protocolVersion {
+		return protocolErrorf("version mismatch")
+	}
+
+	if bytesToInt32(b[4:8]) != headerSize {
+		return protocolErrorf("header size mismatch")
+	}
+
+	return nil
+}
+
+// An Encoder writes OMAPI messages to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes a message in a single write. The message has to be
+// signed already.
+func (enc *Encoder) Encode(msg *Message) error {
+	_, err := enc.w.Write(msg.Bytes(false))
+
+	return err
+}
+
+// Limits restrict the size of messages a Decoder accepts, to protect
+// against peers that send bogus lengths or endless messages. Zero
+// fields use the corresponding field of DefaultLimits.
+type Limits struct {
+	// MaxKeyLength is the maximum length of a key in the message or
+	// object map.
+	MaxKeyLength int
+
+	// MaxValueLength is the maximum length of a value in the message
+	// or object map, and of the signature.
+	MaxValueLength int
+
+	// MaxEntries is the maximum number of entries in the message or
+	// object map.
+	MaxEntries int
+
+	// MaxMessageSize is the maximum size of a whole message,
+	// including its header and signature.
+	MaxMessageSize int
+}
+
+// DefaultLimits are generous enough for anything dhcpd sends or
+// accepts.
+var DefaultLimits = Limits{
+	MaxKeyLength:   1024,
+	MaxValueLength: 64 * 1024,
+	MaxEntries:     256,
+	MaxMessageSize: 1024 * 1024,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxKeyLength == 0 {
+		l.MaxKeyLength = DefaultLimits.MaxKeyLength
+	}
+	if l.MaxValueLength == 0 {
+		l.MaxValueLength = DefaultLimits.MaxValueLength
+	}
+	if l.MaxEntries == 0 {
+		l.MaxEntries = DefaultLimits.MaxEntries
+	}
+	if l.MaxMessageSize == 0 {
+		l.MaxMessageSize = DefaultLimits.MaxMessageSize
+	}
+
+	return l
+}
+
+// A ProtocolError is returned when the other side sends something that
+// isn't a valid OMAPI message or exceeds the limits. The stream cannot
+// be read any further after a protocol error.
+type ProtocolError struct {
+	Msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "omapi: protocol error: " + e.Msg
+}
+
+func protocolErrorf(format string, args ...any) *ProtocolError {
+	return &ProtocolError{fmt.Sprintf(format, args...)}
+}
+
+// A Decoder reads OMAPI messages from an input stream.
+type Decoder struct {
+	// Limits restrict the messages that the decoder accepts.
+	Limits Limits
+
+	r    *bufio.Reader
+	size int // size of the message being decoded so far
+}
+
+// NewDecoder returns a decoder that reads from r, using DefaultLimits.
+// The decoder buffers its input and may read more data than it needs,
+// so r should not be read from by anything else afterwards.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next message. It returns io.EOF if the stream ended
+// cleanly before a message, io.ErrUnexpectedEOF if it ended in the
+// middle of one and a *ProtocolError if the message is malformed or
+// exceeds the decoder's limits.
+func (dec *Decoder) Decode() (*Message, error) {
+	limits := dec.Limits.withDefaults()
+	dec.size = 0
+
+	var header [headerSize]byte
+	if _, err := io.ReadFull(dec.r, header[:]); err != nil {
+		return nil, err
+	}
+	if err := dec.claim(headerSize, limits); err != nil {
+		return nil, err
+	}
+
+	message := &Message{
+		AuthID:        bytesToInt32(header[0:4]),
+		Opcode:        Opcode(bytesToInt32(header[8:12])),
+		Handle:        bytesToInt32(header[12:16]),
+		TransactionID: bytesToInt32(header[16:20]),
+		ResponseID:    bytesToInt32(header[20:24]),
+	}
+
+	authlen := bytesToInt32(header[4:8])
+	if authlen < 0 || int64(authlen) > int64(limits.MaxValueLength) {
+		return nil, protocolErrorf("invalid signature length %d", authlen)
+	}
+
+	var err error
+	if message.Message, err = dec.decodeMap(limits); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if message.Object, err = dec.decodeMap(limits); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	if err := dec.claim(int(authlen), limits); err != nil {
+		return nil, err
+	}
+	message.Signature = make([]byte, authlen)
+	if _, err := io.ReadFull(dec.r, message.Signature); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	return message, nil
+}
+
+// claim accounts for n more bytes of the current message, before they
+// are allocated.
+func (dec *Decoder) claim(n int, limits Limits) error {
+	dec.size += n
+	if dec.size > limits.MaxMessageSize {
+		return protocolErrorf("message exceeds %d bytes", limits.MaxMessageSize)
+	}
+
+	return nil
+}
+
+func (dec *Decoder) decodeMap(limits Limits) (map[string][]byte, error) {
+	dict := make(map[string][]byte)
+
+	for entries := 0; ; entries++ {
+		var keyLength int16
+		if err := binary.Read(dec.r, binary.BigEndian, &keyLength); err != nil {
+			return nil, err
+		}
+		if err := dec.claim(2, limits); err != nil {
+			return nil, err
+		}
+		if keyLength == 0 {
+			// end of map
+			return dict, nil
+		}
+
+		if entries == limits.MaxEntries {
+			return nil, protocolErrorf("map has more than %d entries", limits.MaxEntries)
+		}
+		if keyLength < 0 || int(keyLength) > limits.MaxKeyLength {
+			return nil, protocolErrorf("invalid key length %d", keyLength)
+		}
+		if err := dec.claim(int(keyLength), limits); err != nil {
+			return nil, err
+		}
+
+		key := make([]byte, keyLength)
+		if _, err := io.ReadFull(dec.r, key); err != nil {
+			return nil, err
+		}
+
+		var valueLength int32
+		if err := binary.Read(dec.r, binary.BigEndian, &valueLength); err != nil {
+			return nil, err
+		}
+		if valueLength < 0 || int64(valueLength) > int64(limits.MaxValueLength) {
+			return nil, protocolErrorf("invalid value length %d for key %q", valueLength, key)
+		}
+		if err := dec.claim(4+int(valueLength), limits); err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, valueLength)
+		if _, err := io.ReadFull(dec.r, value); err != nil {
+			return nil, err
+		}
+
+		dict[string(key)] = value
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// MarshalBinary returns the message in wire format, as it would be
+// sent. The message has to be signed already.
+func (m *Message) MarshalBinary() ([]byte, error) {
+	return m.Bytes(false), nil
+}
+
+// UnmarshalBinary parses a single message in wire format.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	dec := NewDecoder(r)
+	message, err := dec.Decode()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	if r.Len() > 0 || dec.r.Buffered() > 0 {
+		return errors.New("trailing data after message")
+	}
+
+	*m = *message
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package omapi_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// frame builds a message in wire format from its header fields and
+// raw map and signature bytes, so that lengths can be bogus.
+func frame(authlen int32, maps []byte, signature []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, []int32{0, authlen, int32(omapi.OpOpen), 0, 1, 0})
+	b.Write(maps)
+	b.Write(signature)
+
+	return b.Bytes()
+}
+
+// entry encodes a map entry with the given lengths, which may differ
+// from the actual key and value.
+func entry(keyLength int16, key string, valueLength int32, value []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, keyLength)
+	b.WriteString(key)
+	binary.Write(&b, binary.BigEndian, valueLength)
+	b.Write(value)
+
+	return b.Bytes()
+}
+
+var endOfMap = []byte{0, 0}
+
+func validFrames() [][]byte {
+	open := omapi.NewOpenMessage("host")
+	open.Object["name"] = []byte("printer")
+	open.Object["hardware-address"] = []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	signed := omapi.NewMessage()
+	signed.Opcode = omapi.OpUpdate
+	signed.Handle = 7
+	signed.Signature = bytes.Repeat([]byte{0xaa}, 16)
+
+	return [][]byte{open.Bytes(false), signed.Bytes(false), omapi.NewMessage().Bytes(false)}
+}
+
+func invalidFrames() map[string][]byte {
+	empty := append(append([]byte{}, endOfMap...), endOfMap...)
+
+	return map[string][]byte{
+		"negative signature length":  frame(-1, empty, nil),
+		"oversized signature length": frame(1<<30, empty, nil),
+		"negative key length":        frame(0, entry(-1, "", 0, nil), nil),
+		"oversized key length":       frame(0, entry(0x7fff, "", 0, nil), nil),
+		"negative value length":      frame(0, entry(4, "name", -1, nil), nil),
+		"oversized value length":     frame(0, entry(4, "name", 1<<30, nil), nil),
+	}
+}
+
+func TestDecodeInvalidLengths(t *testing.T) {
+	for name, data := range invalidFrames() {
+		t.Run(name, func(t *testing.T) {
+			_, err := omapi.NewDecoder(bytes.NewReader(data)).Decode()
+
+			var protocolErr *omapi.ProtocolError
+			if !errors.As(err, &protocolErr) {
+				t.Errorf("Decode returned %v, want a protocol error", err)
+			}
+		})
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	message := omapi.NewMessage()
+	for _, key := range []string{"a", "b", "c"} {
+		message.Object[key] = []byte("value")
+	}
+	data := message.Bytes(false)
+
+	tests := []struct {
+		name   string
+		limits omapi.Limits
+	}{
+		{"entries", omapi.Limits{MaxEntries: 2}},
+		{"value length", omapi.Limits{MaxValueLength: 4}},
+		{"message size", omapi.Limits{MaxMessageSize: len(data) - 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := omapi.NewDecoder(bytes.NewReader(data))
+			dec.Limits = tt.limits
+
+			var protocolErr *omapi.ProtocolError
+			if _, err := dec.Decode(); !errors.As(err, &protocolErr) {
+				t.Errorf("Decode returned %v, want a protocol error", err)
+			}
+		})
+	}
+
+	dec := omapi.NewDecoder(bytes.NewReader(data))
+	dec.Limits = omapi.Limits{MaxMessageSize: len(data)}
+	if _, err := dec.Decode(); err != nil {
+		t.Errorf("Decode of a message at the size limit: %v", err)
+	}
+}
+
+// FuzzDecode feeds arbitrary data to the decoder, which must never
+// panic, must reject invalid data with a protocol error, and must
+// decode what it accepted the same way after encoding it again.
+func FuzzDecode(f *testing.F) {
+	for _, data := range validFrames() {
+		f.Add(data)
+	}
+	for _, data := range invalidFrames() {
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		message, err := omapi.NewDecoder(bytes.NewReader(data)).Decode()
+		if err != nil {
+			// Data that ends early can't be told apart from a
+			// stream that hasn't delivered the rest yet.
+			var protocolErr *omapi.ProtocolError
+			if !errors.As(err, &protocolErr) && err != io.EOF && err != io.ErrUnexpectedEOF {
+				t.Fatalf("Decode returned %T %v, want a protocol error", err, err)
+			}
+			return
+		}
+
+		decoded, err := omapi.NewDecoder(bytes.NewReader(message.Bytes(false))).Decode()
+		if err != nil {
+			t.Fatalf("decoding an encoded message: %v", err)
+		}
+		if !reflect.DeepEqual(message, decoded) {
+			t.Fatalf("message changed in a round trip: %+v, then %+v", message, decoded)
+		}
+	})
+}
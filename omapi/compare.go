@@ -0,0 +1,168 @@
+package omapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// HostDiffKind describes how a host differs between two servers.
+type HostDiffKind int
+
+const (
+	HostSame HostDiffKind = iota
+	HostOnlyInA
+	HostOnlyInB
+	HostDiffers
+	HostMissing // on both servers
+	HostError   // the lookup failed on at least one server
+)
+
+func (k HostDiffKind) String() string {
+	switch k {
+	case HostSame:
+		return "same"
+	case HostOnlyInA:
+		return "only-in-a"
+	case HostOnlyInB:
+		return "only-in-b"
+	case HostDiffers:
+		return "differs"
+	case HostMissing:
+		return "missing"
+	case HostError:
+		return "error"
+	}
+
+	return "unknown"
+}
+
+func (k HostDiffKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// HostDiff is the result of comparing a single host on two servers.
+type HostDiff struct {
+	// Query is the host as it was looked up.
+	Query Host
+
+	Kind HostDiffKind
+
+	// A and B are the host as found on either server, if it was.
+	A, B Host
+
+	// Fields lists the values that differ, such as "ip-address", if
+	// Kind is HostDiffers.
+	Fields []string
+
+	// Err is the error of the failed lookup if Kind is HostError.
+	Err error
+}
+
+// CompareHosts looks up each of the queries, typically hosts with only
+// a name or a hardware address set, on both servers and compares what
+// it finds. It returns a result for every query, in the same order,
+// including hosts that are the same on both servers. Hosts are
+// compared by their IP address, hardware address and type and client
+// identifier; statements can't be compared, because OMAPI doesn't
+// return them.
+//
+// This is mostly useful for failover peers, which don't replicate
+// host objects and may drift apart.
+//
+// Example:
+//
+//	diffs, err := omapi.CompareHosts(ctx, con1, con2, []omapi.Host{{Name: "printer"}})
+//	for _, diff := range diffs {
+//		if diff.Kind == omapi.HostOnlyInA {
+//			// Fix it with RepairHost
+//		}
+//	}
+func CompareHosts(ctx context.Context, a, b *Connection, queries []Host) ([]HostDiff, error) {
+	diffs := make([]HostDiff, 0, len(queries))
+
+	for _, query := range queries {
+		if err := ctx.Err(); err != nil {
+			return diffs, err
+		}
+
+		diff := HostDiff{Query: query}
+
+		hostA, errA := a.FindHostContext(ctx, query)
+		hostB, errB := b.FindHostContext(ctx, query)
+		foundA, foundB := errA == nil, errB == nil
+
+		// Not finding the host is a result, anything else isn't.
+		var errs []error
+		for _, err := range []error{errA, errB} {
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				errs = append(errs, err)
+			}
+		}
+
+		switch {
+		case len(errs) > 0:
+			diff.Kind, diff.Err = HostError, errors.Join(errs...)
+		case foundA && foundB:
+			diff.A, diff.B = hostA, hostB
+			if diff.Fields = differingFields(hostA, hostB); len(diff.Fields) > 0 {
+				diff.Kind = HostDiffers
+			}
+		case foundA:
+			diff.A, diff.Kind = hostA, HostOnlyInA
+		case foundB:
+			diff.B, diff.Kind = hostB, HostOnlyInB
+		default:
+			diff.Kind = HostMissing
+		}
+
+		diffs = append(diffs, diff)
+	}
+
+	return diffs, nil
+}
+
+func differingFields(a, b Host) []string {
+	var fields []string
+
+	if !a.IP.Equal(b.IP) && (len(a.IP) > 0 || len(b.IP) > 0) {
+		fields = append(fields, "ip-address")
+	}
+	if !bytes.Equal(a.HardwareAddress, b.HardwareAddress) {
+		fields = append(fields, "hardware-address")
+	}
+	if a.HardwareType != b.HardwareType {
+		fields = append(fields, "hardware-type")
+	}
+	if !bytes.Equal(a.DHCPClientIdentifier, b.DHCPClientIdentifier) {
+		fields = append(fields, "dhcp-client-identifier")
+	}
+
+	return fields
+}
+
+// RepairHost fixes a host that exists on only one of the servers, by
+// creating it on the other one from what the first one returned. Since
+// OMAPI doesn't return a host's statements, the copy won't have any.
+// Hosts that differ are left alone, since there is no telling which
+// server is right. It returns the created host.
+func RepairHost(ctx context.Context, a, b *Connection, diff HostDiff) (Host, error) {
+	var (
+		host Host
+		to   *Connection
+	)
+
+	switch diff.Kind {
+	case HostOnlyInA:
+		host, to = diff.A, b
+	case HostOnlyInB:
+		host, to = diff.B, a
+	default:
+		return Host{}, fmt.Errorf("can't repair host that is %s", diff.Kind)
+	}
+
+	host.Handle = 0
+
+	return to.CreateHostContext(ctx, host)
+}
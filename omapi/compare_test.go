@@ -0,0 +1,186 @@
+package omapi_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestCompareHosts(t *testing.T) {
+	primary, secondary, client := startPair(t)
+	peers := client.Peers()
+	a, b := peers[0].Conn, peers[1].Conn
+
+	same := omapi.Host{Name: "same", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet, IP: net.ParseIP("10.0.0.1")}
+	onlyA := omapi.Host{Name: "only-a", HardwareAddress: mustMAC(t, "00:11:22:33:44:02"), HardwareType: omapi.Ethernet}
+	onlyB := omapi.Host{Name: "only-b", HardwareAddress: mustMAC(t, "00:11:22:33:44:03"), HardwareType: omapi.Ethernet}
+	differsA := omapi.Host{Name: "differs", HardwareAddress: mustMAC(t, "00:11:22:33:44:04"), HardwareType: omapi.Ethernet, IP: net.ParseIP("10.0.0.4")}
+	differsB := omapi.Host{Name: "differs", HardwareAddress: mustMAC(t, "00:11:22:33:44:04"), HardwareType: omapi.Ethernet, IP: net.ParseIP("10.0.0.5"), DHCPClientIdentifier: []byte("b")}
+
+	for _, host := range []omapi.Host{same, onlyA, differsA} {
+		primary.PutHost(host)
+	}
+	for _, host := range []omapi.Host{same, onlyB, differsB} {
+		secondary.PutHost(host)
+	}
+
+	queries := []omapi.Host{
+		{Name: "same"},
+		{Name: "only-a"},
+		{HardwareAddress: onlyB.HardwareAddress, HardwareType: omapi.Ethernet},
+		{Name: "differs"},
+		{Name: "missing"},
+	}
+
+	diffs, err := omapi.CompareHosts(context.Background(), a, b, queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != len(queries) {
+		t.Fatalf("got %d diffs, want %d", len(diffs), len(queries))
+	}
+
+	tests := []struct {
+		kind   omapi.HostDiffKind
+		a, b   string
+		fields []string
+	}{
+		{omapi.HostSame, "same", "same", nil},
+		{omapi.HostOnlyInA, "only-a", "", nil},
+		{omapi.HostOnlyInB, "", "only-b", nil},
+		{omapi.HostDiffers, "differs", "differs", []string{"ip-address", "dhcp-client-identifier"}},
+		{omapi.HostMissing, "", "", nil},
+	}
+	for i, tt := range tests {
+		diff := diffs[i]
+		if !reflect.DeepEqual(diff.Query, queries[i]) {
+			t.Errorf("diff %d is for %+v, want %+v", i, diff.Query, queries[i])
+		}
+		if diff.Kind != tt.kind || diff.A.Name != tt.a || diff.B.Name != tt.b || diff.Err != nil {
+			t.Errorf("diff of %+v = %s with %q and %q, %v; want %s with %q and %q", queries[i], diff.Kind, diff.A.Name, diff.B.Name, diff.Err, tt.kind, tt.a, tt.b)
+		}
+		if !reflect.DeepEqual(diff.Fields, tt.fields) {
+			t.Errorf("Fields of %+v = %v, want %v", queries[i], diff.Fields, tt.fields)
+		}
+	}
+}
+
+func TestCompareHostsFields(t *testing.T) {
+	base := omapi.Host{Name: "h", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet}
+
+	tests := []struct {
+		name   string
+		change func(*omapi.Host)
+		fields []string
+	}{
+		{"none", func(*omapi.Host) {}, nil},
+		{"ip-address added", func(h *omapi.Host) { h.IP = net.ParseIP("10.0.0.1") }, []string{"ip-address"}},
+		{"hardware-address", func(h *omapi.Host) { h.HardwareAddress = mustMAC(t, "00:11:22:33:44:02") }, []string{"hardware-address"}},
+		{"hardware-type", func(h *omapi.Host) { h.HardwareType = omapi.TokenRing }, []string{"hardware-type"}},
+		{"dhcp-client-identifier", func(h *omapi.Host) { h.DHCPClientIdentifier = []byte{1, 2} }, []string{"dhcp-client-identifier"}},
+		{"statements", func(h *omapi.Host) { h.Statements = `ddns-hostname "h";` }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, secondary, client := startPair(t)
+			peers := client.Peers()
+
+			changed := base
+			tt.change(&changed)
+			primary.PutHost(base)
+			secondary.PutHost(changed)
+
+			diffs, err := omapi.CompareHosts(context.Background(), peers[0].Conn, peers[1].Conn, []omapi.Host{{Name: "h"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(diffs[0].Fields, tt.fields) {
+				t.Errorf("Fields = %v, want %v", diffs[0].Fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestCompareHostsError(t *testing.T) {
+	primary, secondary, client := startPair(t)
+	peers := client.Peers()
+
+	primary.PutHost(omapi.Host{Name: "h"})
+	secondary.InjectFault(omapitest.Fault{Status: omapi.Statuses[2]})
+
+	diffs, err := omapi.CompareHosts(context.Background(), peers[0].Conn, peers[1].Conn, []omapi.Host{{Name: "h"}, {Name: "h"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed lookup is an error, not a missing host, and doesn't
+	// stop the comparison.
+	if diffs[0].Kind != omapi.HostError || !errors.Is(diffs[0].Err, omapi.Statuses[2]) {
+		t.Errorf("first diff = %s, %v; want %s", diffs[0].Kind, diffs[0].Err, omapi.HostError)
+	}
+	if diffs[1].Kind != omapi.HostOnlyInA {
+		t.Errorf("second diff = %s, want %s", diffs[1].Kind, omapi.HostOnlyInA)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if diffs, err := omapi.CompareHosts(ctx, peers[0].Conn, peers[1].Conn, []omapi.Host{{Name: "h"}}); !errors.Is(err, context.Canceled) || len(diffs) != 0 {
+		t.Errorf("CompareHosts after cancel = %v, %v", diffs, err)
+	}
+}
+
+func TestRepairHost(t *testing.T) {
+	primary, secondary, client := startPair(t)
+	peers := client.Peers()
+	a, b := peers[0].Conn, peers[1].Conn
+	ctx := context.Background()
+
+	onlyA := omapi.Host{Name: "only-a", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet, IP: net.ParseIP("10.0.0.1")}
+	onlyB := omapi.Host{Name: "only-b", HardwareAddress: mustMAC(t, "00:11:22:33:44:02"), HardwareType: omapi.Ethernet}
+	primary.PutHost(onlyA)
+	primary.PutHost(omapi.Host{Name: "differs", IP: net.ParseIP("10.0.0.3")})
+	secondary.PutHost(onlyB)
+	secondary.PutHost(omapi.Host{Name: "differs", IP: net.ParseIP("10.0.0.4")})
+
+	diffs, err := omapi.CompareHosts(ctx, a, b, []omapi.Host{{Name: "only-a"}, {Name: "only-b"}, {Name: "differs"}, {Name: "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hosts on only one server are copied to the other.
+	for _, diff := range diffs[:2] {
+		created, err := omapi.RepairHost(ctx, a, b, diff)
+		if err != nil {
+			t.Fatalf("RepairHost(%s) failed: %v", diff.Query.Name, err)
+		}
+		if created.Name != diff.Query.Name || created.Handle == 0 {
+			t.Errorf("RepairHost(%s) created %+v", diff.Query.Name, created)
+		}
+	}
+
+	diffs, err = omapi.CompareHosts(ctx, a, b, []omapi.Host{{Name: "only-a"}, {Name: "only-b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, diff := range diffs {
+		if diff.Kind != omapi.HostSame {
+			t.Errorf("%s is %s after the repair, %v", diff.Query.Name, diff.Kind, diff.Fields)
+		}
+	}
+
+	// Others are left alone.
+	for _, diff := range []omapi.HostDiff{diffs[0], {Query: omapi.Host{Name: "differs"}, Kind: omapi.HostDiffers}, {Kind: omapi.HostMissing}} {
+		if _, err := omapi.RepairHost(ctx, a, b, diff); err == nil {
+			t.Errorf("RepairHost of a %s host succeeded", diff.Kind)
+		}
+	}
+	if len(primary.Hosts()) != 3 || len(secondary.Hosts()) != 3 {
+		t.Errorf("%d and %d hosts after the repair, want 3 each", len(primary.Hosts()), len(secondary.Hosts()))
+	}
+}
@@ -0,0 +1,161 @@
+package omapi
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseConfigHosts parses a dhcpd.conf file and returns its host
+// declarations, in the order in which they appear. Hosts declared
+// inside of group, subnet, shared-network and other scopes are
+// included, but statements of the enclosing scopes aren't inherited.
+//
+// Statements of a host that don't correspond to a field of Host are
+// collected in Statements. Include statements aren't followed.
+//
+// As with ParseLeases, hosts and statements that can't be parsed are
+// skipped and reported in the returned error, along with the hosts
+// that could be parsed.
+func ParseConfigHosts(r io.Reader) ([]Host, error) {
+	statements, err := parseStatements(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	hosts := collectHosts(nil, statements, &errs)
+
+	return hosts, errors.Join(errs...)
+}
+
+func collectHosts(hosts []Host, statements []*statement, errs *[]error) []Host {
+	for _, stmt := range statements {
+		if stmt.keyword() != "host" {
+			if stmt.block != nil {
+				hosts = collectHosts(hosts, stmt.block, errs)
+			}
+			continue
+		}
+
+		host, _, skipped, err := parseHost(stmt)
+		*errs = append(*errs, skipped...)
+		if err != nil {
+			*errs = append(*errs, err)
+			continue
+		}
+
+		hosts = append(hosts, host)
+	}
+
+	return hosts
+}
+
+// WriteConfigHosts writes hosts as dhcpd.conf host declarations, for
+// example to an include file, which makes hosts created over OMAPI
+// permanent. Hardware addresses, fixed addresses and client
+// identifiers are written as their respective statements, followed by
+// the host's Statements verbatim. A hardware address without a
+// hardware type is written as ethernet, the type dhcpd assumes too.
+//
+// Example output:
+//
+//	host new_host {
+//	  hardware ethernet aa:bb:cc:dd:ee:ff;
+//	  fixed-address 10.0.0.2;
+//	  ddns-hostname "the.hostname";
+//	}
+func WriteConfigHosts(w io.Writer, hosts []Host) error {
+	bw := bufio.NewWriter(w)
+
+	for i, host := range hosts {
+		if host.Name == "" {
+			return errors.New("cannot write host without a name")
+		}
+
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+
+		fmt.Fprintf(bw, "host %s {\n", formatName(host.Name))
+
+		if len(host.HardwareAddress) > 0 {
+			hardwareType := host.HardwareType
+			if hardwareType == 0 {
+				hardwareType = Ethernet
+			}
+			hwType, ok := hardwareTypeNames[hardwareType]
+			if !ok {
+				return fmt.Errorf("host %s: unsupported hardware type %d", host.Name, host.HardwareType)
+			}
+			fmt.Fprintf(bw, "  hardware %s %s;\n", hwType, host.HardwareAddress)
+		}
+
+		if len(host.IP) > 0 {
+			fmt.Fprintf(bw, "  fixed-address %s;\n", host.IP)
+		}
+
+		if len(host.DHCPClientIdentifier) > 0 {
+			fmt.Fprintf(bw, "  option dhcp-client-identifier %s;\n", formatIdentifier(host.DHCPClientIdentifier))
+		}
+
+		if statements := strings.TrimSpace(host.Statements); statements != "" {
+			for _, line := range strings.Split(statements, "\n") {
+				fmt.Fprintf(bw, "  %s\n", line)
+			}
+		}
+
+		bw.WriteString("}\n")
+	}
+
+	return bw.Flush()
+}
+
+// formatName returns a host name as is if it can be written as a
+// plain word, and as a quoted string otherwise.
+func formatName(name string) string {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.", c)) {
+			return quoteString([]byte(name))
+		}
+	}
+
+	return name
+}
+
+// formatIdentifier returns a client identifier as a quoted string if
+// it is printable, and as colon-separated hex bytes otherwise, like
+// dhcpd does.
+func formatIdentifier(id []byte) string {
+	for _, b := range id {
+		if b < ' ' || b > '~' {
+			return FormatIdentifier(id)
+		}
+	}
+
+	return quoteString(id)
+}
+
+// quoteString quotes a string in dhcpd syntax, escaping non-printable
+// bytes in octal.
+func quoteString(s []byte) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
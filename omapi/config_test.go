@@ -0,0 +1,245 @@
+package omapi_test
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+func TestParseConfigHosts(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want []omapi.Host
+	}{
+		{
+			name: "top level",
+			conf: `
+option domain-name "example.org";
+default-lease-time 600;
+
+host printer {
+  hardware ethernet 00:11:22:33:44:55;
+  fixed-address 10.0.0.5;
+}
+`,
+			want: []omapi.Host{
+				{Name: "printer", HardwareType: omapi.Ethernet, HardwareAddress: mustMAC(t, "00:11:22:33:44:55"), IP: net.ParseIP("10.0.0.5")},
+			},
+		},
+		{
+			name: "nested scopes",
+			conf: `
+shared-network office {
+  subnet 10.0.0.0 netmask 255.255.255.0 {
+    range 10.0.0.100 10.0.0.200;
+    host a { hardware ethernet 00:00:00:00:00:0a; }
+    group {
+      option routers 10.0.0.1;
+      host b { hardware token-ring 00:00:00:00:00:0b; }
+    }
+  }
+  subnet 10.0.1.0 netmask 255.255.255.0 {
+    pool {
+      range 10.0.1.100 10.0.1.200;
+    }
+  }
+}
+group {
+  host c { fixed-address 10.0.2.5; }
+}
+host d { hardware fddi 00:00:00:00:00:0d; }
+`,
+			want: []omapi.Host{
+				{Name: "a", HardwareType: omapi.Ethernet, HardwareAddress: mustMAC(t, "00:00:00:00:00:0a")},
+				{Name: "b", HardwareType: omapi.TokenRing, HardwareAddress: mustMAC(t, "00:00:00:00:00:0b")},
+				{Name: "c", IP: net.ParseIP("10.0.2.5")},
+				{Name: "d", HardwareType: omapi.FDDI, HardwareAddress: mustMAC(t, "00:00:00:00:00:0d")},
+			},
+		},
+		{
+			name: "statements",
+			conf: `
+host "front desk" {
+  hardware ethernet 00:11:22:33:44:55;
+  fixed-address printer.example.org;
+  option dhcp-client-identifier 01:00:11:22:33:44:55;
+  option host-name "front-desk";
+  ddns-hostname "front-desk";
+  if option vendor-class-identifier = "PXEClient" {
+    filename "pxelinux.0";
+  }
+}
+host kiosk {
+  uid "kiosk";
+  fixed-address 10.0.0.7, 10.0.0.8;
+}
+`,
+			want: []omapi.Host{
+				{
+					Name:                 "front desk",
+					HardwareType:         omapi.Ethernet,
+					HardwareAddress:      mustMAC(t, "00:11:22:33:44:55"),
+					DHCPClientIdentifier: []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x55},
+					Statements: `fixed-address printer.example.org;
+option host-name "front-desk";
+ddns-hostname "front-desk";
+if option vendor-class-identifier = "PXEClient" {
+    filename "pxelinux.0";
+  }`,
+				},
+				{
+					Name:                 "kiosk",
+					DHCPClientIdentifier: []byte("kiosk"),
+					Statements:           "fixed-address 10.0.0.7, 10.0.0.8;",
+				},
+			},
+		},
+		{
+			name: "includes and comments",
+			conf: `
+# Hosts are also kept in an include file, which isn't followed.
+include "/etc/dhcp/hosts.conf";
+
+host laptop { # the admin's laptop
+  # hardware ethernet 00:00:00:00:00:01;
+  hardware ethernet 00:aa:bb:cc:dd:ee; # replaced in 2024
+  option host-name "#laptop"; # a comment character in a string
+}
+# host disabled { fixed-address 10.0.0.9; }
+`,
+			want: []omapi.Host{
+				{
+					Name:            "laptop",
+					HardwareType:    omapi.Ethernet,
+					HardwareAddress: mustMAC(t, "00:aa:bb:cc:dd:ee"),
+					Statements:      `option host-name "#laptop";`,
+				},
+			},
+		},
+		{
+			name: "no hosts",
+			conf: "subnet 10.0.0.0 netmask 255.255.255.0 {\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, err := omapi.ParseConfigHosts(strings.NewReader(tt.conf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(hosts, tt.want) {
+				t.Errorf("ParseConfigHosts() =\n%+v\nwant\n%+v", hosts, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigHostsErrors(t *testing.T) {
+	conf := `
+subnet 10.0.0.0 netmask 255.255.255.0 {
+  host a;
+  host b { hardware infiniband 00:11:22:33:44:55; fixed-address 10.0.0.6; }
+}
+`
+
+	hosts, err := omapi.ParseConfigHosts(strings.NewReader(conf))
+	if err == nil {
+		t.Fatal("ParseConfigHosts succeeded")
+	}
+	if !strings.Contains(err.Error(), "line 3") || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("error = %v, want errors on lines 3 and 4", err)
+	}
+
+	want := []omapi.Host{{Name: "b", IP: net.ParseIP("10.0.0.6")}}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("hosts = %+v, want %+v", hosts, want)
+	}
+
+	if _, err := omapi.ParseConfigHosts(strings.NewReader("group {\n  host a {\n}\n")); err == nil {
+		t.Error("ParseConfigHosts succeeded with a missing }")
+	}
+}
+
+func TestWriteConfigHosts(t *testing.T) {
+	hosts := []omapi.Host{
+		{
+			Name:            "printer",
+			HardwareType:    omapi.Ethernet,
+			HardwareAddress: mustMAC(t, "00:11:22:33:44:55"),
+			IP:              net.ParseIP("10.0.0.5"),
+			Statements:      `ddns-hostname "printer";`,
+		},
+		{
+			// Hosts created over OMAPI often leave out the type.
+			Name:                 "front desk",
+			HardwareAddress:      mustMAC(t, "00:11:22:33:44:56"),
+			DHCPClientIdentifier: []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x56},
+		},
+		{
+			Name:                 "kiosk",
+			HardwareType:         omapi.TokenRing,
+			HardwareAddress:      mustMAC(t, "00:11:22:33:44:57"),
+			DHCPClientIdentifier: []byte(`kiosk "1"`),
+			Statements:           "option host-name \"kiosk\";\nfilename \"pxelinux.0\";",
+		},
+	}
+
+	var b strings.Builder
+	if err := omapi.WriteConfigHosts(&b, hosts); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `host printer {
+  hardware ethernet 00:11:22:33:44:55;
+  fixed-address 10.0.0.5;
+  ddns-hostname "printer";
+}
+
+host "front desk" {
+  hardware ethernet 00:11:22:33:44:56;
+  option dhcp-client-identifier 01:00:11:22:33:44:56;
+}
+
+host kiosk {
+  hardware token-ring 00:11:22:33:44:57;
+  option dhcp-client-identifier "kiosk \"1\"";
+  option host-name "kiosk";
+  filename "pxelinux.0";
+}
+`
+	if b.String() != want {
+		t.Errorf("WriteConfigHosts() =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	// What is written parses back to the same hosts, except that the
+	// hardware type is filled in.
+	parsed, err := omapi.ParseConfigHosts(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts[1].HardwareType = omapi.Ethernet
+	if !reflect.DeepEqual(parsed, hosts) {
+		t.Errorf("ParseConfigHosts(WriteConfigHosts()) =\n%+v\nwant\n%+v", parsed, hosts)
+	}
+}
+
+func TestWriteConfigHostsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		host omapi.Host
+	}{
+		{"no name", omapi.Host{IP: net.ParseIP("10.0.0.5")}},
+		{"unknown hardware type", omapi.Host{Name: "ib", HardwareType: 32, HardwareAddress: mustMAC(t, "00:11:22:33:44:55")}},
+	}
+
+	for _, tt := range tests {
+		if err := omapi.WriteConfigHosts(new(strings.Builder), []omapi.Host{tt.host}); err == nil {
+			t.Errorf("%s: WriteConfigHosts succeeded", tt.name)
+		}
+	}
+}
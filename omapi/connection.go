@@ -1,26 +1,99 @@
 package omapi
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/binary"
 	"errors"
+	"fmt"
+	"log/slog"
 	"net"
-
-	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
 )
 
+// ErrClosed is returned by Err after Close has been called.
+var ErrClosed = errors.New("use of closed connection")
+
+// A Connection is a connection to an OMAPI-enabled server. It is safe
+// for concurrent use; multiple queries may be in flight at the same
+// time and responses are matched to their queries by transaction ID.
 type Connection struct {
 	authenticator Authenticator
+	keyName       string
 	connection    net.Conn
-	inBuffer      *bytes.Buffer
+	decoder       *Decoder
+
+	writeMu sync.Mutex
+	encoder *Encoder
+
+	logger      *slog.Logger
+	interceptor Interceptor
+	operations  OperationInterceptor
+
+	mu         sync.Mutex
+	pending    map[int32]chan *Message
+	err        error
+	notify     map[int]func(*Message)
+	nextNotify int
+}
+
+// A Dialer contains options for connecting to a server. The zero
+// value is ready to use.
+type Dialer struct {
+	// Limits restrict the messages accepted from the server. A
+	// server that exceeds them breaks the connection with a
+	// *ProtocolError.
+	Limits Limits
+
+	// Logger, if set, receives debug records for every query and
+	// a warning when the connection breaks. Values and signatures
+	// are never logged.
+	Logger *slog.Logger
+
+	// Interceptors wrap every query of the connection, including
+	// the one that opens the authenticator, in the order given. See
+	// Interceptor.
+	Interceptors []Interceptor
+
+	// OperationInterceptors wrap the connection's high-level
+	// operations, such as FindHost, in the order given. See
+	// OperationInterceptor.
+	OperationInterceptors []OperationInterceptor
 }
 
 // Dial establishes a connection to an OMAPI-enabled server.
 func Dial(addr, username, key string) (*Connection, error) {
+	return DialContext(context.Background(), addr, username, key)
+}
+
+// DialContext is like Dial but takes a context, see
+// Dialer.DialContext.
+func DialContext(ctx context.Context, addr, username, key string) (*Connection, error) {
+	var d Dialer
+
+	return d.DialContext(ctx, addr, username, key)
+}
+
+// Dial establishes a connection to an OMAPI-enabled server using the
+// dialer's options.
+func (d *Dialer) Dial(addr, username, key string) (*Connection, error) {
+	return d.DialContext(context.Background(), addr, username, key)
+}
+
+// DialContext is like Dial but gives up when the context is done
+// before the connection is established and authenticated. Once Dial
+// has returned, the context no longer affects the connection.
+func (d *Dialer) DialContext(ctx context.Context, addr, username, key string) (*Connection, error) {
 	con := &Connection{
 		authenticator: new(nullAuthenticator),
-		inBuffer:      new(bytes.Buffer),
+		pending:       make(map[int32]chan *Message),
+		logger:        loggerOrDiscard(d.Logger).With("server", addr),
+	}
+	if len(d.Interceptors) > 0 {
+		con.interceptor = ChainInterceptors(d.Interceptors...)
+	}
+	if len(d.OperationInterceptors) > 0 {
+		con.operations = ChainOperationInterceptors(d.OperationInterceptors...)
 	}
 
 	var newAuth Authenticator = new(nullAuthenticator)
@@ -28,31 +101,172 @@ func Dial(addr, username, key string) (*Connection, error) {
 	if len(username) > 0 && len(key) > 0 {
 		decodedKey, err := base64.StdEncoding.DecodeString(key)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("invalid key: %w", err)
 		}
 		newAuth = &hmacMD5Authenticator{username, decodedKey, -1}
+		con.keyName = username
 	}
 
-	tcpConn, err := net.Dial("tcp", addr)
+	var netDialer net.Dialer
+	tcpConn, err := netDialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return nil, err
 	}
 
 	con.connection = tcpConn
+	con.encoder = NewEncoder(tcpConn)
 
-	con.sendProtocolInitialization()
-	if err := con.receiveProtocolInitialization(); err != nil {
+	// Interrupt the startup exchange when ctx is done.
+	stop := context.AfterFunc(ctx, func() { tcpConn.SetDeadline(time.Now()) })
+	err = WriteStartup(tcpConn)
+	if err == nil {
+		err = ReadStartup(tcpConn)
+	}
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		tcpConn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, err
 	}
 
-	if err := con.initializeAuthenticator(newAuth); err != nil {
+	con.decoder = NewDecoder(tcpConn)
+	con.decoder.Limits = d.Limits
+
+	go con.receive()
+
+	if err := con.initializeAuthenticator(ctx, newAuth); err != nil {
+		con.Close()
 		return nil, err
 	}
 
 	return con, nil
 }
 
-func (con *Connection) initializeAuthenticator(auth Authenticator) error {
+// Close closes the connection. Queries that are still waiting for a
+// response will fail.
+func (con *Connection) Close() error {
+	con.fail(ErrClosed)
+
+	return nil
+}
+
+// RemoteAddr returns the address of the server.
+func (con *Connection) RemoteAddr() net.Addr {
+	return con.connection.RemoteAddr()
+}
+
+// KeyName returns the name of the key that the connection is
+// authenticated with, or "" if it isn't authenticated.
+func (con *Connection) KeyName() string {
+	return con.keyName
+}
+
+// Err returns the error that caused the connection to break, or nil
+// if it is still usable.
+func (con *Connection) Err() error {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+
+	return con.err
+}
+
+// fail marks the connection as broken, closes the socket and wakes up
+// all pending queries. Only the first error is recorded.
+func (con *Connection) fail(err error) {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+
+	if con.err != nil {
+		return
+	}
+
+	con.err = err
+	con.connection.Close()
+
+	if !errors.Is(err, ErrClosed) {
+		con.logger.Warn("Connection broken", "error", err)
+	}
+
+	for tid, ch := range con.pending {
+		close(ch)
+		delete(con.pending, tid)
+	}
+}
+
+// receive reads messages from the server and hands them to the
+// queries waiting for them, until the connection breaks.
+func (con *Connection) receive() {
+	for {
+		message, err := con.decoder.Decode()
+		if err != nil {
+			con.fail(err)
+			return
+		}
+
+		if message.Opcode == OpNotify {
+			con.dispatchNotify(message)
+			continue
+		}
+
+		con.mu.Lock()
+		ch, ok := con.pending[message.ResponseID]
+		delete(con.pending, message.ResponseID)
+		con.mu.Unlock()
+
+		if !ok {
+			con.logger.LogAttrs(context.Background(), slog.LevelDebug, "Discarding unsolicited message", messageAttrs(message)...)
+			continue
+		}
+
+		ch <- message
+	}
+}
+
+// OnNotify registers fn to be called with every notify message the
+// server sends, which some servers send when an object changes. fn is
+// called from the goroutine that reads responses, so it must neither
+// block nor query the connection. The returned function unregisters
+// fn.
+func (con *Connection) OnNotify(fn func(msg *Message)) (remove func()) {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+
+	if con.notify == nil {
+		con.notify = make(map[int]func(*Message))
+	}
+	id := con.nextNotify
+	con.nextNotify++
+	con.notify[id] = fn
+
+	return func() {
+		con.mu.Lock()
+		defer con.mu.Unlock()
+
+		delete(con.notify, id)
+	}
+}
+
+func (con *Connection) dispatchNotify(message *Message) {
+	con.mu.Lock()
+	handlers := make([]func(*Message), 0, len(con.notify))
+	for _, fn := range con.notify {
+		handlers = append(handlers, fn)
+	}
+	con.mu.Unlock()
+
+	if len(handlers) == 0 {
+		con.logger.LogAttrs(context.Background(), slog.LevelDebug, "Discarding notify message", messageAttrs(message)...)
+	}
+	for _, fn := range handlers {
+		fn(message)
+	}
+}
+
+func (con *Connection) initializeAuthenticator(ctx context.Context, auth Authenticator) error {
 	if _, ok := auth.(*nullAuthenticator); ok {
 		return nil
 	}
@@ -62,7 +276,10 @@ func (con *Connection) initializeAuthenticator(auth Authenticator) error {
 		message.Object[key] = value
 	}
 
-	response, _ := con.Query(message)
+	response, status := con.QueryContext(ctx, message)
+	if status.IsError() {
+		return status
+	}
 
 	if response.Opcode != OpUpdate {
 		return errors.New("received non-update response for open")
@@ -82,187 +299,249 @@ func (con *Connection) initializeAuthenticator(auth Authenticator) error {
 // returns the underlying response as well as its representation as a
 // status. If the message didn't contain a status, the success status
 // will be returned instead.
+//
+// If the connection breaks before a response arrives, a "not
+// connected" status, or "protocol error" if the server sent an
+// invalid message, is returned and Err reports the cause. On an
+// authenticated connection, a response with an invalid signature is
+// discarded and "invalid TSIG key" is returned.
+//
+// Every query assigns the message a new transaction ID, so that a
+// message can be sent again without a late response to the earlier
+// send being taken for the response to the new one.
 func (con *Connection) Query(msg *Message) (*Message, Status) {
-	msg.Sign(con.authenticator)
-
-	log.Debugf("Sending query: %s", msg)
+	return con.QueryContext(context.Background(), msg)
+}
 
-	con.send(msg.Bytes(false))
-	response := con.parseMessage()
-	if !response.IsResponseTo(msg) {
-		log.Fatal("received message is not the desired response")
+// QueryContext is like Query but stops waiting for the response when
+// the context is done, in which case an "operation canceled" or, for
+// an exceeded deadline, a "timed out" status is returned. The
+// server may still act on the query.
+//
+// The query passes through the connection's interceptors, if it has
+// any.
+func (con *Connection) QueryContext(ctx context.Context, msg *Message) (*Message, Status) {
+	if con.interceptor != nil {
+		return con.interceptor(ctx, msg, con, con.query)
 	}
 
-	// TODO check authid
-
-	log.Debugf("Query response: %s", response)
-
-	return response, response.ToStatus()
-}
-
-func (con *Connection) send(data []byte) (n int, err error) {
-	return con.connection.Write(data)
+	return con.query(ctx, msg)
 }
 
-func (con *Connection) sendProtocolInitialization() {
-	buf := newBuffer()
-	buf.add(int32(100)) // Protocol version
-	buf.add(int32(24))  // Header size
-	con.send(buf.bytes())
-}
+// query sends a message and waits for the response, without
+// interceptors.
+func (con *Connection) query(ctx context.Context, msg *Message) (*Message, Status) {
+	ch := make(chan *Message, 1)
 
-func (con *Connection) read() {
-	buf := make([]byte, 2048)
-	n, err := con.connection.Read(buf)
-	if err != nil {
-		log.Fatal(err)
+	con.mu.Lock()
+	if con.err != nil {
+		con.mu.Unlock()
+		status := con.brokenStatus()
+		return newStatusMessage(msg, status), status
 	}
+	for {
+		msg.TransactionID = newTransactionID()
+		if _, ok := con.pending[msg.TransactionID]; !ok {
+			break
+		}
+	}
+	con.pending[msg.TransactionID] = ch
+	con.mu.Unlock()
 
-	con.inBuffer.Write(buf[0:n])
-}
+	msg.Sign(con.authenticator)
 
-func (con *Connection) waitForN(n int) {
-	for con.inBuffer.Len() < n {
-		con.read()
+	start := time.Now()
+	if err := con.send(msg); err != nil {
+		con.fail(err)
 	}
-}
-
-func (con *Connection) parseStartupMessage() (version, headerSize int32) {
-	con.waitForN(8) // version, headerSize
 
-	binary.Read(con.inBuffer, binary.BigEndian, &version)
-	binary.Read(con.inBuffer, binary.BigEndian, &headerSize)
+	select {
+	case response, ok := <-ch:
+		if !ok {
+			status := con.brokenStatus()
+			con.logQuery(ctx, msg, nil, status, start)
+			return newStatusMessage(msg, status), status
+		}
 
-	return
-}
+		// Responses on an authenticated connection are signed with
+		// its key; a response that isn't can't be trusted.
+		if con.authenticator.AuthLen() > 0 && !response.Verify(con.authenticator) {
+			status := Statuses[48] // invalid TSIG key
+			status.Text = "invalid signature on response"
+			con.logQuery(ctx, msg, response, status, start)
+			return newStatusMessage(msg, status), status
+		}
 
-func (con *Connection) parseMap() map[string][]byte {
-	dict := make(map[string][]byte)
+		status := response.ToStatus()
+		con.logQuery(ctx, msg, response, status, start)
 
-	var (
-		keyLength   int16
-		valueLength int32
-		key         []byte
-		value       []byte
-	)
+		return response, status
+	case <-ctx.Done():
+		con.mu.Lock()
+		delete(con.pending, msg.TransactionID)
+		con.mu.Unlock()
 
-	for {
-		con.waitForN(2) // key length
-		binary.Read(con.inBuffer, binary.BigEndian, &keyLength)
-		if keyLength == 0 {
-			// end of map
-			break
-		}
+		status := contextStatus(ctx)
+		con.logQuery(ctx, msg, nil, status, start)
 
-		con.waitForN(int(keyLength)) // key
-		key = make([]byte, keyLength)
-		con.inBuffer.Read(key)
+		return newStatusMessage(msg, status), status
+	}
+}
 
-		con.waitForN(4) // value length
-		binary.Read(con.inBuffer, binary.BigEndian, &valueLength)
-		con.waitForN(int(valueLength)) // value
-		value = make([]byte, valueLength)
-		con.inBuffer.Read(value)
+// logQuery logs a query, with its response if there is one.
+func (con *Connection) logQuery(ctx context.Context, msg, response *Message, status Status, start time.Time) {
+	if !con.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
 
-		dict[string(key)] = value
+	attrs := append(messageAttrs(msg),
+		slog.String("status", status.Message),
+		slog.Duration("latency", time.Since(start)))
+	if response != nil {
+		attrs = append(attrs, slog.Attr{Key: "response", Value: slog.GroupValue(messageAttrs(response)...)})
 	}
 
-	return dict
+	con.logger.LogAttrs(ctx, slog.LevelDebug, "Query", attrs...)
 }
 
-func (con *Connection) parseMessage() *Message {
-	message := new(Message)
-	con.waitForN(24) // authid + authlen + opcode + handle + tid + rid
+// contextStatus returns the status for a query that was given up on
+// because its context is done.
+func contextStatus(ctx context.Context) Status {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return Statuses[2] // timed out
+	}
 
-	var authlen int32
+	return Statuses[20] // operation canceled
+}
 
-	binary.Read(con.inBuffer, binary.BigEndian, &message.AuthID)
-	binary.Read(con.inBuffer, binary.BigEndian, &authlen)
-	binary.Read(con.inBuffer, binary.BigEndian, &message.Opcode)
-	binary.Read(con.inBuffer, binary.BigEndian, &message.Handle)
-	binary.Read(con.inBuffer, binary.BigEndian, &message.TransactionID)
-	binary.Read(con.inBuffer, binary.BigEndian, &message.ResponseID)
+// brokenStatus returns the status that queries fail with once the
+// connection is broken.
+func (con *Connection) brokenStatus() Status {
+	var protocolErr *ProtocolError
+	if errors.As(con.Err(), &protocolErr) {
+		return Statuses[38] // protocol error
+	}
 
-	message.Message = con.parseMap()
-	message.Object = con.parseMap()
+	return Statuses[40] // not connected
+}
 
-	con.waitForN(int(authlen)) // signature
-	message.Signature = make([]byte, authlen)
-	con.inBuffer.Read(message.Signature)
+func (con *Connection) send(msg *Message) error {
+	con.writeMu.Lock()
+	defer con.writeMu.Unlock()
 
-	return message
+	return con.encoder.Encode(msg)
 }
 
-func (con *Connection) receiveProtocolInitialization() error {
-	version, headerSize := con.parseStartupMessage()
-	if version != 100 {
-		return errors.New("version mismatch")
+// operation runs one of the connection's high-level operations
+// through its operation interceptors.
+func (con *Connection) operation(ctx context.Context, op string, run func(ctx context.Context) error) error {
+	if con.operations != nil {
+		return con.operations(ctx, op, con, run)
 	}
 
-	if headerSize != 24 {
-		return errors.New("header size mismatch")
-	}
-
-	return nil
+	return run(ctx)
 }
 
+// FindHost finds a host by the fields set in host, such as its name
+// or hardware address.
 func (con *Connection) FindHost(host Host) (Host, error) {
-	message := NewOpenMessage("host")
+	return con.FindHostContext(context.Background(), host)
+}
 
-	message.Object = host.toObject()
+// FindHostContext is like FindHost but takes a context, see
+// QueryContext.
+func (con *Connection) FindHostContext(ctx context.Context, host Host) (found Host, err error) {
+	err = con.operation(ctx, "FindHost", func(ctx context.Context) error {
+		message := NewOpenMessage("host")
 
-	response, status := con.Query(message)
-	if response.Opcode == OpUpdate {
-		return response.ToHost(), nil
-	}
+		message.Object = host.toObject()
+
+		response, status := con.QueryContext(ctx, message)
+		if response.Opcode != OpUpdate {
+			return status
+		}
 
-	return Host{}, status
+		found = response.ToHost()
+		return nil
+	})
+
+	return found, err
 }
 
+// FindLease finds a lease by the fields set in lease.
 func (con *Connection) FindLease(lease Lease) (Lease, error) {
-	// - IP works
-	// - DHCPClientIdentifier works
-	// - State does not, even though documentation claims it does
-	// - ClientHostname does not, even though documentation claims it does
-	message := NewOpenMessage("lease")
+	return con.FindLeaseContext(context.Background(), lease)
+}
 
-	message.Object = lease.toObject()
+// FindLeaseContext is like FindLease but takes a context, see
+// QueryContext.
+func (con *Connection) FindLeaseContext(ctx context.Context, lease Lease) (found Lease, err error) {
+	err = con.operation(ctx, "FindLease", func(ctx context.Context) error {
+		// - IP works
+		// - DHCPClientIdentifier works
+		// - State does not, even though documentation claims it does
+		// - ClientHostname does not, even though documentation claims it does
+		message := NewOpenMessage("lease")
+
+		message.Object = lease.toObject()
+
+		response, status := con.QueryContext(ctx, message)
+		if response.Opcode != OpUpdate {
+			return status
+		}
 
-	response, status := con.Query(message)
-	if response.Opcode == OpUpdate {
-		return response.ToLease(), nil
-	}
+		found = response.ToLease()
+		return nil
+	})
 
-	return Lease{}, status
+	return found, err
 }
 
 // FindFailover finds a failover-state given its name.
 func (con *Connection) FindFailover(name string) (Failover, error) {
-	message := NewOpenMessage("failover-state")
+	return con.FindFailoverContext(context.Background(), name)
+}
 
-	message.Object["name"] = []byte(name)
+// FindFailoverContext is like FindFailover but takes a context, see
+// QueryContext.
+func (con *Connection) FindFailoverContext(ctx context.Context, name string) (found Failover, err error) {
+	err = con.operation(ctx, "FindFailover", func(ctx context.Context) error {
+		message := NewOpenMessage("failover-state")
 
-	response, status := con.Query(message)
-	if response.Opcode == OpUpdate {
-		return response.ToFailover(), nil
-	}
+		message.Object["name"] = []byte(name)
+
+		response, status := con.QueryContext(ctx, message)
+		if response.Opcode != OpUpdate {
+			return status
+		}
+
+		found = response.ToFailover()
+		return nil
+	})
 
-	return Failover{}, status
+	return found, err
 }
 
 // Delete deletes an object from the server, given its handle.
 func (con *Connection) Delete(handle int32) error {
-	message := NewMessage()
-	message.Opcode = OpDelete
-	message.Handle = handle
+	return con.DeleteContext(context.Background(), handle)
+}
 
-	_, status := con.Query(message)
+// DeleteContext is like Delete but takes a context, see QueryContext.
+func (con *Connection) DeleteContext(ctx context.Context, handle int32) error {
+	return con.operation(ctx, "Delete", func(ctx context.Context) error {
+		message := NewMessage()
+		message.Opcode = OpDelete
+		message.Handle = handle
 
-	if status.IsError() {
-		return status
-	}
+		_, status := con.QueryContext(ctx, message)
 
-	return nil
+		if status.IsError() {
+			return status
+		}
+
+		return nil
+	})
 }
 
 // CreateHost creates a new host object on the server. The passed
@@ -293,54 +572,211 @@ func (con *Connection) Delete(handle int32) error {
 //		// OMAPI representation of it, including a handle
 //	}
 func (con *Connection) CreateHost(host Host) (Host, error) {
-	message := NewCreateMessage("host")
-	message.Object = host.toObject()
+	return con.CreateHostContext(context.Background(), host)
+}
 
-	// The server doesn't currently care about Known
+// CreateHostContext is like CreateHost but takes a context, see
+// QueryContext.
+func (con *Connection) CreateHostContext(ctx context.Context, host Host) (created Host, err error) {
+	err = con.operation(ctx, "CreateHost", func(ctx context.Context) error {
+		message := NewCreateMessage("host")
+		message.Object = host.toObject()
 
-	// if host.Known {
-	//	message.Object["known"] = True
-	// } else {
-	//	message.Object["known"] = False
-	// }
+		// The server doesn't currently care about Known
 
-	response, status := con.Query(message)
+		// if host.Known {
+		//	message.Object["known"] = True
+		// } else {
+		//	message.Object["known"] = False
+		// }
 
-	if status.IsError() {
-		return Host{}, status
+		response, status := con.QueryContext(ctx, message)
+
+		if status.IsError() {
+			return status
+		}
+
+		created = response.ToHost()
+		return nil
+	})
+
+	return created, err
+}
+
+// OpenObject looks up an object of the given type by the given values
+// and returns the server's response, which holds the object's handle
+// and values. It is the generic form of FindHost and friends, for
+// object types and values this package doesn't model.
+func (con *Connection) OpenObject(typeName string, values map[string][]byte) (*Message, error) {
+	return con.OpenObjectContext(context.Background(), typeName, values)
+}
+
+// OpenObjectContext is like OpenObject but takes a context, see
+// QueryContext.
+func (con *Connection) OpenObjectContext(ctx context.Context, typeName string, values map[string][]byte) (*Message, error) {
+	message := NewOpenMessage(typeName)
+	for key, value := range values {
+		message.Object[key] = value
 	}
 
-	return response.ToHost(), nil
+	return con.queryObject(ctx, "OpenObject", message)
 }
 
-/* func (con *Connection) Update(handle int32, object Object) error {
-	// Notes: Cannot change a host's name
+// CreateObject creates a new object of the given type with the given
+// values and returns the server's response, which holds the object's
+// handle and values. It fails if the object already exists.
+func (con *Connection) CreateObject(typeName string, values map[string][]byte) (*Message, error) {
+	return con.CreateObjectContext(context.Background(), typeName, values)
+}
+
+// CreateObjectContext is like CreateObject but takes a context, see
+// QueryContext.
+func (con *Connection) CreateObjectContext(ctx context.Context, typeName string, values map[string][]byte) (*Message, error) {
+	message := NewCreateMessage(typeName)
+	for key, value := range values {
+		message.Object[key] = value
+	}
 
-	message := NewUpdateMessage(handle)
-	message.Object = object.toUpdateObject()
+	return con.queryObject(ctx, "CreateObject", message)
+}
 
-	// Do not transmit empty fields. And as far as we know, unsetting
-	// fields doesn't work, anyway.
-	for key := range message.Object {
-		if len(message.Object[key]) == 0 {
-			delete(message.Object, key)
+// RefreshObject returns the server's response to refreshing an object,
+// given its handle, which holds the object's current values.
+func (con *Connection) RefreshObject(handle int32) (*Message, error) {
+	return con.RefreshObjectContext(context.Background(), handle)
+}
+
+// RefreshObjectContext is like RefreshObject but takes a context, see
+// QueryContext.
+func (con *Connection) RefreshObjectContext(ctx context.Context, handle int32) (*Message, error) {
+	message := NewMessage()
+	message.Opcode = OpRefresh
+	message.Handle = handle
+
+	return con.queryObject(ctx, "RefreshObject", message)
+}
+
+// queryObject sends a message that the server answers with an update
+// message describing an object, as the operation with the given name.
+func (con *Connection) queryObject(ctx context.Context, op string, message *Message) (response *Message, err error) {
+	err = con.operation(ctx, op, func(ctx context.Context) error {
+		var status Status
+		response, status = con.QueryContext(ctx, message)
+		if status.IsError() {
+			return status
 		}
+
+		if response.Opcode != OpUpdate {
+			return errors.New("received non-update response for object query")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// The dhcp client identifier can only be changed if it was empty
-	// before, so right now, we don't allow setting it at all.
-	delete(message.Object, "dhcp-client-identifier")
+	return response, nil
+}
 
-	// TODO unset fields that cause nasty crashes
-	_, status := con.Query(message)
+// Update changes the values of an object on the server, given its
+// handle. Empty values are not transmitted, because unsetting values
+// doesn't work, as far as we know.
+//
+// Not every value can be changed: a host's name is fixed, and its
+// dhcp client identifier can only be set if it was empty before.
+func (con *Connection) Update(handle int32, values map[string][]byte) error {
+	return con.UpdateContext(context.Background(), handle, values)
+}
 
-	if status.IsError() {
-		return status
-	}
+// UpdateContext is like Update but takes a context, see QueryContext.
+func (con *Connection) UpdateContext(ctx context.Context, handle int32, values map[string][]byte) error {
+	return con.operation(ctx, "Update", func(ctx context.Context) error {
+		message := NewUpdateMessage(handle)
+		for key, value := range values {
+			if len(value) > 0 {
+				message.Object[key] = value
+			}
+		}
 
-	return nil
-} */
+		_, status := con.QueryContext(ctx, message)
+
+		if status.IsError() {
+			return status
+		}
+
+		return nil
+	})
+}
+
+// ReleaseLease releases a lease, found by the same fields as
+// FindLease, by setting its end time to the past, like
+// "set ends = 00:00:00:00" in omshell.
+func (con *Connection) ReleaseLease(lease Lease) error {
+	return con.ReleaseLeaseContext(context.Background(), lease)
+}
+
+// ReleaseLeaseContext is like ReleaseLease but takes a context, see
+// QueryContext.
+func (con *Connection) ReleaseLeaseContext(ctx context.Context, lease Lease) error {
+	return con.operation(ctx, "ReleaseLease", func(ctx context.Context) error {
+		found, err := con.FindLeaseContext(ctx, lease)
+		if err != nil {
+			return err
+		}
+
+		message := NewUpdateMessage(found.Handle)
+		message.Object["ends"] = int32ToBytes(0)
+
+		_, status := con.QueryContext(ctx, message)
+
+		if status.IsError() {
+			return status
+		}
+
+		return nil
+	})
+}
+
+// SetFailoverState sets the local state of a failover-state, given its
+// name. This is how a server is put into partner-down state when its
+// partner is known to be down.
+func (con *Connection) SetFailoverState(name string, state FailoverState) error {
+	return con.SetFailoverStateContext(context.Background(), name, state)
+}
+
+// SetFailoverStateContext is like SetFailoverState but takes a
+// context, see QueryContext.
+func (con *Connection) SetFailoverStateContext(ctx context.Context, name string, state FailoverState) error {
+	return con.operation(ctx, "SetFailoverState", func(ctx context.Context) error {
+		failover := NewOpenMessage("failover-state")
+		failover.Object["name"] = []byte(name)
+
+		response, status := con.QueryContext(ctx, failover)
+		if status.IsError() {
+			return status
+		}
+
+		return con.UpdateContext(ctx, response.Handle, map[string][]byte{"local-state": state.toBytes()})
+	})
+}
+
+// Shutdown tells the server to shut down.
+func (con *Connection) Shutdown() error {
+	return con.ShutdownContext(context.Background())
+}
+
+// ShutdownContext is like Shutdown but takes a context, see
+// QueryContext.
+func (con *Connection) ShutdownContext(ctx context.Context) error {
+	return con.operation(ctx, "Shutdown", func(ctx context.Context) error {
+		control := NewOpenMessage("control")
+
+		response, status := con.QueryContext(ctx, control)
+		if status.IsError() {
+			return status
+		}
 
-func (con *Connection) Shutdown() {
-	// open Control object, set state to 2, update object, rejoice
+		return con.UpdateContext(ctx, response.Handle, map[string][]byte{"state": int32ToBytes(2)})
+	})
 }
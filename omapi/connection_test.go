@@ -0,0 +1,77 @@
+package omapi_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestQueryTransactionID(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet})
+
+	con, err := omapi.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	msg := omapi.NewOpenMessage("host")
+	msg.Object["name"] = []byte("a")
+
+	// Sending the same message again gives it a new transaction ID,
+	// and the response is the one to the new ID.
+	seen := make(map[int32]bool)
+	for i := 0; i < 3; i++ {
+		response, status := con.Query(msg)
+		if status.IsError() {
+			t.Fatalf("query %d: %v", i, status)
+		}
+		if seen[msg.TransactionID] {
+			t.Errorf("query %d reused transaction ID %d", i, msg.TransactionID)
+		}
+		seen[msg.TransactionID] = true
+
+		if response.ResponseID != msg.TransactionID {
+			t.Errorf("query %d: got response to %d, want %d", i, response.ResponseID, msg.TransactionID)
+		}
+	}
+}
+
+func TestDialContextTimeout(t *testing.T) {
+	// A server that accepts connections but never sends its startup
+	// message.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = omapi.DialContext(ctx, ln.Addr().String(), "", "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DialContext error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("DialContext took %v", elapsed)
+	}
+}
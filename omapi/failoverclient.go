@@ -0,0 +1,311 @@
+package omapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A FailoverClient talks to both servers of a failover pair. dhcpd
+// replicates leases between failover peers, but not host objects, so
+// host mutations are sent to both peers while lease lookups go to
+// whichever peer is healthy, falling back to the other one.
+//
+// The client learns each peer's role and state with FindFailover when
+// Refresh is called. Call it before the first query and whenever the
+// state may have changed, for example periodically.
+//
+// Example:
+//
+//	client := omapi.NewFailoverClient("dhcp-failover", con1, con2)
+//	if err := client.Refresh(); err != nil {
+//		// At least one of the peers couldn't be queried
+//	}
+//
+//	_, err := client.CreateHost(host)
+//	var divergence *omapi.DivergenceError
+//	if errors.As(err, &divergence) {
+//		// The host only exists on one of the peers
+//	}
+type FailoverClient struct {
+	name string
+
+	mu    sync.Mutex
+	peers []PeerState // primary first once known
+}
+
+// PeerState is what a FailoverClient knows about one of its peers.
+type PeerState struct {
+	Conn *Connection
+
+	// Failover is the peer's failover-state as of the last Refresh.
+	Failover Failover
+
+	// Err is set if the peer couldn't be queried during the last
+	// Refresh, or if Refresh hasn't been called yet.
+	Err error
+}
+
+// Hierarchy returns the peer's role, as reported by itself.
+func (p PeerState) Hierarchy() FailoverHierarchy {
+	return p.Failover.Hierarchy
+}
+
+// health reports how suitable the peer is for queries; lower is
+// better. Peers in normal or partner-down state are in charge of their
+// leases, peers in other states can still answer but may be behind,
+// and broken peers are a last resort.
+func (p PeerState) health() int {
+	switch {
+	case p.Err != nil || p.Conn.Err() != nil:
+		return 2
+	case p.Failover.LocalState == FailoverStateNormal, p.Failover.LocalState == FailoverStatePartnerDown:
+		return 0
+	}
+
+	return 1
+}
+
+var errNotRefreshed = errors.New("failover state not queried yet")
+
+// NewFailoverClient returns a client for the failover-state with the
+// given name, the name of the failover peer declaration, on the two
+// servers of a pair. It doesn't query the servers; call Refresh for
+// that.
+func NewFailoverClient(name string, a, b *Connection) *FailoverClient {
+	return &FailoverClient{
+		name: name,
+		peers: []PeerState{
+			{Conn: a, Err: errNotRefreshed},
+			{Conn: b, Err: errNotRefreshed},
+		},
+	}
+}
+
+// Refresh queries the failover-state of both peers. It returns an
+// error if either of them couldn't be queried, or if both claim the
+// same role, but the client remains usable with whatever it learned.
+func (fc *FailoverClient) Refresh() error {
+	return fc.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh but takes a context, see
+// Connection.QueryContext.
+func (fc *FailoverClient) RefreshContext(ctx context.Context) error {
+	fc.mu.Lock()
+	peers := append([]PeerState(nil), fc.peers...)
+	fc.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := range peers {
+		wg.Add(1)
+		go func(peer *PeerState) {
+			defer wg.Done()
+			peer.Failover, peer.Err = peer.Conn.FindFailoverContext(ctx, fc.name)
+		}(&peers[i])
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, peer := range peers {
+		if peer.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", peer.Conn.RemoteAddr(), peer.Err))
+		}
+	}
+
+	if peers[0].Err == nil && peers[1].Err == nil {
+		if peers[0].Hierarchy() == peers[1].Hierarchy() {
+			errs = append(errs, fmt.Errorf("both peers are %s", peers[0].Hierarchy()))
+		} else if peers[0].Hierarchy() == HierarchySecondary {
+			peers[0], peers[1] = peers[1], peers[0]
+		}
+	}
+
+	fc.mu.Lock()
+	fc.peers = peers
+	fc.mu.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// Peers returns what the client knows about both peers, the primary
+// first if their roles are known.
+func (fc *FailoverClient) Peers() []PeerState {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return append([]PeerState(nil), fc.peers...)
+}
+
+// byHealth returns the peers, the healthiest first. The primary wins
+// ties.
+func (fc *FailoverClient) byHealth() []PeerState {
+	peers := fc.Peers()
+	sort.SliceStable(peers, func(i, j int) bool { return peers[i].health() < peers[j].health() })
+
+	return peers
+}
+
+// FindLease looks up a lease on the healthiest peer, and on the other
+// one if that fails.
+func (fc *FailoverClient) FindLease(lease Lease) (Lease, error) {
+	return fc.FindLeaseContext(context.Background(), lease)
+}
+
+// FindLeaseContext is like FindLease but takes a context, see
+// Connection.QueryContext.
+func (fc *FailoverClient) FindLeaseContext(ctx context.Context, lease Lease) (Lease, error) {
+	var firstErr error
+	for _, peer := range fc.byHealth() {
+		found, err := peer.Conn.FindLeaseContext(ctx, lease)
+		if err == nil {
+			return found, nil
+		}
+
+		// Prefer "not found" from a working peer over the other
+		// peer being unreachable.
+		if firstErr == nil || (errors.Is(err, ErrNotFound) && !errors.Is(firstErr, ErrNotFound)) {
+			firstErr = err
+		}
+	}
+
+	return Lease{}, firstErr
+}
+
+// FindHost looks up a host on the healthiest peer, and on the other one
+// if that fails. Use CompareHosts to check whether the peers agree.
+func (fc *FailoverClient) FindHost(host Host) (Host, error) {
+	return fc.FindHostContext(context.Background(), host)
+}
+
+// FindHostContext is like FindHost but takes a context, see
+// Connection.QueryContext.
+func (fc *FailoverClient) FindHostContext(ctx context.Context, host Host) (Host, error) {
+	var firstErr error
+	for _, peer := range fc.byHealth() {
+		found, err := peer.Conn.FindHostContext(ctx, host)
+		if err == nil {
+			return found, nil
+		}
+
+		if firstErr == nil || (errors.Is(err, ErrNotFound) && !errors.Is(firstErr, ErrNotFound)) {
+			firstErr = err
+		}
+	}
+
+	return Host{}, firstErr
+}
+
+// A DivergenceError is returned when a mutation succeeded on one peer
+// but failed on the other, leaving the peers out of sync. It wraps the
+// error of the failed peer.
+type DivergenceError struct {
+	Op        string
+	Succeeded *Connection
+	Failed    *Connection
+	Err       error
+}
+
+func (e *DivergenceError) Error() string {
+	return fmt.Sprintf("omapi: peers diverged: %s succeeded on %s but failed on %s: %s",
+		e.Op, e.Succeeded.RemoteAddr(), e.Failed.RemoteAddr(), e.Err)
+}
+
+func (e *DivergenceError) Unwrap() error {
+	return e.Err
+}
+
+// fanOut runs op on both peers concurrently, passing each the index of
+// its peer. An error matching done means that the peer was already in
+// the state op is meant to bring it to, such as a host to delete not
+// existing, which counts as success for that peer. If neither peer
+// succeeded or both were already done, the error of the first peer is
+// returned; if op failed on only one, a *DivergenceError. The index of
+// a peer on which op returned nil is returned, preferring the first,
+// or -1.
+func (fc *FailoverClient) fanOut(name string, peers []PeerState, done error, op func(i int, con *Connection) error) (int, error) {
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, con *Connection) {
+			defer wg.Done()
+			errs[i] = op(i, con)
+		}(i, peer.Conn)
+	}
+	wg.Wait()
+
+	settled := func(i int) bool { return errs[i] == nil || errors.Is(errs[i], done) }
+
+	switch {
+	case errs[0] == nil && settled(1):
+		return 0, nil
+	case errs[1] == nil && settled(0):
+		return 1, nil
+	case settled(0) == settled(1):
+		return -1, errs[0]
+	}
+
+	ok, failed := 0, 1
+	if !settled(0) {
+		ok, failed = 1, 0
+	}
+
+	i := ok
+	if errs[ok] != nil {
+		i = -1
+	}
+
+	return i, &DivergenceError{name, peers[ok].Conn, peers[failed].Conn, errs[failed]}
+}
+
+// CreateHost creates a host on both peers. It returns the host as
+// created on the primary, or on the secondary if only that created it.
+// A peer that already has the host is left alone, so that a create
+// that failed on one peer can be retried. If only one peer has the
+// host afterwards, the error is a *DivergenceError.
+func (fc *FailoverClient) CreateHost(host Host) (Host, error) {
+	return fc.CreateHostContext(context.Background(), host)
+}
+
+// CreateHostContext is like CreateHost but takes a context, see
+// Connection.QueryContext.
+func (fc *FailoverClient) CreateHostContext(ctx context.Context, host Host) (Host, error) {
+	var created [2]Host
+	peers := fc.Peers()
+
+	i, err := fc.fanOut("create host", peers, ErrAlreadyExists, func(i int, con *Connection) error {
+		var err error
+		created[i], err = con.CreateHostContext(ctx, host)
+		return err
+	})
+	if i < 0 {
+		return Host{}, err
+	}
+
+	return created[i], err
+}
+
+// DeleteHost looks up a host on both peers and deletes it. A peer that
+// doesn't have the host is left alone, so that a delete that failed on
+// one peer can be retried. If only one peer has the host afterwards,
+// the error is a *DivergenceError.
+func (fc *FailoverClient) DeleteHost(query Host) error {
+	return fc.DeleteHostContext(context.Background(), query)
+}
+
+// DeleteHostContext is like DeleteHost but takes a context, see
+// Connection.QueryContext.
+func (fc *FailoverClient) DeleteHostContext(ctx context.Context, query Host) error {
+	_, err := fc.fanOut("delete host", fc.Peers(), ErrNotFound, func(_ int, con *Connection) error {
+		host, err := con.FindHostContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		return con.DeleteContext(ctx, host.Handle)
+	})
+
+	return err
+}
@@ -0,0 +1,163 @@
+package omapi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+// startPair starts a failover pair, the secondary first, and returns
+// a refreshed client for it.
+func startPair(t *testing.T) (primary, secondary *omapitest.Server, client *omapi.FailoverClient) {
+	t.Helper()
+
+	primary, secondary = omapitest.NewServer(), omapitest.NewServer()
+	t.Cleanup(primary.Close)
+	t.Cleanup(secondary.Close)
+
+	primary.PutFailover(omapi.Failover{Name: "peer", Hierarchy: omapi.HierarchyPrimary, LocalState: omapi.FailoverStateNormal})
+	secondary.PutFailover(omapi.Failover{Name: "peer", Hierarchy: omapi.HierarchySecondary, LocalState: omapi.FailoverStateNormal})
+
+	var cons []*omapi.Connection
+	for _, server := range []*omapitest.Server{secondary, primary} {
+		con, err := omapi.Dial(server.Addr, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { con.Close() })
+		cons = append(cons, con)
+	}
+
+	client = omapi.NewFailoverClient("peer", cons[0], cons[1])
+	if err := client.RefreshContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return primary, secondary, client
+}
+
+func TestFailoverClientRefresh(t *testing.T) {
+	primary, _, client := startPair(t)
+
+	peers := client.Peers()
+	if peers[0].Hierarchy() != omapi.HierarchyPrimary || peers[0].Conn.RemoteAddr().String() != primary.Addr {
+		t.Errorf("first peer is %s at %s, want the primary at %s", peers[0].Hierarchy(), peers[0].Conn.RemoteAddr(), primary.Addr)
+	}
+}
+
+func TestFailoverClientCreateHost(t *testing.T) {
+	ctx := context.Background()
+	host := omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet}
+
+	t.Run("on both", func(t *testing.T) {
+		primary, secondary, client := startPair(t)
+
+		created, err := client.CreateHostContext(ctx, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.Name != "a" {
+			t.Errorf("created host %q, want a", created.Name)
+		}
+		if len(primary.Hosts()) != 1 || len(secondary.Hosts()) != 1 {
+			t.Errorf("primary has %d hosts and secondary %d, want 1 each", len(primary.Hosts()), len(secondary.Hosts()))
+		}
+	})
+
+	// Retrying a create that only succeeded on one peer brings the
+	// peers back in sync.
+	t.Run("existing on one", func(t *testing.T) {
+		primary, secondary, client := startPair(t)
+		secondary.PutHost(host)
+
+		created, err := client.CreateHostContext(ctx, host)
+		if err != nil {
+			t.Fatalf("got %v, want success", err)
+		}
+		if created.Name != "a" {
+			t.Errorf("created host %q, want a", created.Name)
+		}
+		if len(primary.Hosts()) != 1 || len(secondary.Hosts()) != 1 {
+			t.Errorf("primary has %d hosts and secondary %d, want 1 each", len(primary.Hosts()), len(secondary.Hosts()))
+		}
+	})
+
+	t.Run("existing on both", func(t *testing.T) {
+		primary, secondary, client := startPair(t)
+		primary.PutHost(host)
+		secondary.PutHost(host)
+
+		_, err := client.CreateHostContext(ctx, host)
+		var divergence *omapi.DivergenceError
+		if !errors.Is(err, omapi.ErrAlreadyExists) || errors.As(err, &divergence) {
+			t.Errorf("got %v, want already exists", err)
+		}
+	})
+}
+
+func TestFailoverClientDeleteHost(t *testing.T) {
+	ctx := context.Background()
+	host := omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet}
+
+	tests := []struct {
+		name               string
+		primary, secondary bool // whether the peers have the host
+		fault              omapi.Status
+		divergent          bool
+		want               error
+	}{
+		{name: "on both", primary: true, secondary: true},
+		{name: "missing on one", primary: true},
+		{name: "missing on both", want: omapi.ErrNotFound},
+		{name: "failing on one", primary: true, secondary: true, fault: omapi.Statuses[17], divergent: true, want: omapi.Statuses[17]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			primary, secondary, client := startPair(t)
+			if test.primary {
+				primary.PutHost(host)
+			}
+			if test.secondary {
+				secondary.PutHost(host)
+			}
+			secondary.InjectFault(omapitest.Fault{Status: test.fault})
+
+			err := client.DeleteHostContext(ctx, omapi.Host{Name: "a"})
+
+			var divergence *omapi.DivergenceError
+			if errors.As(err, &divergence) != test.divergent {
+				t.Errorf("got %v, divergent is %t", err, test.divergent)
+			}
+			if !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+			if len(primary.Hosts()) != 0 {
+				t.Errorf("primary still has the host")
+			}
+		})
+	}
+}
+
+func TestFailoverClientFindLease(t *testing.T) {
+	primary, secondary, client := startPair(t)
+	secondary.PutLease(omapi.Lease{IP: []byte{10, 0, 0, 5}, State: omapi.LeaseStateActive})
+
+	// The primary doesn't know the lease, so it's looked up on the
+	// secondary.
+	lease, err := client.FindLeaseContext(context.Background(), omapi.Lease{IP: []byte{10, 0, 0, 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.State != omapi.LeaseStateActive {
+		t.Errorf("got lease in state %s, want active", lease.State)
+	}
+
+	primary.DropConnections()
+	if _, err := client.FindLeaseContext(context.Background(), omapi.Lease{IP: []byte{10, 0, 0, 6}}); !errors.Is(err, omapi.ErrNotFound) {
+		t.Errorf("got %v, want not found from the secondary", err)
+	}
+}
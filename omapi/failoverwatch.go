@@ -0,0 +1,415 @@
+package omapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// FailoverEventKind is the kind of a FailoverEvent.
+type FailoverEventKind int
+
+const (
+	// The local or partner state changed.
+	FailoverLocalStateChanged FailoverEventKind = iota + 1
+	FailoverPartnerStateChanged
+
+	// The clock skew between the peers exceeds MaxSkew.
+	FailoverSkewHigh
+
+	// The number of unacknowledged updates grew on UnackedPolls
+	// consecutive polls. The condition ends once it shrinks.
+	FailoverUnackedGrowing
+
+	// Nothing has been received from the partner for longer than
+	// MaxStaleness.
+	FailoverPartnerStale
+
+	// The failover-state couldn't be queried.
+	FailoverUnreachable
+)
+
+func (k FailoverEventKind) String() string {
+	switch k {
+	case FailoverLocalStateChanged:
+		return "local-state-changed"
+	case FailoverPartnerStateChanged:
+		return "partner-state-changed"
+	case FailoverSkewHigh:
+		return "skew-high"
+	case FailoverUnackedGrowing:
+		return "unacked-updates-growing"
+	case FailoverPartnerStale:
+		return "partner-stale"
+	case FailoverUnreachable:
+		return "unreachable"
+	}
+
+	return "unknown"
+}
+
+func (k FailoverEventKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// A FailoverEvent is something a FailoverWatcher noticed. State changes
+// are reported once per change. Conditions, such as high skew, are
+// reported once when they start and once, with Resolved set, when they
+// end.
+type FailoverEvent struct {
+	Kind FailoverEventKind
+	Name string // of the failover-state
+	Time time.Time
+
+	// Resolved is set if a condition ended.
+	Resolved bool
+
+	// Previous and Current are the last two snapshots of the
+	// failover-state. Previous is empty for the first poll, and
+	// Current is the last known snapshot if the failover-state
+	// couldn't be queried.
+	Previous, Current Failover
+
+	// Err is why the failover-state couldn't be queried, for
+	// FailoverUnreachable events.
+	Err error
+}
+
+func (e FailoverEvent) String() string {
+	var msg string
+
+	switch e.Kind {
+	case FailoverLocalStateChanged:
+		msg = fmt.Sprintf("local state changed from %q to %q", e.Previous.LocalState, e.Current.LocalState)
+	case FailoverPartnerStateChanged:
+		msg = fmt.Sprintf("partner state changed from %q to %q", e.Previous.PartnerState, e.Current.PartnerState)
+	case FailoverSkewHigh:
+		msg = fmt.Sprintf("clock skew is %ds", e.Current.Skew)
+	case FailoverUnackedGrowing:
+		msg = fmt.Sprintf("%d unacked updates", e.Current.CurUnackedUpdates)
+	case FailoverPartnerStale:
+		msg = fmt.Sprintf("last heard from partner at %s", e.Current.LastTimestampReceived.Format(time.RFC3339))
+	case FailoverUnreachable:
+		msg = "unreachable"
+		if e.Err != nil {
+			msg += ": " + e.Err.Error()
+		}
+	default:
+		msg = e.Kind.String()
+	}
+
+	if e.Resolved {
+		msg = "resolved: " + msg
+	}
+
+	return fmt.Sprintf("failover %s: %s", e.Name, msg)
+}
+
+// A FailoverSink receives the events of a FailoverWatcher.
+type FailoverSink interface {
+	HandleFailoverEvent(ctx context.Context, event FailoverEvent) error
+}
+
+// FailoverSinkFunc adapts a function to a FailoverSink.
+type FailoverSinkFunc func(ctx context.Context, event FailoverEvent) error
+
+func (f FailoverSinkFunc) HandleFailoverEvent(ctx context.Context, event FailoverEvent) error {
+	return f(ctx, event)
+}
+
+// LogSink logs events with a slog.Logger, at warning level, or info
+// level for resolved conditions.
+type LogSink struct {
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+func (s LogSink) HandleFailoverEvent(ctx context.Context, event FailoverEvent) error {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	level := slog.LevelWarn
+	if event.Resolved {
+		level = slog.LevelInfo
+	}
+
+	logger.Log(ctx, level, event.String(),
+		"kind", event.Kind.String(),
+		"failover", event.Name,
+		"local-state", event.Current.LocalState.String(),
+		"partner-state", event.Current.PartnerState.String())
+
+	return nil
+}
+
+// WebhookSink posts events as JSON to a URL:
+//
+//	{
+//		"kind": "local-state-changed",
+//		"failover": "dhcp-failover",
+//		"time": "2024-05-01T12:00:00Z",
+//		"resolved": false,
+//		"message": "failover dhcp-failover: local state changed from \"normal\" to \"communications interrupted\"",
+//		"local-state": "communications interrupted",
+//		"partner-state": "normal",
+//		"skew": 0,
+//		"cur-unacked-updates": 0,
+//		"last-timestamp-received": "2024-05-01T11:59:30Z"
+//	}
+type WebhookSink struct {
+	URL string
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (s WebhookSink) HandleFailoverEvent(ctx context.Context, event FailoverEvent) error {
+	payload := struct {
+		Kind                  FailoverEventKind `json:"kind"`
+		Failover              string            `json:"failover"`
+		Time                  time.Time         `json:"time"`
+		Resolved              bool              `json:"resolved"`
+		Message               string            `json:"message"`
+		LocalState            string            `json:"local-state"`
+		PartnerState          string            `json:"partner-state"`
+		Skew                  int32             `json:"skew"`
+		CurUnackedUpdates     int32             `json:"cur-unacked-updates"`
+		LastTimestampReceived time.Time         `json:"last-timestamp-received"`
+		Error                 string            `json:"error,omitempty"`
+	}{
+		Kind:                  event.Kind,
+		Failover:              event.Name,
+		Time:                  event.Time,
+		Resolved:              event.Resolved,
+		Message:               event.String(),
+		LocalState:            event.Current.LocalState.String(),
+		PartnerState:          event.Current.PartnerState.String(),
+		Skew:                  event.Current.Skew,
+		CurUnackedUpdates:     event.Current.CurUnackedUpdates,
+		LastTimestampReceived: event.Current.LastTimestampReceived,
+	}
+	if event.Err != nil {
+		payload.Error = event.Err.Error()
+	}
+
+	return s.post(ctx, payload)
+}
+
+// post posts a payload as JSON.
+func (s WebhookSink) post(ctx context.Context, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// A FailoverWatcher polls a failover-state and reports changes and
+// problems to its sinks, so that a pair doesn't sit in
+// communications-interrupted unnoticed.
+//
+// Example:
+//
+//	watcher := &omapi.FailoverWatcher{
+//		Conn:         connection,
+//		Name:         "dhcp-failover",
+//		MaxSkew:      10,
+//		MaxStaleness: time.Minute,
+//		Sinks:        []omapi.FailoverSink{omapi.LogSink{}, omapi.WebhookSink{URL: url}},
+//	}
+//
+//	err := watcher.Run(ctx)
+//	// err is the reason the connection broke; dial again and rerun
+type FailoverWatcher struct {
+	Conn *Connection
+	Name string
+
+	// Interval is the time between polls. It defaults to 30 seconds.
+	Interval time.Duration
+
+	// MaxSkew is the clock skew, in seconds, above which a
+	// FailoverSkewHigh event is emitted. Zero disables the check.
+	MaxSkew int32
+
+	// UnackedPolls is the number of consecutive polls during which the
+	// number of unacked updates has to grow for a
+	// FailoverUnackedGrowing event. Zero disables the check.
+	UnackedPolls int
+
+	// MaxStaleness is how long ago the last message from the partner
+	// may have been received before a FailoverPartnerStale event is
+	// emitted. Zero disables the check.
+	MaxStaleness time.Duration
+
+	Sinks []FailoverSink
+
+	// OnError, if set, is called with errors returned by sinks.
+	OnError func(sink FailoverSink, err error)
+}
+
+// watchState is what a FailoverWatcher remembers between polls.
+type watchState struct {
+	handle      int32
+	last        Failover
+	polled      bool
+	unackedRuns int
+	conditions  map[FailoverEventKind]bool
+}
+
+// Run polls the failover-state until ctx is done or the connection
+// breaks, and returns ctx's error or the connection's.
+func (w *FailoverWatcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	state := &watchState{conditions: make(map[FailoverEventKind]bool)}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		w.poll(ctx, state)
+
+		if err := w.Conn.Err(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// fetch returns the current failover-state, refreshing the handle
+// from the previous poll if there is one.
+func (w *FailoverWatcher) fetch(ctx context.Context, state *watchState) (Failover, error) {
+	if state.handle != 0 {
+		response, err := w.Conn.RefreshObjectContext(ctx, state.handle)
+		if err == nil {
+			return response.ToFailover(), nil
+		}
+		state.handle = 0
+	}
+
+	response, err := w.Conn.OpenObjectContext(ctx, "failover-state", map[string][]byte{"name": []byte(w.Name)})
+	if err != nil {
+		return Failover{}, err
+	}
+	state.handle = response.Handle
+
+	return response.ToFailover(), nil
+}
+
+func (w *FailoverWatcher) poll(ctx context.Context, state *watchState) {
+	now := time.Now()
+	current, err := w.fetch(ctx, state)
+
+	event := func(kind FailoverEventKind) FailoverEvent {
+		return FailoverEvent{Kind: kind, Name: w.Name, Time: now, Previous: state.last, Current: current}
+	}
+
+	if err != nil {
+		current = state.last
+		if !state.conditions[FailoverUnreachable] {
+			state.conditions[FailoverUnreachable] = true
+			e := event(FailoverUnreachable)
+			e.Err = err
+			w.emit(ctx, e)
+		}
+		return
+	}
+
+	w.condition(ctx, state, event(FailoverUnreachable), false)
+
+	if state.polled {
+		if current.LocalState != state.last.LocalState {
+			w.emit(ctx, event(FailoverLocalStateChanged))
+		}
+		if current.PartnerState != state.last.PartnerState {
+			w.emit(ctx, event(FailoverPartnerStateChanged))
+		}
+
+		if current.CurUnackedUpdates > state.last.CurUnackedUpdates {
+			state.unackedRuns++
+		} else {
+			state.unackedRuns = 0
+		}
+	}
+
+	if w.MaxSkew > 0 {
+		skew := current.Skew
+		if skew < 0 {
+			skew = -skew
+		}
+		w.condition(ctx, state, event(FailoverSkewHigh), skew > w.MaxSkew)
+	}
+
+	if w.UnackedPolls > 0 {
+		growing := state.unackedRuns >= w.UnackedPolls
+		// Once growing, the condition lasts until the backlog
+		// shrinks.
+		if state.conditions[FailoverUnackedGrowing] {
+			growing = current.CurUnackedUpdates > 0 && current.CurUnackedUpdates >= state.last.CurUnackedUpdates
+		}
+		w.condition(ctx, state, event(FailoverUnackedGrowing), growing)
+	}
+
+	if w.MaxStaleness > 0 && current.LastTimestampReceived.Unix() > 0 {
+		stale := now.Sub(current.LastTimestampReceived) > w.MaxStaleness
+		w.condition(ctx, state, event(FailoverPartnerStale), stale)
+	}
+
+	state.last = current
+	state.polled = true
+}
+
+// condition emits an event if a condition started or ended.
+func (w *FailoverWatcher) condition(ctx context.Context, state *watchState, event FailoverEvent, active bool) {
+	if state.conditions[event.Kind] == active {
+		return
+	}
+
+	state.conditions[event.Kind] = active
+	event.Resolved = !active
+	w.emit(ctx, event)
+}
+
+func (w *FailoverWatcher) emit(ctx context.Context, event FailoverEvent) {
+	for _, sink := range w.Sinks {
+		if err := sink.HandleFailoverEvent(ctx, event); err != nil && w.OnError != nil {
+			w.OnError(sink, err)
+		}
+	}
+}
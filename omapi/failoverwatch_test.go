@@ -0,0 +1,219 @@
+package omapi_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+// pacedWatcher runs a FailoverWatcher whose polls only happen when the
+// test calls poll, with the failover-state the test passes.
+type pacedWatcher struct {
+	t       *testing.T
+	server  *omapitest.Server
+	ready   chan struct{}
+	release chan struct{}
+	events  chan omapi.FailoverEvent
+}
+
+func startWatcher(t *testing.T, watcher *omapi.FailoverWatcher) *pacedWatcher {
+	t.Helper()
+
+	w := &pacedWatcher{
+		t:       t,
+		server:  omapitest.NewServer(),
+		ready:   make(chan struct{}),
+		release: make(chan struct{}),
+		events:  make(chan omapi.FailoverEvent, 10),
+	}
+	t.Cleanup(w.server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Every query waits for the test to release it. Each poll makes a
+	// single query, so the next one arriving means that the previous
+	// poll is done.
+	pace := func(ctx context.Context, msg *omapi.Message, _ *omapi.Connection, invoke omapi.Invoker) (*omapi.Message, omapi.Status) {
+		select {
+		case w.ready <- struct{}{}:
+		case <-ctx.Done():
+			return nil, omapi.Statuses[20]
+		}
+		select {
+		case <-w.release:
+		case <-ctx.Done():
+			return nil, omapi.Statuses[20]
+		}
+
+		return invoke(ctx, msg)
+	}
+
+	dialer := &omapi.Dialer{Interceptors: []omapi.Interceptor{pace}}
+	con, err := dialer.Dial(w.server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watcher.Conn = con
+	watcher.Name = "peer"
+	watcher.Interval = time.Millisecond
+	watcher.Sinks = []omapi.FailoverSink{omapi.FailoverSinkFunc(func(_ context.Context, event omapi.FailoverEvent) error {
+		w.events <- event
+		return nil
+	})}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watcher.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		con.Close()
+	})
+
+	<-w.ready
+
+	return w
+}
+
+// poll lets the watcher poll failover and returns the events of that
+// poll.
+func (w *pacedWatcher) poll(failover omapi.Failover) []omapi.FailoverEvent {
+	w.t.Helper()
+
+	failover.Name = "peer"
+	w.server.PutFailover(failover)
+
+	w.release <- struct{}{}
+	select {
+	case <-w.ready:
+	case <-time.After(time.Second):
+		w.t.Fatal("watcher didn't poll again")
+	}
+
+	var events []omapi.FailoverEvent
+	for {
+		select {
+		case event := <-w.events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// eventString describes an event by its kind and whether it is
+// resolved, for comparing events.
+func eventString(events []omapi.FailoverEvent) []string {
+	var s []string
+	for _, event := range events {
+		if event.Resolved {
+			s = append(s, "resolved "+event.Kind.String())
+		} else {
+			s = append(s, event.Kind.String())
+		}
+	}
+
+	return s
+}
+
+func TestFailoverWatcher(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	normal := omapi.Failover{
+		LocalState:            omapi.FailoverStateNormal,
+		PartnerState:          omapi.FailoverStateNormal,
+		LastTimestampReceived: now,
+	}
+	with := func(change func(*omapi.Failover)) omapi.Failover {
+		f := normal
+		change(&f)
+		return f
+	}
+
+	steps := []struct {
+		name     string
+		failover omapi.Failover
+		want     []string
+	}{
+		{"first poll", normal, nil},
+		{"unchanged", normal, nil},
+
+		{"local state", with(func(f *omapi.Failover) { f.LocalState = omapi.FailoverStateCommunicationsInterrupted }), []string{"local-state-changed"}},
+		{"partner state", with(func(f *omapi.Failover) {
+			f.LocalState = omapi.FailoverStateCommunicationsInterrupted
+			f.PartnerState = omapi.FailoverStatePartnerDown
+		}), []string{"partner-state-changed"}},
+		{"both back", normal, []string{"local-state-changed", "partner-state-changed"}},
+
+		{"skew below threshold", with(func(f *omapi.Failover) { f.Skew = 5 }), nil},
+		{"skew above threshold", with(func(f *omapi.Failover) { f.Skew = 6 }), []string{"skew-high"}},
+		{"skew still high", with(func(f *omapi.Failover) { f.Skew = -8 }), nil},
+		{"skew back", with(func(f *omapi.Failover) { f.Skew = -3 }), []string{"resolved skew-high"}},
+
+		{"unacked grows once", with(func(f *omapi.Failover) { f.CurUnackedUpdates = 5 }), nil},
+		{"unacked grows twice", with(func(f *omapi.Failover) { f.CurUnackedUpdates = 8 }), []string{"unacked-updates-growing"}},
+		{"unacked stays", with(func(f *omapi.Failover) { f.CurUnackedUpdates = 8 }), nil},
+		{"unacked shrinks", with(func(f *omapi.Failover) { f.CurUnackedUpdates = 3 }), []string{"resolved unacked-updates-growing"}},
+		{"unacked grows after shrinking", with(func(f *omapi.Failover) { f.CurUnackedUpdates = 4 }), nil},
+		{"unacked cleared", normal, nil},
+
+		{"stale", with(func(f *omapi.Failover) { f.LastTimestampReceived = now.Add(-time.Hour) }), []string{"partner-stale"}},
+		{"still stale", with(func(f *omapi.Failover) { f.LastTimestampReceived = now.Add(-time.Hour) }), nil},
+		{"fresh", normal, []string{"resolved partner-stale"}},
+	}
+
+	w := startWatcher(t, &omapi.FailoverWatcher{MaxSkew: 5, UnackedPolls: 2, MaxStaleness: time.Minute})
+
+	for _, step := range steps {
+		events := w.poll(step.failover)
+		if got := eventString(events); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: events %q, want %q", step.name, got, step.want)
+		}
+
+		for _, event := range events {
+			if event.Name != "peer" || event.Time.IsZero() {
+				t.Errorf("%s: event %s has name %q and time %v", step.name, event.Kind, event.Name, event.Time)
+			}
+			if event.Current.LocalState != step.failover.LocalState || event.Current.CurUnackedUpdates != step.failover.CurUnackedUpdates {
+				t.Errorf("%s: event %s has current %+v", step.name, event.Kind, event.Current)
+			}
+		}
+	}
+}
+
+func TestFailoverWatcherStateChange(t *testing.T) {
+	w := startWatcher(t, &omapi.FailoverWatcher{})
+
+	w.poll(omapi.Failover{LocalState: omapi.FailoverStateNormal, PartnerState: omapi.FailoverStateNormal})
+	events := w.poll(omapi.Failover{LocalState: omapi.FailoverStatePartnerDown, PartnerState: omapi.FailoverStateNormal})
+	if len(events) != 1 {
+		t.Fatalf("events %q, want a single one", eventString(events))
+	}
+
+	event := events[0]
+	if event.Previous.LocalState != omapi.FailoverStateNormal || event.Current.LocalState != omapi.FailoverStatePartnerDown {
+		t.Errorf("local state changed from %s to %s", event.Previous.LocalState, event.Current.LocalState)
+	}
+	if want := `failover peer: local state changed from "normal" to "partner down"`; event.String() != want {
+		t.Errorf("String() = %q, want %q", event.String(), want)
+	}
+}
+
+func TestFailoverWatcherDisabledChecks(t *testing.T) {
+	w := startWatcher(t, &omapi.FailoverWatcher{})
+
+	// Without thresholds, only state changes are reported.
+	w.poll(omapi.Failover{})
+	for i := int32(1); i <= 5; i++ {
+		events := w.poll(omapi.Failover{Skew: 100 * i, CurUnackedUpdates: i, LastTimestampReceived: time.Now().Add(-time.Hour)})
+		if len(events) > 0 {
+			t.Fatalf("poll %d: events %q", i, eventString(events))
+		}
+	}
+}
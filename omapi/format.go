@@ -0,0 +1,112 @@
+package omapi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hardwareTypeNames are the names that dhcpd uses for hardware types,
+// in hardware statements and in omshell.
+var hardwareTypeNames = map[HardwareType]string{
+	Ethernet:  "ethernet",
+	TokenRing: "token-ring",
+	FDDI:      "fddi",
+}
+
+// ParseHardwareType parses a hardware type as dhcpd names it, such as
+// "ethernet", ignoring case.
+func ParseHardwareType(s string) (HardwareType, error) {
+	for hw, name := range hardwareTypeNames {
+		if strings.EqualFold(s, name) {
+			return hw, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown hardware type %q, expected ethernet, token-ring or fddi", s)
+}
+
+// Name returns the name that dhcpd uses for the hardware type, such as
+// "ethernet", or its number if it has none. Unlike String, it can be
+// parsed again with ParseHardwareType.
+func (hw HardwareType) Name() string {
+	if name, ok := hardwareTypeNames[hw]; ok {
+		return name
+	}
+
+	return strconv.Itoa(int(hw))
+}
+
+// ParseIdentifier parses a client identifier given as colon-separated
+// hex bytes, such as "01:00:11:22:33:44:55". Anything else is taken
+// literally.
+func ParseIdentifier(s string) []byte {
+	if s == "" {
+		return nil
+	}
+
+	if strings.Contains(s, ":") {
+		if id, err := ParseColonHex(s); err == nil {
+			return id
+		}
+	}
+
+	return []byte(s)
+}
+
+// ParseColonHex parses colon-separated hex bytes, such as hardware
+// addresses and client identifiers, as dhcpd and omshell write them.
+// Unlike net.ParseMAC, it accepts any number of bytes and single-digit
+// groups, such as "1:a".
+func ParseColonHex(s string) ([]byte, error) {
+	var ret []byte
+	for _, group := range strings.Split(s, ":") {
+		if len(group) == 1 {
+			group = "0" + group
+		}
+
+		b, err := hex.DecodeString(group)
+		if err != nil || len(b) != 1 {
+			return nil, fmt.Errorf("invalid hex string %q", s)
+		}
+
+		ret = append(ret, b[0])
+	}
+
+	return ret, nil
+}
+
+// FormatIdentifier formats a client identifier as colon-separated hex
+// bytes, the form ParseIdentifier accepts.
+func FormatIdentifier(id []byte) string {
+	groups := make([]string, len(id))
+	for i, b := range id {
+		groups[i] = fmt.Sprintf("%02x", b)
+	}
+
+	return strings.Join(groups, ":")
+}
+
+// FormatTime formats a time as RFC 3339 in UTC. OMAPI represents unset
+// times as the epoch, which, like the zero time, is formatted as an
+// empty string.
+func FormatTime(t time.Time) string {
+	if t.IsZero() || t.Unix() == 0 {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// FormatAddress formats an address and port, such as those of a
+// Failover, as host:port. It returns an empty string if ip is unset.
+func FormatAddress(ip net.IP, port int32) string {
+	if len(ip) == 0 {
+		return ""
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
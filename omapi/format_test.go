@@ -0,0 +1,102 @@
+package omapi_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+func TestHardwareType(t *testing.T) {
+	for _, hw := range []omapi.HardwareType{omapi.Ethernet, omapi.TokenRing, omapi.FDDI} {
+		parsed, err := omapi.ParseHardwareType(hw.Name())
+		if err != nil || parsed != hw {
+			t.Errorf("ParseHardwareType(%q) = %v, %v, want %v", hw.Name(), parsed, err, hw)
+		}
+	}
+
+	if hw, err := omapi.ParseHardwareType("Ethernet"); err != nil || hw != omapi.Ethernet {
+		t.Errorf("ParseHardwareType(\"Ethernet\") = %v, %v", hw, err)
+	}
+	if _, err := omapi.ParseHardwareType("wifi"); err == nil {
+		t.Error("ParseHardwareType(\"wifi\") succeeded")
+	}
+	if name := omapi.HardwareType(32).Name(); name != "32" {
+		t.Errorf("Name() of an unnamed type = %q, want 32", name)
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []byte
+	}{
+		{"", nil},
+		{"01:00:11:22:33:44:55", []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x55}},
+		{"1:a", []byte{1, 0xa}},
+		{"ab", []byte("ab")},
+		{"client:one", []byte("client:one")},
+	}
+
+	for _, tt := range tests {
+		if got := omapi.ParseIdentifier(tt.in); !bytes.Equal(got, tt.want) {
+			t.Errorf("ParseIdentifier(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	id := []byte{1, 0, 0x11, 0xff}
+	if s := omapi.FormatIdentifier(id); s != "01:00:11:ff" || !bytes.Equal(omapi.ParseIdentifier(s), id) {
+		t.Errorf("FormatIdentifier(%v) = %q", id, s)
+	}
+}
+
+func TestParseColonHex(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []byte
+	}{
+		{"00:11:22:33:44:55", []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55}},
+		{"1:a:FF", []byte{1, 0xa, 0xff}},
+		{"ab", []byte{0xab}},
+	}
+
+	for _, tt := range tests {
+		if got, err := omapi.ParseColonHex(tt.in); err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("ParseColonHex(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "00::11", "001:22", "zz", "00:11:"} {
+		if got, err := omapi.ParseColonHex(in); err == nil {
+			t.Errorf("ParseColonHex(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{time.Time{}, ""},
+		{time.Unix(0, 0), ""},
+		{time.Date(2024, 5, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60)), "2024-05-01T12:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		if got := omapi.FormatTime(tt.in); got != tt.want {
+			t.Errorf("FormatTime(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatAddress(t *testing.T) {
+	if got := omapi.FormatAddress(net.ParseIP("10.0.0.1"), 647); got != "10.0.0.1:647" {
+		t.Errorf("FormatAddress = %q", got)
+	}
+	if got := omapi.FormatAddress(nil, 647); got != "" {
+		t.Errorf("FormatAddress of no address = %q", got)
+	}
+}
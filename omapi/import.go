@@ -0,0 +1,190 @@
+package omapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ImportOptions configures ImportHosts.
+type ImportOptions struct {
+	// Concurrency is the maximum number of hosts being created at the
+	// same time. Values below 1 mean 1.
+	Concurrency int
+
+	// Overwrite replaces hosts that already exist, by deleting the
+	// host of the same name and creating it anew. Otherwise existing
+	// hosts are skipped. If creating the new host fails, the previous
+	// one is created again, without its statements, which OMAPI
+	// doesn't return; if that fails too, the result's error says so
+	// and the host is missing from the server.
+	Overwrite bool
+
+	// Progress, if set, is called after each host has been imported,
+	// with the number of hosts done so far and the total number of
+	// hosts. It is never called concurrently.
+	Progress func(done, total int)
+}
+
+// ImportOutcome describes what happened to a single host during an
+// import.
+type ImportOutcome int
+
+const (
+	ImportCreated ImportOutcome = iota
+	ImportSkipped
+	ImportOverwritten
+	ImportFailed
+)
+
+func (o ImportOutcome) String() string {
+	switch o {
+	case ImportCreated:
+		return "created"
+	case ImportSkipped:
+		return "skipped"
+	case ImportOverwritten:
+		return "overwritten"
+	case ImportFailed:
+		return "failed"
+	}
+
+	return "unknown"
+}
+
+func (o ImportOutcome) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// ImportResult is the outcome of importing a single host. Index is the
+// host's index in the slice passed to ImportHosts, and Host the
+// server's representation of the host if it was created.
+type ImportResult struct {
+	Index   int
+	Host    Host
+	Outcome ImportOutcome
+	Err     error
+}
+
+// ImportHosts creates the given hosts and returns a result per host, in
+// the same order as the hosts. Hosts that already exist are skipped or
+// overwritten, depending on opts.Overwrite. Failing hosts don't stop
+// the import; check the results for errors. Hosts that haven't been
+// imported by the time ctx is done fail with the context's error.
+//
+// Example:
+//
+//	results := omapi.ImportHosts(ctx, connection, hosts, omapi.ImportOptions{Concurrency: 8})
+//	for _, result := range results {
+//		if result.Outcome == omapi.ImportFailed {
+//			// Couldn't import hosts[result.Index], result.Err tells why
+//		}
+//	}
+func ImportHosts(ctx context.Context, con *Connection, hosts []Host, opts ImportOptions) []ImportResult {
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Hosts that are never handed to a worker because ctx is done
+	// keep their initial result.
+	results := make([]ImportResult, len(hosts))
+	for i := range results {
+		results[i] = ImportResult{Index: i, Outcome: ImportFailed}
+	}
+
+	indexes := make(chan int)
+
+	go func() {
+		defer close(indexes)
+
+		for i := range hosts {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		done int
+	)
+
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+
+			for index := range indexes {
+				results[index] = importHost(ctx, con, index, hosts[index], opts.Overwrite)
+
+				mu.Lock()
+				done++
+				if opts.Progress != nil {
+					opts.Progress(done, len(hosts))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for i := range results {
+		if results[i].Outcome == ImportFailed && results[i].Err == nil {
+			results[i].Err = ctx.Err()
+		}
+	}
+
+	return results
+}
+
+func importHost(ctx context.Context, con *Connection, index int, host Host, overwrite bool) ImportResult {
+	result := ImportResult{Index: index, Outcome: ImportFailed}
+
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	created, err := con.CreateHostContext(ctx, host)
+	switch {
+	case err == nil:
+		result.Host, result.Outcome = created, ImportCreated
+		return result
+	case !errors.Is(err, ErrAlreadyExists):
+		result.Err = err
+		return result
+	case !overwrite:
+		result.Outcome = ImportSkipped
+		return result
+	}
+
+	existing, err := con.FindHostContext(ctx, Host{Name: host.Name})
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if err := con.DeleteContext(ctx, existing.Handle); err != nil {
+		result.Err = err
+		return result
+	}
+
+	if result.Host, result.Err = con.CreateHostContext(ctx, host); result.Err == nil {
+		result.Outcome = ImportOverwritten
+		return result
+	}
+
+	// Put the previous host back, so that a failed overwrite doesn't
+	// leave the server without it. This is done even if ctx is done.
+	if _, err := con.CreateHostContext(context.WithoutCancel(ctx), existing); err != nil {
+		// Both errors are kept, on one line for tabular output.
+		result.Err = fmt.Errorf("%w; restoring previous host %s: %w", result.Err, existing.Name, err)
+	}
+
+	return result
+}
@@ -0,0 +1,109 @@
+package omapi_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestImportHosts(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.PutHost(omapi.Host{Name: "existing", HardwareAddress: mustMAC(t, "00:00:00:00:00:01"), HardwareType: 1})
+
+	con, err := omapi.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	hosts := []omapi.Host{
+		{Name: "new", HardwareAddress: mustMAC(t, "00:00:00:00:00:02"), HardwareType: 1},
+		{Name: "existing", HardwareAddress: mustMAC(t, "00:00:00:00:00:01"), HardwareType: 1, Statements: "ddns-hostname existing;"},
+	}
+
+	var progress []int
+	results := omapi.ImportHosts(context.Background(), con, hosts, omapi.ImportOptions{
+		Progress: func(done, total int) { progress = append(progress, done) },
+	})
+	if results[0].Outcome != omapi.ImportCreated || results[1].Outcome != omapi.ImportSkipped {
+		t.Errorf("results are %+v", results)
+	}
+	if len(progress) != 2 {
+		t.Errorf("progress was reported %v", progress)
+	}
+
+	results = omapi.ImportHosts(context.Background(), con, hosts[1:], omapi.ImportOptions{Overwrite: true})
+	if results[0].Outcome != omapi.ImportOverwritten {
+		t.Fatalf("result is %+v", results[0])
+	}
+	for _, host := range server.Hosts() {
+		if host.Name == "existing" && host.Statements != hosts[1].Statements {
+			t.Errorf("overwritten host has statements %q", host.Statements)
+		}
+	}
+}
+
+func TestImportHostsFailedOverwrite(t *testing.T) {
+	previous := omapi.Host{Name: "existing", HardwareAddress: net.HardwareAddr{0, 0, 0, 0, 0, 1}, HardwareType: 1, IP: net.ParseIP("10.0.0.1")}
+	replacement := previous
+	replacement.Statements = "ddns-hostname existing;"
+
+	tests := []struct {
+		name     string
+		faults   []omapitest.Fault
+		restored bool
+	}{
+		{
+			// The create, lookup and delete succeed, the second
+			// create fails.
+			name:     "restored",
+			faults:   []omapitest.Fault{{}, {}, {}, {Status: omapi.Statuses[17]}},
+			restored: true,
+		},
+		{
+			name:   "lost",
+			faults: []omapitest.Fault{{}, {}, {}, {Status: omapi.Statuses[17]}, {Status: omapi.Statuses[3]}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := omapitest.NewServer()
+			defer server.Close()
+
+			server.PutHost(previous)
+
+			con, err := omapi.Dial(server.Addr, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer con.Close()
+
+			for _, fault := range tt.faults {
+				server.InjectFault(fault)
+			}
+
+			result := omapi.ImportHosts(context.Background(), con, []omapi.Host{replacement}, omapi.ImportOptions{Overwrite: true})[0]
+			if result.Outcome != omapi.ImportFailed || !errors.Is(result.Err, omapi.Statuses[17]) {
+				t.Fatalf("result is %+v", result)
+			}
+			if restoreFailed := errors.Is(result.Err, omapi.Statuses[3]); restoreFailed == tt.restored {
+				t.Errorf("error %q, want restore failed = %v", result.Err, !tt.restored)
+			}
+
+			hosts := server.Hosts()
+			switch {
+			case tt.restored && (len(hosts) != 1 || !hosts[0].IP.Equal(previous.IP) || hosts[0].Statements != ""):
+				t.Errorf("server has hosts %+v, want the previous host", hosts)
+			case !tt.restored && len(hosts) != 0:
+				t.Errorf("server has hosts %+v, want none", hosts)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package omapi
+
+import "context"
+
+// An Invoker sends a message and waits for the response, like
+// Connection.QueryContext.
+type Invoker func(ctx context.Context, msg *Message) (*Message, Status)
+
+// An Interceptor wraps every query of a connection, much like a gRPC
+// unary client interceptor. It sees the outgoing message and the
+// response, and decides whether, when and how often to call invoke,
+// which sends the message through the rest of the chain. Interceptors
+// are used for metrics, tracing, auditing, rate limiting and retries.
+//
+// Each call of invoke gives the message a new transaction ID and signs
+// it, so interceptors may change the message before calling invoke,
+// and call invoke again with the same message to retry: a late
+// response to an earlier attempt can't be mistaken for the response to
+// the retry.
+//
+// Example:
+//
+//	func timing(ctx context.Context, msg *omapi.Message, con *omapi.Connection, invoke omapi.Invoker) (*omapi.Message, omapi.Status) {
+//		start := time.Now()
+//		response, status := invoke(ctx, msg)
+//		observe(msg.Opcode, status, time.Since(start))
+//		return response, status
+//	}
+//
+//	dialer := omapi.Dialer{Interceptors: []omapi.Interceptor{timing}}
+type Interceptor func(ctx context.Context, msg *Message, con *Connection, invoke Invoker) (*Message, Status)
+
+// ChainInterceptors combines interceptors into one. The first one is
+// the outermost: it is called first and its invoke calls the second
+// one, and so on, until the last one's invoke sends the message.
+// Without interceptors, the result sends the message right away.
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	if len(interceptors) == 1 {
+		return interceptors[0]
+	}
+
+	return func(ctx context.Context, msg *Message, con *Connection, invoke Invoker) (*Message, Status) {
+		return chainInvoker(interceptors, con, invoke)(ctx, msg)
+	}
+}
+
+// chainInvoker returns an invoker that calls the interceptors in order
+// and finally invoke.
+func chainInvoker(interceptors []Interceptor, con *Connection, invoke Invoker) Invoker {
+	if len(interceptors) == 0 {
+		return invoke
+	}
+
+	return func(ctx context.Context, msg *Message) (*Message, Status) {
+		return interceptors[0](ctx, msg, con, chainInvoker(interceptors[1:], con, invoke))
+	}
+}
+
+// An OperationInterceptor wraps the high-level operations of a
+// connection, such as FindHost or CreateHost, which send one or more
+// queries. op is the name of the method, without a Context suffix, and
+// run performs the operation with the given context, which is passed
+// on to its queries and their interceptors. Tracing uses this to
+// group the queries of an operation.
+//
+// Operations may be nested: ReleaseLease, for example, runs FindLease.
+type OperationInterceptor func(ctx context.Context, op string, con *Connection, run func(ctx context.Context) error) error
+
+// ChainOperationInterceptors combines operation interceptors into one,
+// in the same order as ChainInterceptors.
+func ChainOperationInterceptors(interceptors ...OperationInterceptor) OperationInterceptor {
+	if len(interceptors) == 1 {
+		return interceptors[0]
+	}
+
+	return func(ctx context.Context, op string, con *Connection, run func(ctx context.Context) error) error {
+		return chainRun(interceptors, op, con, run)(ctx)
+	}
+}
+
+// chainRun returns a function that calls the operation interceptors in
+// order and finally run.
+func chainRun(interceptors []OperationInterceptor, op string, con *Connection, run func(ctx context.Context) error) func(ctx context.Context) error {
+	if len(interceptors) == 0 {
+		return run
+	}
+
+	return func(ctx context.Context) error {
+		return interceptors[0](ctx, op, con, chainRun(interceptors[1:], op, con, run))
+	}
+}
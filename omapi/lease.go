@@ -92,6 +92,7 @@ type Lease struct {
 	// Subnet, Pool, BillingClass are "currently not supported" by the dhcpd
 	HardwareAddress net.HardwareAddr
 	HardwareType    HardwareType
+	Starts          time.Time
 	Ends            time.Time
 	// TODO maybe find nicer names for these times
 	Tstp   time.Time
@@ -110,6 +111,7 @@ func (lease Lease) String() string {
 		Host                 int32        `json:"host"`
 		HardwareAddress      string       `json:"hardware-address"`
 		HardwareType         HardwareType `json:"hardware-type"`
+		Starts               time.Time    `json:"lease-start-time"`
 		Ends                 time.Time    `json:"lease-end-time"`
 		Tstp                 time.Time    `json:"tstp"`
 		Atsfp                time.Time    `json:"atsfp"`
@@ -123,6 +125,7 @@ func (lease Lease) String() string {
 		Host:                 lease.Host,
 		HardwareAddress:      net.HardwareAddr(lease.HardwareAddress).String(),
 		HardwareType:         lease.HardwareType,
+		Starts:               lease.Starts,
 		Ends:                 lease.Ends,
 		Tstp:                 lease.Tstp,
 		Atsfp:                lease.Atsfp,
@@ -149,7 +152,7 @@ func (lease Lease) toObject() map[string][]byte {
 	// TODO check if sending the IP in an update will cause an
 	// error
 	if len([]byte(lease.IP)) > 0 {
-		object["ip-address"] = []byte(lease.IP)[12:]
+		object["ip-address"] = []byte(lease.IP.To4())
 	} else {
 		object["ip-address"] = nil
 	}
@@ -0,0 +1,222 @@
+package omapi
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+)
+
+// ParseLeases parses a dhcpd.leases file, the journal in which dhcpd
+// records leases and dynamically created hosts. This allows reading
+// lease data while the server isn't running.
+//
+// Because the file is a journal, the same lease or host may appear
+// more than once; the last entry wins. Hosts that have been deleted
+// are not returned. Leases and hosts are returned in the order in
+// which they first appear.
+//
+// Statements of host entries that don't correspond to a field of Host
+// are collected in Statements. Handles are never populated.
+//
+// An entry that can't be understood, or a statement within one, such
+// as a hardware statement with a type that this package doesn't know,
+// is skipped rather than hiding the rest of the file. The returned
+// error then joins a *ParseError for each, and the leases and hosts
+// are returned nevertheless. Only a syntax error, after which nothing
+// can be parsed, returns no leases or hosts.
+func ParseLeases(r io.Reader) ([]Lease, []Host, error) {
+	statements, err := parseStatements(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		leases     []Lease
+		hosts      []Host
+		errs       []error
+		leaseIndex = make(map[string]int)
+		hostIndex  = make(map[string]int)
+	)
+
+	for _, stmt := range statements {
+		switch stmt.keyword() {
+		case "lease":
+			lease, skipped, err := parseLease(stmt)
+			errs = append(errs, skipped...)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			key := lease.IP.String()
+			if i, ok := leaseIndex[key]; ok {
+				leases[i] = lease
+			} else {
+				leaseIndex[key] = len(leases)
+				leases = append(leases, lease)
+			}
+		case "host":
+			host, deleted, skipped, err := parseHost(stmt)
+			errs = append(errs, skipped...)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			i, ok := hostIndex[host.Name]
+			switch {
+			case deleted && ok:
+				hosts = append(hosts[:i], hosts[i+1:]...)
+				delete(hostIndex, host.Name)
+				for name, j := range hostIndex {
+					if j > i {
+						hostIndex[name] = j - 1
+					}
+				}
+			case deleted:
+			case ok:
+				hosts[i] = host
+			default:
+				hostIndex[host.Name] = len(hosts)
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	return leases, hosts, errors.Join(errs...)
+}
+
+// parseLease parses a lease declaration. Statements within it that
+// can't be parsed are left out of the lease and returned as skipped.
+// An error means that the lease itself is invalid.
+func parseLease(stmt *statement) (lease Lease, skipped []error, err error) {
+	if len(stmt.args) != 2 || stmt.block == nil {
+		return lease, nil, stmt.errorf("expected lease address and block")
+	}
+
+	lease.IP = net.ParseIP(stmt.args[1].text)
+	if lease.IP == nil {
+		return lease, nil, stmt.errorf("invalid lease address %q", stmt.args[1].text)
+	}
+
+	for _, inner := range stmt.block {
+		var err error
+
+		switch inner.keyword() {
+		case "starts":
+			lease.Starts, err = inner.parseTime()
+		case "ends":
+			lease.Ends, err = inner.parseTime()
+		case "tstp":
+			lease.Tstp, err = inner.parseTime()
+		case "atsfp":
+			lease.Atsfp, err = inner.parseTime()
+		case "cltt":
+			lease.Cltt, err = inner.parseTime()
+		case "binding":
+			// "next binding state" and "rewind binding state"
+			// describe future states and are ignored.
+			if len(inner.args) != 3 || inner.args[1].text != "state" {
+				err = inner.errorf("expected binding state")
+				break
+			}
+
+			state, ok := parseLeaseState(inner.args[2].text)
+			if !ok {
+				err = inner.errorf("unknown binding state %q", inner.args[2].text)
+				break
+			}
+			lease.State = state
+		case "abandoned":
+			lease.State = LeaseStateAbandoned
+		case "hardware":
+			lease.HardwareType, lease.HardwareAddress, err = inner.parseHardware()
+		case "uid":
+			if len(inner.args) != 2 {
+				err = inner.errorf("expected client identifier")
+				break
+			}
+			lease.DHCPClientIdentifier, err = inner.parseIdentifier(inner.args[1])
+		case "client-hostname":
+			if len(inner.args) != 2 {
+				err = inner.errorf("expected client hostname")
+				break
+			}
+			lease.ClientHostname = inner.args[1].text
+		}
+
+		if err != nil {
+			skipped = append(skipped, err)
+		}
+	}
+
+	return lease, skipped, nil
+}
+
+func parseLeaseState(s string) (LeaseState, bool) {
+	for state := LeaseStateFree; state <= LeaseStateBootp; state++ {
+		if LeaseState(state).String() == s {
+			return LeaseState(state), true
+		}
+	}
+
+	return 0, false
+}
+
+// parseHost parses a host declaration, as found in both dhcpd.conf
+// and dhcpd.leases. It also reports whether the host has been marked
+// as deleted, which only happens in lease files. Like parseLease, it
+// returns statements that can't be parsed as skipped.
+func parseHost(stmt *statement) (host Host, deleted bool, skipped []error, err error) {
+	if len(stmt.args) != 2 || stmt.block == nil {
+		return host, false, nil, stmt.errorf("expected host name and block")
+	}
+
+	host.Name = stmt.args[1].text
+
+	var statements []string
+	for _, inner := range stmt.block {
+		switch inner.keyword() {
+		case "dynamic":
+		case "deleted":
+			deleted = true
+		case "hardware":
+			host.HardwareType, host.HardwareAddress, err = inner.parseHardware()
+		case "uid":
+			if len(inner.args) != 2 {
+				err = inner.errorf("expected client identifier")
+				break
+			}
+			host.DHCPClientIdentifier, err = inner.parseIdentifier(inner.args[1])
+		case "fixed-address":
+			// Hosts can only have a single address in OMAPI, so
+			// lists of addresses and hostnames are kept as a
+			// statement.
+			if len(inner.args) == 2 {
+				if ip := net.ParseIP(inner.args[1].text); ip != nil {
+					host.IP = ip
+					break
+				}
+			}
+			statements = append(statements, inner.text)
+		case "option":
+			if len(inner.args) == 3 && strings.ToLower(inner.args[1].text) == "dhcp-client-identifier" {
+				host.DHCPClientIdentifier, err = inner.parseIdentifier(inner.args[2])
+				break
+			}
+			statements = append(statements, inner.text)
+		default:
+			statements = append(statements, inner.text)
+		}
+
+		if err != nil {
+			skipped = append(skipped, err)
+			err = nil
+		}
+	}
+
+	host.Statements = strings.Join(statements, "\n")
+
+	return host, deleted, skipped, nil
+}
@@ -0,0 +1,209 @@
+package omapi_test
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+const leaseFile = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+# This lease file was written by isc-dhcp-4.4.3
+
+# authoring-byte-order entry is generated, DO NOT DELETE
+authoring-byte-order little-endian;
+
+server-duid "\000\001\000\001+\253\315\357RT\000\022\064V";
+
+lease 10.0.0.5 {
+  starts 4 2024/05/02 10:00:00;
+  ends 4 2024/05/02 22:00:00;
+  tstp 4 2024/05/02 22:00:00;
+  cltt 4 2024/05/02 10:00:00;
+  binding state active;
+  next binding state free;
+  rewind binding state free;
+  hardware ethernet 00:11:22:33:44:55;
+  uid "\001\000\021\"3DU";
+  set vendor-class-identifier = "MSFT 5.0";
+  client-hostname "printer";
+}
+lease 10.0.0.6 {
+  starts epoch 1714644000; # Thu May 02 10:00:00 2024
+  ends never;
+  binding state backup;
+  hardware ethernet 0:1:2:3:4:5;
+  uid 01:00:01:02:03:04:05;
+}
+host laptop {
+  dynamic;
+  hardware ethernet 00:aa:bb:cc:dd:ee;
+  fixed-address 10.0.0.20;
+  supersede server.ddns-hostname = "laptop";
+}
+host "old printer" {
+  dynamic;
+  hardware ethernet 00:11:22:33:44:56;
+  fixed-address 10.0.0.21;
+}
+lease 10.0.0.5 {
+  starts 4 2024/05/02 12:00:00;
+  ends 4 2024/05/02 12:00:00;
+  binding state free;
+  hardware ethernet 00:11:22:33:44:55;
+}
+host "old printer" {
+  dynamic;
+  deleted;
+}
+host desk {
+  dynamic;
+  option dhcp-client-identifier "desk\\01";
+}
+failover peer "dhcp-failover" state {
+  my state normal at 4 2024/05/02 09:00:00;
+  partner state normal at 4 2024/05/02 09:00:00;
+}
+`
+
+func TestParseLeases(t *testing.T) {
+	leases, hosts, err := omapi.ParseLeases(strings.NewReader(leaseFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantLeases := []omapi.Lease{
+		{
+			// Only the last entry of 10.0.0.5 counts, but it keeps
+			// its place.
+			IP:              net.ParseIP("10.0.0.5"),
+			State:           omapi.LeaseStateFree,
+			HardwareType:    omapi.Ethernet,
+			HardwareAddress: mustMAC(t, "00:11:22:33:44:55"),
+			Starts:          time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC),
+			Ends:            time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			IP:                   net.ParseIP("10.0.0.6"),
+			State:                omapi.LeaseStateBackup,
+			HardwareType:         omapi.Ethernet,
+			HardwareAddress:      mustMAC(t, "00:01:02:03:04:05"),
+			DHCPClientIdentifier: []byte{1, 0, 1, 2, 3, 4, 5},
+			Starts:               time.Unix(1714644000, 0),
+		},
+	}
+	if !reflect.DeepEqual(leases, wantLeases) {
+		t.Errorf("leases =\n%+v\nwant\n%+v", leases, wantLeases)
+	}
+
+	wantHosts := []omapi.Host{
+		{
+			Name:            "laptop",
+			HardwareType:    omapi.Ethernet,
+			HardwareAddress: mustMAC(t, "00:aa:bb:cc:dd:ee"),
+			IP:              net.ParseIP("10.0.0.20"),
+			Statements:      `supersede server.ddns-hostname = "laptop";`,
+		},
+		{
+			Name:                 "desk",
+			DHCPClientIdentifier: []byte(`desk\01`),
+		},
+	}
+	if !reflect.DeepEqual(hosts, wantHosts) {
+		t.Errorf("hosts =\n%+v\nwant\n%+v", hosts, wantHosts)
+	}
+}
+
+func TestParseLeasesFirstEntry(t *testing.T) {
+	// The escapes of the first entry of 10.0.0.5 are checked on their
+	// own, because the later entry replaces it.
+	src := leaseFile[:strings.Index(leaseFile, "lease 10.0.0.6")]
+
+	leases, _, err := omapi.ParseLeases(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 1 {
+		t.Fatalf("got %d leases, want 1", len(leases))
+	}
+
+	lease := leases[0]
+	if want := []byte("\x01\x00\x11\"3DU"); string(lease.DHCPClientIdentifier) != string(want) {
+		t.Errorf("DHCPClientIdentifier = %q, want %q", lease.DHCPClientIdentifier, want)
+	}
+	if lease.ClientHostname != "printer" || lease.State != omapi.LeaseStateActive {
+		t.Errorf("ClientHostname, State = %q, %v", lease.ClientHostname, lease.State)
+	}
+	if want := time.Date(2024, 5, 2, 22, 0, 0, 0, time.UTC); !lease.Ends.Equal(want) || !lease.Tstp.Equal(want) {
+		t.Errorf("Ends, Tstp = %v, %v, want %v", lease.Ends, lease.Tstp, want)
+	}
+}
+
+func TestParseLeasesSkipped(t *testing.T) {
+	src := `lease 10.0.0.5 {
+  starts 4 2024/05/02 10:00:00;
+  binding state active;
+  hardware infiniband 00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff:00:11:22:33;
+  client-hostname "ib0";
+}
+lease 10.0.0.300 {
+  binding state active;
+}
+lease 10.0.0.6 {
+  binding state sleeping;
+  hardware ethernet 00:11:22:33:44:66;
+}
+host laptop {
+  hardware ethernet 00:aa:bb:cc:dd:zz;
+  fixed-address 10.0.0.20;
+}
+`
+
+	leases, hosts, err := omapi.ParseLeases(strings.NewReader(src))
+	if err == nil {
+		t.Fatal("ParseLeases succeeded")
+	}
+
+	// Every problem is reported with its line.
+	var lines []int
+	for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var parseErr *omapi.ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("error %v is not a ParseError", err)
+		}
+		lines = append(lines, parseErr.Line)
+	}
+	if want := []int{4, 7, 11, 15}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("errors on lines %v, want %v: %v", lines, want, err)
+	}
+
+	// The rest of the file is parsed.
+	if len(leases) != 2 || len(hosts) != 1 {
+		t.Fatalf("got %d leases and %d hosts, want 2 and 1", len(leases), len(hosts))
+	}
+	if lease := leases[0]; lease.ClientHostname != "ib0" || lease.State != omapi.LeaseStateActive || lease.HardwareAddress != nil {
+		t.Errorf("lease with unknown hardware type = %+v", lease)
+	}
+	if lease := leases[1]; !lease.IP.Equal(net.ParseIP("10.0.0.6")) || lease.HardwareAddress.String() != "00:11:22:33:44:66" {
+		t.Errorf("lease with unknown state = %+v", lease)
+	}
+	if host := hosts[0]; host.Name != "laptop" || !host.IP.Equal(net.ParseIP("10.0.0.20")) || host.HardwareAddress != nil {
+		t.Errorf("host with invalid hardware address = %+v", host)
+	}
+}
+
+func TestParseLeasesSyntaxError(t *testing.T) {
+	leases, hosts, err := omapi.ParseLeases(strings.NewReader("lease 10.0.0.5 {\n  binding state active;\n"))
+
+	var parseErr *omapi.ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("error = %v, want a ParseError", err)
+	}
+	if leases != nil || hosts != nil {
+		t.Errorf("got leases %v and hosts %v despite a syntax error", leases, hosts)
+	}
+}
@@ -0,0 +1,355 @@
+package omapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// LeaseEventKind is the kind of a LeaseEvent.
+type LeaseEventKind int
+
+const (
+	// A lease became active.
+	LeaseAcquired LeaseEventKind = iota + 1
+
+	// An active lease was extended.
+	LeaseRenewed
+
+	// An active lease ran out, or disappeared.
+	LeaseExpired
+
+	// An active lease was released by the client or over OMAPI.
+	LeaseReleased
+
+	// An active lease moved to a different hardware address.
+	LeaseHardwareChanged
+)
+
+func (k LeaseEventKind) String() string {
+	switch k {
+	case LeaseAcquired:
+		return "acquired"
+	case LeaseRenewed:
+		return "renewed"
+	case LeaseExpired:
+		return "expired"
+	case LeaseReleased:
+		return "released"
+	case LeaseHardwareChanged:
+		return "hardware-changed"
+	}
+
+	return "unknown"
+}
+
+func (k LeaseEventKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// A LeaseEvent is a change of a lease noticed by a LeaseWatcher.
+type LeaseEvent struct {
+	Kind LeaseEventKind
+	Time time.Time
+
+	// Query is the watched lease, as given to the LeaseWatcher.
+	Query Lease
+
+	// Previous and Current are the lease before and after the change.
+	// Either is empty if the lease didn't exist.
+	Previous, Current Lease
+}
+
+// lease returns the current lease, or the previous one if the lease
+// no longer exists.
+func (e LeaseEvent) lease() Lease {
+	if e.Current.IP == nil {
+		return e.Previous
+	}
+
+	return e.Current
+}
+
+func (e LeaseEvent) String() string {
+	lease := e.lease()
+	msg := fmt.Sprintf("lease %s %s", lease.IP, e.Kind)
+	switch e.Kind {
+	case LeaseAcquired, LeaseRenewed:
+		msg += fmt.Sprintf(" by %s until %s", lease.HardwareAddress, lease.Ends.Format(time.RFC3339))
+	case LeaseHardwareChanged:
+		msg += fmt.Sprintf(" from %s to %s", e.Previous.HardwareAddress, e.Current.HardwareAddress)
+	}
+
+	return msg
+}
+
+// A LeaseSink receives the events of a LeaseWatcher.
+type LeaseSink interface {
+	HandleLeaseEvent(ctx context.Context, event LeaseEvent) error
+}
+
+// LeaseSinkFunc adapts a function to a LeaseSink.
+type LeaseSinkFunc func(ctx context.Context, event LeaseEvent) error
+
+func (f LeaseSinkFunc) HandleLeaseEvent(ctx context.Context, event LeaseEvent) error {
+	return f(ctx, event)
+}
+
+// HandleLeaseEvent logs lease events at info level.
+func (s LogSink) HandleLeaseEvent(ctx context.Context, event LeaseEvent) error {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	logger.InfoContext(ctx, event.String(),
+		"kind", event.Kind.String(),
+		"ip", event.lease().IP.String(),
+		"hardware-address", event.Current.HardwareAddress.String(),
+		"state", event.Current.State.String())
+
+	return nil
+}
+
+// HandleLeaseEvent posts lease events as JSON:
+//
+//	{
+//		"kind": "acquired",
+//		"time": "2024-05-01T12:00:00Z",
+//		"message": "lease 10.0.0.5 acquired by 00:11:22:33:44:55 until 2024-05-01T13:00:00Z",
+//		"ip-address": "10.0.0.5",
+//		"state": "active",
+//		"hardware-address": "00:11:22:33:44:55",
+//		"previous-hardware-address": "",
+//		"client-hostname": "laptop",
+//		"ends": "2024-05-01T13:00:00Z"
+//	}
+func (s WebhookSink) HandleLeaseEvent(ctx context.Context, event LeaseEvent) error {
+	lease := event.lease()
+	payload := struct {
+		Kind                    LeaseEventKind `json:"kind"`
+		Time                    time.Time      `json:"time"`
+		Message                 string         `json:"message"`
+		IP                      string         `json:"ip-address"`
+		State                   string         `json:"state"`
+		HardwareAddress         string         `json:"hardware-address"`
+		PreviousHardwareAddress string         `json:"previous-hardware-address"`
+		ClientHostname          string         `json:"client-hostname"`
+		Ends                    time.Time      `json:"ends"`
+	}{
+		Kind:                    event.Kind,
+		Time:                    event.Time,
+		Message:                 event.String(),
+		IP:                      lease.IP.String(),
+		State:                   event.Current.State.String(),
+		HardwareAddress:         event.Current.HardwareAddress.String(),
+		PreviousHardwareAddress: event.Previous.HardwareAddress.String(),
+		ClientHostname:          lease.ClientHostname,
+		Ends:                    lease.Ends,
+	}
+
+	return s.post(ctx, payload)
+}
+
+// A LeaseWatcher polls a set of leases and reports their changes to its
+// sinks, for example to keep DNS or access control in sync without
+// tailing dhcpd's log. If the server sends notify messages for the
+// watched leases, they are refreshed right away instead of waiting for
+// the next poll.
+//
+// The first poll only records the leases' current state; events are
+// reported for changes after that.
+//
+// Example:
+//
+//	watcher := &omapi.LeaseWatcher{
+//		Conn:   connection,
+//		Leases: []omapi.Lease{{IP: net.ParseIP("10.0.0.5")}},
+//		Sinks:  []omapi.LeaseSink{omapi.LeaseSinkFunc(updateDNS)},
+//	}
+//
+//	err := watcher.Run(ctx)
+//	// err is the reason the connection broke; dial again and rerun
+type LeaseWatcher struct {
+	Conn *Connection
+
+	// Leases are the leases to watch, each identified by its IP
+	// address or its client identifier.
+	Leases []Lease
+
+	// Interval is the time between polls. It defaults to 30 seconds.
+	Interval time.Duration
+
+	Sinks []LeaseSink
+
+	// OnError, if set, is called with errors returned by sinks.
+	OnError func(sink LeaseSink, err error)
+}
+
+// watchedLease is what a LeaseWatcher remembers about a lease between
+// polls.
+type watchedLease struct {
+	query    Lease
+	handle   int32
+	last     Lease
+	found    bool
+	polled   bool
+	polledAt time.Time
+}
+
+// Run polls the leases until ctx is done or the connection breaks, and
+// returns ctx's error or the connection's.
+func (w *LeaseWatcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	leases := make([]*watchedLease, len(w.Leases))
+	for i, query := range w.Leases {
+		leases[i] = &watchedLease{query: query}
+	}
+
+	// Notify messages only carry the handle of the object that
+	// changed. They are handled by the poll loop, so that only it
+	// touches the watched leases; if it falls behind, notifications
+	// are dropped and the next poll catches up.
+	notified := make(chan int32, 64)
+	remove := w.Conn.OnNotify(func(msg *Message) {
+		select {
+		case notified <- msg.Handle:
+		default:
+		}
+	})
+	defer remove()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, lease := range leases {
+			w.poll(ctx, lease)
+		}
+
+		if err := w.Conn.Err(); err != nil {
+			return err
+		}
+
+	wait:
+		for {
+			select {
+			case <-ticker.C:
+				break wait
+			case handle := <-notified:
+				for _, lease := range leases {
+					if lease.handle != 0 && lease.handle == handle {
+						w.poll(ctx, lease)
+					}
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// fetch returns the current lease. Leases watched by IP address are
+// refreshed through the handle from the previous poll, if there is
+// one. Leases watched by client identifier are looked up again every
+// time, because the client may have moved to a lease of a different
+// address, while the handle stays on the old one.
+func (w *LeaseWatcher) fetch(ctx context.Context, lease *watchedLease) (Lease, error) {
+	if lease.handle != 0 && lease.query.IP != nil {
+		response, err := w.Conn.RefreshObjectContext(ctx, lease.handle)
+		if err == nil {
+			return response.ToLease(), nil
+		}
+		lease.handle = 0
+	}
+
+	response, err := w.Conn.OpenObjectContext(ctx, "lease", lease.query.toObject())
+	if err != nil {
+		return Lease{}, err
+	}
+	lease.handle = response.Handle
+
+	return response.ToLease(), nil
+}
+
+func (w *LeaseWatcher) poll(ctx context.Context, lease *watchedLease) {
+	now := time.Now()
+	current, err := w.fetch(ctx, lease)
+	found := err == nil
+
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		// Try again on the next poll.
+		return
+	}
+
+	if lease.polled {
+		var previous Lease
+		if lease.found {
+			previous = lease.last
+		}
+
+		if kind, ok := classifyLeaseChange(previous, lease.polledAt, current, now); ok {
+			w.emit(ctx, LeaseEvent{Kind: kind, Time: now, Query: lease.query, Previous: previous, Current: current})
+		}
+	}
+
+	lease.last, lease.found, lease.polled, lease.polledAt = current, found, true, now
+}
+
+// leaseActive reports whether a lease was active at the given time.
+// Leases released over OMAPI have their end time set to the epoch, see
+// ReleaseLease, and dhcpd leaves them in the active state until it gets
+// around to them, as it does with leases that ran out, so an active
+// lease whose end time has passed no longer counts.
+func leaseActive(lease Lease, at time.Time) bool {
+	return lease.State == LeaseStateActive && lease.Ends.After(time.Unix(0, 0)) && lease.Ends.After(at)
+}
+
+// classifyLeaseChange returns the kind of change between two states of
+// a lease, polled at then and now, if there is one worth reporting. An
+// empty lease didn't exist.
+func classifyLeaseChange(previous Lease, then time.Time, current Lease, now time.Time) (LeaseEventKind, bool) {
+	wasActive := leaseActive(previous, then)
+	isActive := leaseActive(current, now)
+
+	switch {
+	case !wasActive && isActive:
+		return LeaseAcquired, true
+	case wasActive && isActive:
+		if !bytes.Equal(previous.HardwareAddress, current.HardwareAddress) {
+			return LeaseHardwareChanged, true
+		}
+		if current.Ends.After(previous.Ends) {
+			return LeaseRenewed, true
+		}
+	case wasActive && current.State == LeaseStateReleased:
+		return LeaseReleased, true
+	case wasActive && current.State == LeaseStateActive && current.Ends.Before(previous.Ends):
+		// The end time was moved to the past while the lease is still
+		// active, as ReleaseLease does.
+		return LeaseReleased, true
+	case wasActive && current.State != LeaseStateExpired && current.Ends.After(now):
+		// The lease left the active state before running out,
+		// such as when it is released over OMAPI.
+		return LeaseReleased, true
+	case wasActive:
+		return LeaseExpired, true
+	}
+
+	return 0, false
+}
+
+func (w *LeaseWatcher) emit(ctx context.Context, event LeaseEvent) {
+	for _, sink := range w.Sinks {
+		if err := sink.HandleLeaseEvent(ctx, event); err != nil && w.OnError != nil {
+			w.OnError(sink, err)
+		}
+	}
+}
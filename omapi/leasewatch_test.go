@@ -0,0 +1,162 @@
+package omapi_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+// watchLease runs a LeaseWatcher for query until the test ends. It
+// returns once the first poll has been answered, and returns the
+// watcher's events.
+func watchLease(t *testing.T, server *omapitest.Server, query omapi.Lease) <-chan omapi.LeaseEvent {
+	t.Helper()
+
+	polled := make(chan struct{}, 1)
+	dialer := &omapi.Dialer{Interceptors: []omapi.Interceptor{
+		func(ctx context.Context, msg *omapi.Message, con *omapi.Connection, invoke omapi.Invoker) (*omapi.Message, omapi.Status) {
+			response, status := invoke(ctx, msg)
+			select {
+			case polled <- struct{}{}:
+			default:
+			}
+			return response, status
+		},
+	}}
+
+	con, err := dialer.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan omapi.LeaseEvent, 16)
+	watcher := &omapi.LeaseWatcher{
+		Conn:     con,
+		Leases:   []omapi.Lease{query},
+		Interval: 10 * time.Millisecond,
+		Sinks: []omapi.LeaseSink{omapi.LeaseSinkFunc(func(_ context.Context, event omapi.LeaseEvent) error {
+			events <- event
+			return nil
+		})},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watcher.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		con.Close()
+	})
+
+	<-polled
+
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan omapi.LeaseEvent) omapi.LeaseEvent {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no lease event")
+	}
+
+	return omapi.LeaseEvent{}
+}
+
+// expectNoEvent fails if an event is reported within a few polls.
+func expectNoEvent(t *testing.T, events <-chan omapi.LeaseEvent) {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event: %s", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLeaseWatcherReleaseOverOMAPI(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	ip := net.IPv4(10, 0, 0, 5).To4()
+	lease := omapi.Lease{IP: ip, State: omapi.LeaseStateActive, HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), Ends: time.Now().Add(time.Hour)}
+	server.PutLease(lease)
+
+	events := watchLease(t, server, omapi.Lease{IP: ip})
+
+	con, err := omapi.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	// Like dhcpd, the server leaves the lease active with its end time
+	// at the epoch.
+	if err := con.ReleaseLease(omapi.Lease{IP: ip}); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, events); event.Kind != omapi.LeaseReleased {
+		t.Errorf("got %s, want released", event)
+	}
+
+	// Freeing the released lease isn't another event.
+	lease.State, lease.Ends = omapi.LeaseStateFree, time.Unix(0, 0)
+	server.PutLease(lease)
+	expectNoEvent(t, events)
+}
+
+func TestLeaseWatcherExpiry(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	// End times are sent in whole seconds, so the lease runs out at
+	// least 100 milliseconds from now.
+	ends := time.Now().Add(100 * time.Millisecond).Truncate(time.Second).Add(time.Second)
+
+	ip := net.IPv4(10, 0, 0, 5).To4()
+	lease := omapi.Lease{IP: ip, State: omapi.LeaseStateActive, HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), Ends: ends}
+	server.PutLease(lease)
+
+	events := watchLease(t, server, omapi.Lease{IP: ip})
+
+	// The lease runs out while the server still has it as active.
+	if event := nextEvent(t, events); event.Kind != omapi.LeaseExpired {
+		t.Errorf("got %s, want expired", event)
+	}
+
+	lease.State = omapi.LeaseStateExpired
+	server.PutLease(lease)
+	expectNoEvent(t, events)
+}
+
+func TestLeaseWatcherClientIdentifier(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	id := []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x01}
+	ends := time.Now().Add(time.Hour)
+	server.PutLease(omapi.Lease{IP: net.IPv4(10, 0, 0, 5).To4(), State: omapi.LeaseStateActive, DHCPClientIdentifier: id, HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), Ends: ends})
+
+	events := watchLease(t, server, omapi.Lease{DHCPClientIdentifier: id})
+
+	// The client moves to another address, with a new interface. The
+	// old lease is still there, but no longer the client's.
+	server.PutLease(omapi.Lease{IP: net.IPv4(10, 0, 0, 5).To4(), State: omapi.LeaseStateFree})
+	server.PutLease(omapi.Lease{IP: net.IPv4(10, 0, 0, 6).To4(), State: omapi.LeaseStateActive, DHCPClientIdentifier: id, HardwareAddress: mustMAC(t, "00:11:22:33:44:02"), Ends: ends})
+
+	event := nextEvent(t, events)
+	if event.Kind != omapi.LeaseHardwareChanged || !event.Current.IP.Equal(net.IPv4(10, 0, 0, 6)) {
+		t.Errorf("got %s, want a hardware change on 10.0.0.6", event)
+	}
+}
@@ -0,0 +1,257 @@
+package omapi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bounds of the adaptive rate, as fractions of Limiter.Rate.
+const (
+	minRateFactor = 0.05
+	rateIncrease  = 0.05
+)
+
+// defaultIdleTimeout is how long a Limiter keeps the state of a server
+// that isn't queried, unless Limiter.IdleTimeout says otherwise.
+const defaultIdleTimeout = 10 * time.Minute
+
+// A Limiter caps the queries sent to each server, so that management
+// traffic doesn't take dhcpd, which handles one message at a time,
+// away from its DHCP clients. Its limits apply per server address,
+// across all connections that use it, so a bulk scan over a Pool is
+// limited as a whole.
+//
+// Use its Intercept method as an interceptor:
+//
+//	limiter := &omapi.Limiter{Rate: 50, MaxInFlight: 4, TargetLatency: 20 * time.Millisecond}
+//	dialer := omapi.Dialer{Interceptors: []omapi.Interceptor{limiter.Intercept}}
+//
+// Put it last in the chain, closest to the wire. It takes the time
+// invoke takes as the server's latency, which must not include what
+// interceptors after it do, such as a RetryPolicy waiting between
+// attempts. Last in the chain, it also limits the refreshes that an
+// Auditor sends before mutations:
+//
+//	dialer := omapi.Dialer{Interceptors: []omapi.Interceptor{policy.Intercept, auditor.Intercept, limiter.Intercept}}
+//
+// Queries that wait for the limiter longer than their context allows
+// fail with "timed out" or "operation canceled". A Limiter must not be
+// copied after first use.
+type Limiter struct {
+	// Rate is the number of queries per second sent to a server.
+	// Zero means no limit.
+	Rate float64
+
+	// Burst is the number of queries that may be sent at once after
+	// a quiet period, without regard to Rate. It defaults to 1.
+	Burst int
+
+	// MaxInFlight is the number of queries a server may be working on
+	// at the same time. Zero means no limit.
+	MaxInFlight int
+
+	// TargetLatency, if set, makes Rate adaptive: while the average
+	// latency of a server's queries is above it, which means that
+	// the server is busy, the rate is halved, at most once a second,
+	// down to a twentieth of Rate. Once latency recovers, the rate
+	// rises step by step back to Rate. Only the rate adapts, not
+	// MaxInFlight, so TargetLatency requires Rate; queries through a
+	// Limiter with TargetLatency but no Rate fail with "invalid
+	// argument".
+	TargetLatency time.Duration
+
+	// IdleTimeout is how long the state of a server that isn't
+	// queried is kept, including a rate lowered by TargetLatency.
+	// It defaults to 10 minutes.
+	IdleTimeout time.Duration
+
+	mu        sync.Mutex
+	servers   map[string]*serverLimit
+	lastSweep time.Time
+}
+
+// serverLimit is the state of a Limiter for a single server.
+type serverLimit struct {
+	inFlight chan struct{}
+
+	// Guarded by Limiter.mu.
+	users    int // queries between acquire and release
+	lastUsed time.Time
+
+	mu           sync.Mutex
+	tokens       float64
+	last         time.Time
+	factor       float64
+	latency      time.Duration // moving average
+	lastDecrease time.Time
+}
+
+// acquire returns the state of a server for a query, which must
+// release it when done. Servers that have been idle for longer than
+// IdleTimeout are forgotten on the way.
+func (l *Limiter) acquire(addr string) *serverLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= l.idleTimeout() {
+		for a, s := range l.servers {
+			if s.users == 0 && now.Sub(s.lastUsed) >= l.idleTimeout() {
+				delete(l.servers, a)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	s, ok := l.servers[addr]
+	if !ok {
+		if l.servers == nil {
+			l.servers = make(map[string]*serverLimit)
+		}
+		s = &serverLimit{tokens: float64(l.burst()), last: now, factor: 1}
+		if l.MaxInFlight > 0 {
+			s.inFlight = make(chan struct{}, l.MaxInFlight)
+		}
+		l.servers[addr] = s
+	}
+	s.users++
+
+	return s
+}
+
+func (l *Limiter) release(s *serverLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s.users--
+	s.lastUsed = time.Now()
+}
+
+func (l *Limiter) idleTimeout() time.Duration {
+	if l.IdleTimeout > 0 {
+		return l.IdleTimeout
+	}
+
+	return defaultIdleTimeout
+}
+
+func (l *Limiter) burst() int {
+	return max(l.Burst, 1)
+}
+
+// CurrentRate returns the rate that queries to a server are currently
+// limited to, which is lower than Rate while the server is busy. The
+// address is that of the connections, see Connection.RemoteAddr.
+func (l *Limiter) CurrentRate(addr string) float64 {
+	l.mu.Lock()
+	s, ok := l.servers[addr]
+	l.mu.Unlock()
+	if !ok {
+		return l.Rate
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return l.Rate * s.factor
+}
+
+// Intercept is an Interceptor that waits until a query may be sent.
+func (l *Limiter) Intercept(ctx context.Context, msg *Message, con *Connection, invoke Invoker) (*Message, Status) {
+	if l.TargetLatency > 0 && l.Rate <= 0 {
+		status := Statuses[39] // invalid argument
+		status.Text = "Limiter.TargetLatency requires Rate"
+		return newStatusMessage(msg, status), status
+	}
+
+	s := l.acquire(con.RemoteAddr().String())
+	defer l.release(s)
+
+	if err := l.wait(ctx, s); err != nil {
+		status := contextStatus(ctx)
+		return newStatusMessage(msg, status), status
+	}
+
+	if s.inFlight != nil {
+		select {
+		case s.inFlight <- struct{}{}:
+			defer func() { <-s.inFlight }()
+		case <-ctx.Done():
+			status := contextStatus(ctx)
+			return newStatusMessage(msg, status), status
+		}
+	}
+
+	start := time.Now()
+	response, status := invoke(ctx, msg)
+	if ctx.Err() == nil && con.Err() == nil {
+		l.observe(s, time.Since(start))
+	}
+
+	return response, status
+}
+
+// wait waits until the rate allows another query.
+func (l *Limiter) wait(ctx context.Context, s *serverLimit) error {
+	if l.Rate <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	rate := l.Rate * s.factor
+	s.tokens = min(s.tokens+now.Sub(s.last).Seconds()*rate, float64(l.burst()))
+	s.last = now
+
+	// Take a token even if there is none yet; waiting for it to
+	// be earned keeps the queries in order.
+	s.tokens--
+	var delay time.Duration
+	if s.tokens < 0 {
+		delay = time.Duration(-s.tokens / rate * float64(time.Second))
+	}
+	s.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		s.tokens++
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// observe adapts the rate to a query's latency.
+func (l *Limiter) observe(s *serverLimit, latency time.Duration) {
+	if l.TargetLatency <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = (4*s.latency + latency) / 5
+	}
+
+	now := time.Now()
+	switch {
+	case s.latency > l.TargetLatency && now.Sub(s.lastDecrease) >= time.Second:
+		s.factor = max(s.factor/2, minRateFactor)
+		s.lastDecrease = now
+	case s.latency <= l.TargetLatency:
+		s.factor = min(s.factor+rateIncrease, 1)
+	}
+}
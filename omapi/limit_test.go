@@ -0,0 +1,166 @@
+package omapi_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+// dialLimited returns a connection to a server with a host named "a",
+// whose queries pass through limiter and then the given interceptors.
+func dialLimited(t *testing.T, limiter *omapi.Limiter, interceptors ...omapi.Interceptor) (*omapitest.Server, *omapi.Connection) {
+	t.Helper()
+
+	server := omapitest.NewServer()
+	t.Cleanup(server.Close)
+
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet})
+
+	dialer := &omapi.Dialer{Interceptors: append([]omapi.Interceptor{limiter.Intercept}, interceptors...)}
+	con, err := dialer.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { con.Close() })
+
+	return server, con
+}
+
+func findHosts(t *testing.T, con *omapi.Connection, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		if _, err := con.FindHost(omapi.Host{Name: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	_, con := dialLimited(t, &omapi.Limiter{Rate: 50, Burst: 2})
+
+	// The burst is sent right away, the rest at the rate.
+	start := time.Now()
+	findHosts(t, con, 7)
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("7 queries took %s, want about 100ms", elapsed)
+	}
+}
+
+func TestLimiterContext(t *testing.T) {
+	_, con := dialLimited(t, &omapi.Limiter{Rate: 5})
+	findHosts(t, con, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := con.FindHostContext(ctx, omapi.Host{Name: "a"}); err == nil || ctx.Err() == nil {
+		t.Errorf("query that had to wait past its deadline returned %v", err)
+	}
+
+	// The canceled query gave its turn back.
+	start := time.Now()
+	findHosts(t, con, 1)
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("next query waited %s, want at most 200ms", elapsed)
+	}
+}
+
+func TestLimiterMaxInFlight(t *testing.T) {
+	var (
+		mu                sync.Mutex
+		inFlight, maximum int
+	)
+	count := func(ctx context.Context, msg *omapi.Message, con *omapi.Connection, invoke omapi.Invoker) (*omapi.Message, omapi.Status) {
+		mu.Lock()
+		inFlight++
+		maximum = max(maximum, inFlight)
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		return invoke(ctx, msg)
+	}
+
+	server, con := dialLimited(t, &omapi.Limiter{MaxInFlight: 2}, count)
+	server.SetLatency(20 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := con.FindHost(omapi.Host{Name: "a"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maximum != 2 {
+		t.Errorf("%d queries were in flight at once, want 2", maximum)
+	}
+}
+
+func TestLimiterTargetLatency(t *testing.T) {
+	limiter := &omapi.Limiter{Rate: 1000, TargetLatency: 10 * time.Millisecond}
+	server, con := dialLimited(t, limiter)
+	addr := con.RemoteAddr().String()
+
+	// A slow server halves the rate, at most once a second.
+	server.SetLatency(30 * time.Millisecond)
+	findHosts(t, con, 2)
+	if rate := limiter.CurrentRate(addr); rate != 500 {
+		t.Errorf("rate is %g while the server is slow, want 500", rate)
+	}
+
+	// Once the server is fast again, the rate recovers step by step.
+	server.SetLatency(0)
+	findHosts(t, con, 8)
+	if rate := limiter.CurrentRate(addr); rate <= 500 || rate >= 1000 {
+		t.Errorf("rate is %g while recovering, want between 500 and 1000", rate)
+	}
+
+	findHosts(t, con, 20)
+	if rate := limiter.CurrentRate(addr); rate != 1000 {
+		t.Errorf("rate is %g after recovering, want 1000", rate)
+	}
+}
+
+func TestLimiterTargetLatencyWithoutRate(t *testing.T) {
+	_, con := dialLimited(t, &omapi.Limiter{MaxInFlight: 4, TargetLatency: 10 * time.Millisecond})
+
+	if _, err := con.FindHost(omapi.Host{Name: "a"}); !errors.Is(err, omapi.Statuses[39]) {
+		t.Errorf("FindHost returned %v, want invalid argument", err)
+	}
+}
+
+func TestLimiterIdleTimeout(t *testing.T) {
+	limiter := &omapi.Limiter{Rate: 1000, TargetLatency: 10 * time.Millisecond, IdleTimeout: 50 * time.Millisecond}
+	server, con := dialLimited(t, limiter)
+	addr := con.RemoteAddr().String()
+
+	server.SetLatency(30 * time.Millisecond)
+	findHosts(t, con, 2)
+	server.SetLatency(0)
+	if rate := limiter.CurrentRate(addr); rate != 500 {
+		t.Fatalf("rate is %g while the server is slow, want 500", rate)
+	}
+
+	// A server that has been idle for longer than IdleTimeout starts
+	// over at the full rate.
+	time.Sleep(100 * time.Millisecond)
+	findHosts(t, con, 1)
+	if rate := limiter.CurrentRate(addr); rate != 1000 {
+		t.Errorf("rate is %g after being idle, want 1000", rate)
+	}
+}
@@ -0,0 +1,44 @@
+package omapi
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler is a slog.Handler that drops all records. It is used
+// for connections and servers without a logger.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discardLogger = slog.New(discardHandler{})
+
+// loggerOrDiscard returns logger, or a logger that drops everything if
+// logger is nil.
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+
+	return logger
+}
+
+// messageAttrs returns the attributes that messages are logged with.
+// Values aren't logged, since they may contain secrets, and neither
+// is the signature.
+func messageAttrs(msg *Message) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("opcode", msg.Opcode.String()),
+		slog.Int("handle", int(msg.Handle)),
+		slog.Int("tid", int(msg.TransactionID)),
+		slog.Int("rid", int(msg.ResponseID)),
+	}
+	if typeName, ok := msg.Message["type"]; ok {
+		attrs = append(attrs, slog.String("type", string(typeName)))
+	}
+
+	return attrs
+}
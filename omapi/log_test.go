@@ -0,0 +1,282 @@
+package omapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+// recordHandler is a slog.Handler that keeps the records it handles,
+// with the attributes of the logger and groups flattened into
+// "group.key" form.
+type recordHandler struct {
+	mu      *sync.Mutex
+	records *[]map[string]slog.Value
+	attrs   []slog.Attr
+}
+
+func newRecordHandler() *recordHandler {
+	return &recordHandler{mu: new(sync.Mutex), records: new([]map[string]slog.Value)}
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	record := map[string]slog.Value{"msg": slog.StringValue(r.Message)}
+
+	var add func(prefix string, attr slog.Attr)
+	add = func(prefix string, attr slog.Attr) {
+		if attr.Value.Kind() == slog.KindGroup {
+			for _, a := range attr.Value.Group() {
+				add(prefix+attr.Key+".", a)
+			}
+			return
+		}
+		record[prefix+attr.Key] = attr.Value
+	}
+	for _, attr := range h.attrs {
+		add("", attr)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		add("", attr)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, record)
+
+	return nil
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &recordHandler{mu: h.mu, records: h.records, attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *recordHandler) WithGroup(string) slog.Handler {
+	panic("groups aren't used")
+}
+
+func (h *recordHandler) find(msg string) []map[string]slog.Value {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var found []map[string]slog.Value
+	for _, record := range *h.records {
+		if record["msg"].String() == msg {
+			found = append(found, record)
+		}
+	}
+
+	return found
+}
+
+// recordingProxy forwards connections to addr and keeps what clients
+// send.
+func recordingProxy(t *testing.T, addr string) (string, func() []byte) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var (
+		mu   sync.Mutex
+		sent bytes.Buffer
+	)
+	go func() {
+		for {
+			client, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", addr)
+			if err != nil {
+				client.Close()
+				return
+			}
+			go func() {
+				defer server.Close()
+				io.Copy(server, io.TeeReader(client, writerFunc(func(p []byte) (int, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					return sent.Write(p)
+				})))
+			}()
+			go func() {
+				defer client.Close()
+				io.Copy(client, server)
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), func() []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return bytes.Clone(sent.Bytes())
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestQueryLogging(t *testing.T) {
+	const secret = "c2VjcmV0LWtleS1ieXRlcw=="
+
+	server := omapitest.NewServer()
+	defer server.Close()
+	server.AddKey("omapi_key", secret)
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet})
+
+	addr, sent := recordingProxy(t, server.Addr)
+
+	// Records go to both a handler that keeps them and a text handler,
+	// to check what actually ends up in a log.
+	handler := newRecordHandler()
+	var text bytes.Buffer
+	textHandler := slog.NewTextHandler(&text, &slog.HandlerOptions{Level: slog.LevelDebug})
+
+	dialer := &omapi.Dialer{Logger: slog.New(teeHandler{handler, textHandler})}
+	con, err := dialer.Dial(addr, "omapi_key", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	if _, err := con.FindHost(omapi.Host{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := con.FindHost(omapi.Host{Name: "b"}); err == nil {
+		t.Fatal("found host b")
+	}
+
+	queries := handler.find("Query")
+	if len(queries) != 3 {
+		t.Fatalf("got %d queries logged, want 3: the authenticator and two hosts", len(queries))
+	}
+
+	for i, query := range queries {
+		for _, key := range []string{"server", "opcode", "handle", "tid", "rid", "type", "status", "latency", "response.opcode", "response.handle", "response.tid", "response.rid"} {
+			if _, ok := query[key]; !ok {
+				t.Errorf("query %d has no %s: %v", i, key, query)
+			}
+		}
+		if query["server"].String() != addr || query["opcode"].String() != "open" || query["rid"].Int64() != 0 {
+			t.Errorf("query %d = %v", i, query)
+		}
+		if query["response.rid"].Int64() != query["tid"].Int64() {
+			t.Errorf("query %d has response ID %v for transaction ID %v", i, query["response.rid"], query["tid"])
+		}
+		if query["latency"].Kind() != slog.KindDuration || query["latency"].Duration() <= 0 {
+			t.Errorf("query %d has latency %v", i, query["latency"])
+		}
+	}
+
+	if q := queries[0]; q["type"].String() != "authenticator" || q["status"].String() != "success" || q["response.opcode"].String() != "update" {
+		t.Errorf("authenticator query = %v", q)
+	}
+	if q := queries[1]; q["type"].String() != "host" || q["status"].String() != "success" || q["response.handle"].Int64() == 0 {
+		t.Errorf("host query = %v", q)
+	}
+	if q := queries[2]; q["type"].String() != "host" || q["status"].String() != omapi.ErrNotFound.Message || q["response.opcode"].String() != "status" {
+		t.Errorf("missing host query = %v", q)
+	}
+
+	// Neither the key nor the signatures of the messages, which are
+	// derived from it, are logged in any form.
+	key, _ := base64.StdEncoding.DecodeString(secret)
+	secrets := [][]byte{[]byte(secret), key}
+
+	dec := omapi.NewDecoder(bytes.NewReader(sent()[8:]))
+	for {
+		msg, err := dec.Decode()
+		if err != nil {
+			break
+		}
+		if len(msg.Signature) > 0 {
+			secrets = append(secrets, msg.Signature)
+		}
+	}
+	if len(secrets) < 4 {
+		t.Fatalf("only %d signed messages sent", len(secrets)-2)
+	}
+
+	logged := text.String()
+	for _, s := range secrets {
+		for _, form := range []string{string(s), strconv.Quote(string(s)), hex.EncodeToString(s), base64.StdEncoding.EncodeToString(s), fmt.Sprint(s)} {
+			if strings.Contains(logged, strings.Trim(form, `"`)) {
+				t.Errorf("log contains %q:\n%s", form, logged)
+			}
+		}
+
+		for _, record := range handler.find("Query") {
+			for key, value := range record {
+				var logged []byte
+				switch v := value.Any().(type) {
+				case []byte:
+					logged = v
+				default:
+					logged = []byte(fmt.Sprint(v))
+				}
+				if bytes.Contains(logged, s) {
+					t.Errorf("%s contains %q", key, s)
+				}
+			}
+		}
+	}
+	if strings.Contains(strings.ToLower(logged), "signature") {
+		t.Errorf("log mentions the signature:\n%s", logged)
+	}
+}
+
+// teeHandler passes records to several handlers.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	for _, h := range t {
+		if err := h.Handle(ctx, r.Clone()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
@@ -24,25 +24,7 @@ func (m *Message) String() string {
 		tmpMessage[key] = string(value)
 	}
 
-	tmpObject := make(map[string]any)
-	for key, value := range m.Object {
-		switch key {
-		case "atsfp", "cltt", "tstp", "tsfp", "starts", "ends":
-			tmpObject[key] = time.Unix(int64(bytesToInt32(value)), 0)
-		case "hardware-address":
-			tmpObject[key] = net.HardwareAddr(value).String()
-		case "hardware-type":
-			tmpObject[key] = HardwareType(bytesToInt32(value)).String()
-		case "ip-address":
-			tmpObject[key] = net.IP(value)
-		case "state":
-			tmpObject[key] = LeaseState(bytesToInt32(value)).String()
-		case "remote-handle", "subnet", "pool", "flags":
-			tmpObject[key] = bytesToInt32(value)
-		default:
-			tmpObject[key] = string(value)
-		}
-	}
+	tmpObject := displayValues(m.Object)
 
 	// TODO fix dhcp-client-identifier
 
@@ -71,13 +53,42 @@ func (m *Message) String() string {
 	return string(ret)
 }
 
-func NewMessage() *Message {
+// displayValues converts an object's values into something readable,
+// for printing them as JSON.
+func displayValues(values map[string][]byte) map[string]any {
+	ret := make(map[string]any, len(values))
+	for key, value := range values {
+		switch key {
+		case "atsfp", "cltt", "tstp", "tsfp", "starts", "ends":
+			ret[key] = time.Unix(int64(bytesToInt32(value)), 0)
+		case "hardware-address":
+			ret[key] = net.HardwareAddr(value).String()
+		case "hardware-type":
+			ret[key] = HardwareType(bytesToInt32(value)).String()
+		case "ip-address":
+			ret[key] = net.IP(value)
+		case "state":
+			ret[key] = LeaseState(bytesToInt32(value)).String()
+		case "remote-handle", "subnet", "pool", "flags":
+			ret[key] = bytesToInt32(value)
+		default:
+			ret[key] = string(value)
+		}
+	}
+
+	return ret
+}
+
+func newTransactionID() int32 {
 	rng.Lock()
-	tid := rng.Int31()
-	rng.Unlock()
+	defer rng.Unlock()
+
+	return rng.Int31()
+}
 
+func NewMessage() *Message {
 	msg := &Message{
-		TransactionID: tid,
+		TransactionID: newTransactionID(),
 		Message:       make(map[string][]byte),
 		Object:        make(map[string][]byte),
 	}
@@ -101,6 +112,14 @@ func NewCreateMessage(typeName string) *Message {
 	return message
 }
 
+func NewUpdateMessage(handle int32) *Message {
+	message := NewMessage()
+	message.Opcode = OpUpdate
+	message.Handle = handle
+
+	return message
+}
+
 func NewDeleteMessage(handle int32) *Message {
 	message := NewMessage()
 	message.Opcode = OpDelete
@@ -109,6 +128,17 @@ func NewDeleteMessage(handle int32) *Message {
 	return message
 }
 
+// newStatusMessage returns a status message in response to the given
+// message.
+func newStatusMessage(to *Message, status Status) *Message {
+	message := NewMessage()
+	message.Opcode = OpStatus
+	message.ResponseID = to.TransactionID
+	message.Message["result"] = int32ToBytes(status.Code)
+
+	return message
+}
+
 func (m *Message) Bytes(forSigning bool) []byte {
 	ret := newBuffer()
 	if !forSigning {
@@ -153,17 +183,24 @@ func (m *Message) ToHost() Host {
 	}
 }
 
+// ToStatus returns the status that a status message carries, with the
+// server's explanation, if any, as its Text. Other messages are
+// successes.
 func (m *Message) ToStatus() Status {
 	if m.Opcode != OpStatus {
 		return Statuses[0]
 	}
 
-	return Statuses[bytesToInt32(m.Message["result"])]
+	status := StatusForCode(bytesToInt32(m.Message["result"]))
+	status.Text = string(m.Message["message"])
+
+	return status
 }
 
 func (m *Message) ToLease() Lease {
 	state := bytesToInt32(m.Object["state"])
 	host := bytesToInt32(m.Object["host"])
+	starts := bytesToInt32(m.Object["starts"])
 	ends := bytesToInt32(m.Object["ends"])
 	tstp := bytesToInt32(m.Object["tstp"])
 	atsfp := bytesToInt32(m.Object["atsfp"])
@@ -177,6 +214,7 @@ func (m *Message) ToLease() Lease {
 		Host:                 host,
 		HardwareAddress:      net.HardwareAddr(m.Object["hardware-address"]),
 		HardwareType:         HardwareType(bytesToInt32(m.Object["hardware-type"])),
+		Starts:               time.Unix(int64(starts), 0),
 		Ends:                 time.Unix(int64(ends), 0),
 		Tstp:                 time.Unix(int64(tstp), 0),
 		Atsfp:                time.Unix(int64(atsfp), 0),
@@ -0,0 +1,166 @@
+// Package omapiotel traces OMAPI connections with OpenTelemetry. Every
+// high-level operation, such as FindHost, and every query it sends
+// become spans, children of the span in the caller's context.
+//
+// It is a separate module, so that users of the omapi package don't
+// depend on OpenTelemetry.
+//
+// Example:
+//
+//	var dialer omapi.Dialer
+//	omapiotel.Instrument(&dialer)
+//
+//	con, err := dialer.Dial("dhcp1:7911", "omapi_key", key)
+//	host, err := con.FindHostContext(ctx, omapi.Host{Name: "printer"})
+package omapiotel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+const instrumentationName = "github.com/loopinternet/dhcp-management/omapi/omapiotel"
+
+// Attributes of the spans, besides server.address and server.port.
+const (
+	OpcodeKey        = attribute.Key("omapi.opcode")
+	ObjectTypeKey    = attribute.Key("omapi.object_type")
+	HandleKey        = attribute.Key("omapi.handle")
+	TransactionIDKey = attribute.Key("omapi.transaction_id")
+	StatusCodeKey    = attribute.Key("omapi.status.code")
+	StatusKey        = attribute.Key("omapi.status")
+)
+
+type config struct {
+	provider trace.TracerProvider
+}
+
+// An Option configures the interceptors.
+type Option func(*config)
+
+// WithTracerProvider sets the tracer provider to use instead of the
+// global one.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+func newTracer(opts []Option) trace.Tracer {
+	c := config{provider: otel.GetTracerProvider()}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c.provider.Tracer(instrumentationName)
+}
+
+// Instrument adds the interceptors of this package to a dialer, so
+// that all connections it dials, including those of a Pool using it,
+// are traced.
+func Instrument(d *omapi.Dialer, opts ...Option) {
+	d.Interceptors = append(d.Interceptors, Interceptor(opts...))
+	d.OperationInterceptors = append(d.OperationInterceptors, OperationInterceptor(opts...))
+}
+
+// Interceptor returns an interceptor that creates a client span for
+// every query, named after its opcode and object type, such as
+// "omapi open host".
+func Interceptor(opts ...Option) omapi.Interceptor {
+	tracer := newTracer(opts)
+
+	return func(ctx context.Context, msg *omapi.Message, con *omapi.Connection, invoke omapi.Invoker) (*omapi.Message, omapi.Status) {
+		name := "omapi " + msg.Opcode.String()
+		typeName, hasType := msg.Message["type"]
+		if hasType {
+			name += " " + string(typeName)
+		}
+
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(serverAttrs(con)...),
+			trace.WithAttributes(OpcodeKey.String(msg.Opcode.String())))
+		defer span.End()
+
+		if hasType {
+			span.SetAttributes(ObjectTypeKey.String(string(typeName)))
+		}
+
+		response, status := invoke(ctx, msg)
+
+		// The transaction ID is only final once the message was
+		// sent, and opening an object returns its handle.
+		handle := msg.Handle
+		if response != nil && response.Handle != 0 {
+			handle = response.Handle
+		}
+		span.SetAttributes(
+			TransactionIDKey.Int(int(msg.TransactionID)),
+			HandleKey.Int(int(handle)))
+		setStatus(span, status)
+
+		return response, status
+	}
+}
+
+// OperationInterceptor returns an operation interceptor that creates a
+// span for every high-level operation, named after it, such as
+// "omapi.FindHost". The spans of its queries are its children.
+func OperationInterceptor(opts ...Option) omapi.OperationInterceptor {
+	tracer := newTracer(opts)
+
+	return func(ctx context.Context, op string, con *omapi.Connection, run func(ctx context.Context) error) error {
+		ctx, span := tracer.Start(ctx, "omapi."+op, trace.WithAttributes(serverAttrs(con)...))
+		defer span.End()
+
+		err := run(ctx)
+
+		var status omapi.Status
+		switch {
+		case err == nil:
+			setStatus(span, omapi.Statuses[0])
+		case errors.As(err, &status):
+			setStatus(span, status)
+		default:
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		return err
+	}
+}
+
+// setStatus records the status of a query or operation on its span.
+func setStatus(span trace.Span, status omapi.Status) {
+	span.SetAttributes(
+		StatusCodeKey.Int(int(status.Code)),
+		StatusKey.String(status.Message))
+
+	if status.IsError() {
+		span.SetStatus(codes.Error, status.Message)
+	}
+}
+
+func serverAttrs(con *omapi.Connection) []attribute.KeyValue {
+	host, port, err := net.SplitHostPort(con.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+
+	attrs := []attribute.KeyValue{semconv.ServerAddress(host)}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.ServerPort(p))
+	}
+
+	return attrs
+}
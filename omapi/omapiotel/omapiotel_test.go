@@ -0,0 +1,141 @@
+package omapiotel_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapiotel"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+// dial returns a connection to server that records its spans.
+func dial(t *testing.T, server *omapitest.Server) (*omapi.Connection, *tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	var dialer omapi.Dialer
+	omapiotel.Instrument(&dialer, omapiotel.WithTracerProvider(provider))
+
+	con, err := dialer.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { con.Close() })
+
+	return con, recorder, provider
+}
+
+// spans returns the ended spans by name.
+func spans(t *testing.T, recorder *tracetest.SpanRecorder, want int) map[string]sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	ended := recorder.Ended()
+	if len(ended) != want {
+		t.Fatalf("got %d spans, want %d", len(ended), want)
+	}
+
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range ended {
+		byName[span.Name()] = span
+	}
+
+	return byName
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+
+	return attrs
+}
+
+func TestSpans(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	mac, _ := net.ParseMAC("00:11:22:33:44:01")
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mac, HardwareType: omapi.Ethernet})
+
+	con, recorder, provider := dial(t, server)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	host, err := con.FindHostContext(ctx, omapi.Host{Name: "a"})
+	parent.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := spans(t, recorder, 3)
+	operation, query := byName["omapi.FindHost"], byName["omapi open host"]
+	if operation == nil || query == nil {
+		t.Fatalf("got spans %v", byName)
+	}
+
+	// The caller's span is the operation's parent, which is the
+	// query's parent.
+	if operation.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("operation span's parent is %s, want %s", operation.Parent().SpanID(), parent.SpanContext().SpanID())
+	}
+	if query.Parent().SpanID() != operation.SpanContext().SpanID() {
+		t.Errorf("query span's parent is %s, want %s", query.Parent().SpanID(), operation.SpanContext().SpanID())
+	}
+	if query.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("query span is in trace %s, want %s", query.SpanContext().TraceID(), parent.SpanContext().TraceID())
+	}
+
+	attrs := attributes(query)
+	for key, want := range map[attribute.Key]attribute.Value{
+		omapiotel.OpcodeKey:     attribute.StringValue("open"),
+		omapiotel.ObjectTypeKey: attribute.StringValue("host"),
+		omapiotel.HandleKey:     attribute.IntValue(int(host.Handle)),
+		omapiotel.StatusCodeKey: attribute.IntValue(0),
+		omapiotel.StatusKey:     attribute.StringValue("success"),
+		"server.address":        attribute.StringValue("127.0.0.1"),
+	} {
+		if got := attrs[key]; got != want {
+			t.Errorf("query span has %s = %s, want %s", key, got.Emit(), want.Emit())
+		}
+	}
+	if tid := attrs[omapiotel.TransactionIDKey]; tid.Type() != attribute.INT64 {
+		t.Errorf("query span has no transaction ID")
+	}
+	if query.Status().Code == codes.Error || operation.Status().Code == codes.Error {
+		t.Errorf("spans have error status")
+	}
+}
+
+func TestSpanStatus(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	con, recorder, _ := dial(t, server)
+
+	if _, err := con.FindHostContext(context.Background(), omapi.Host{Name: "missing"}); !errors.Is(err, omapi.ErrNotFound) {
+		t.Fatalf("got %v, want not found", err)
+	}
+
+	byName := spans(t, recorder, 2)
+	for _, span := range byName {
+		if span.Status().Code != codes.Error || span.Status().Description != "not found" {
+			t.Errorf("span %q has status %v, want not found error", span.Name(), span.Status())
+		}
+		if code := attributes(span)[omapiotel.StatusCodeKey]; code != attribute.IntValue(23) {
+			t.Errorf("span %q has status code %s, want 23", span.Name(), code.Emit())
+		}
+	}
+	if byName["omapi.FindHost"].Parent().IsValid() {
+		t.Errorf("operation span has a parent without one in the context")
+	}
+}
@@ -0,0 +1,148 @@
+package omapitest
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// startupSize is the size of the startup message, which precedes the
+// messages on a connection.
+const startupSize = 8
+
+// faultListener wraps the connections it accepts in faultConns.
+type faultListener struct {
+	net.Listener
+	server *Server
+}
+
+func (l *faultListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	fc := &faultConn{Conn: c, server: l.server}
+
+	l.server.mu.Lock()
+	l.server.conns[fc] = struct{}{}
+	l.server.mu.Unlock()
+
+	return fc, nil
+}
+
+// A faultConn injects the server's faults into a connection. It reads
+// whole messages from the client, so that it can take the next fault
+// for each of them before the omapi.Server sees it. The server handles
+// one message at a time and writes each response in a single write,
+// so the response that a write carries is the one to the last message
+// read.
+type faultConn struct {
+	net.Conn
+	server *Server
+
+	started bool
+	decoder *omapi.Decoder
+	buf     []byte
+
+	mu    sync.Mutex
+	fault Fault
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	if len(c.buf) == 0 {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+// next reads the startup message or the next message into c.buf.
+func (c *faultConn) next() error {
+	if !c.started {
+		c.started = true
+		c.buf = make([]byte, startupSize)
+		_, err := io.ReadFull(c.Conn, c.buf)
+		c.decoder = omapi.NewDecoder(c.Conn)
+
+		return err
+	}
+
+	message, err := c.decoder.Decode()
+	if err != nil {
+		return err
+	}
+
+	fault, delay := c.server.nextFault()
+	time.Sleep(delay)
+	if fault.Drop {
+		c.Conn.Close()
+		return io.EOF
+	}
+
+	c.mu.Lock()
+	c.fault = fault
+	c.mu.Unlock()
+
+	c.buf = message.Bytes(false)
+
+	return nil
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	fault := c.fault
+	c.mu.Unlock()
+
+	// The signature is at the end of a message, and its length in the
+	// second field of the header.
+	if fault.BadSignature && len(p) >= startupSize && binary.BigEndian.Uint32(p[4:8]) > 0 {
+		p = append([]byte(nil), p...)
+		p[len(p)-1] ^= 0xff
+	}
+
+	return c.Conn.Write(p)
+}
+
+func (c *faultConn) Close() error {
+	c.server.mu.Lock()
+	delete(c.server.conns, c)
+	c.server.mu.Unlock()
+
+	return c.Conn.Close()
+}
+
+// faultStatus returns the status fault for the message being handled.
+func (c *faultConn) faultStatus() omapi.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.fault.Status
+}
+
+type connKey struct{}
+
+// injectedStatus returns the status fault for the message that the
+// handler was called for, or nil if there is none.
+func injectedStatus(ctx context.Context) error {
+	c, ok := ctx.Value(connKey{}).(*faultConn)
+	if !ok {
+		return nil
+	}
+
+	if status := c.faultStatus(); status.IsError() {
+		return statusWithText(status, "injected fault")
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package omapitest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// An object is a stored object. Its ID orders objects by creation;
+// clients see them through the handles of the omapi.Server instead.
+type object struct {
+	typeName string
+	id       int32
+	values   map[string][]byte
+}
+
+// lookupKeys lists, per object type, the values that open messages
+// can look up objects by.
+var lookupKeys = map[string][]string{
+	"host":           {"name", "dhcp-client-identifier", "hardware-address", "ip-address"},
+	"lease":          {"ip-address", "dhcp-client-identifier", "hardware-address"},
+	"failover-state": {"name"},
+}
+
+// hiddenValues lists values that are stored but, like in dhcpd, never
+// returned to clients.
+var hiddenValues = map[string]bool{
+	"statements": true,
+}
+
+// visibleValues returns the values of the object that are returned to
+// clients.
+func (obj *object) visibleValues() map[string][]byte {
+	values := make(map[string][]byte)
+	for key, value := range obj.values {
+		if !hiddenValues[key] {
+			values[key] = value
+		}
+	}
+
+	return values
+}
+
+// copyValues returns all values of the object, including hidden ones.
+func (obj *object) copyValues() map[string][]byte {
+	values := make(map[string][]byte, len(obj.values))
+	for key, value := range obj.values {
+		values[key] = value
+	}
+
+	return values
+}
+
+func (obj *object) matches(key string, query map[string][]byte) bool {
+	if !bytes.Equal(obj.values[key], query[key]) {
+		return false
+	}
+
+	if key == "hardware-address" && len(query["hardware-type"]) > 0 {
+		return bytes.Equal(obj.values["hardware-type"], query["hardware-type"])
+	}
+
+	return true
+}
+
+// update merges values into the object. Empty values unset the
+// respective value.
+func (obj *object) update(values map[string][]byte) {
+	for key, value := range values {
+		if len(value) == 0 {
+			delete(obj.values, key)
+		} else {
+			obj.values[key] = value
+		}
+	}
+}
+
+type objectValues map[string][]byte
+
+func (v objectValues) set(key string, value []byte) {
+	if len(value) > 0 {
+		v[key] = value
+	}
+}
+
+func (v objectValues) setInt32(key string, value int32) {
+	if value != 0 {
+		v[key] = int32ToBytes(value)
+	}
+}
+
+func (v objectValues) setTime(key string, value time.Time) {
+	if !value.IsZero() {
+		v[key] = int32ToBytes(int32(value.Unix()))
+	}
+}
+
+func hostValues(host omapi.Host) objectValues {
+	v := make(objectValues)
+	v.set("name", []byte(host.Name))
+	v.set("hardware-address", host.HardwareAddress)
+	v.setInt32("hardware-type", int32(host.HardwareType))
+	v.set("ip-address", host.IP.To4())
+	v.set("dhcp-client-identifier", host.DHCPClientIdentifier)
+	v.set("statements", []byte(host.Statements))
+
+	return v
+}
+
+func leaseValues(lease omapi.Lease) objectValues {
+	v := make(objectValues)
+	v.setInt32("state", int32(lease.State))
+	v.set("ip-address", lease.IP.To4())
+	v.set("dhcp-client-identifier", lease.DHCPClientIdentifier)
+	v.set("client-hostname", []byte(lease.ClientHostname))
+	v.setInt32("host", lease.Host)
+	v.set("hardware-address", lease.HardwareAddress)
+	v.setInt32("hardware-type", int32(lease.HardwareType))
+	v.setTime("starts", lease.Starts)
+	v.setTime("ends", lease.Ends)
+	v.setTime("tstp", lease.Tstp)
+	v.setTime("atsfp", lease.Atsfp)
+	v.setTime("cltt", lease.Cltt)
+
+	return v
+}
+
+func failoverValues(failover omapi.Failover) objectValues {
+	v := make(objectValues)
+	v.set("name", []byte(failover.Name))
+	v.set("partner-address", failover.PartnerAddress.To4())
+	v.set("local-address", failover.LocalAddress.To4())
+	v.setInt32("partner-port", failover.PartnerPort)
+	v.setInt32("local-port", failover.LocalPort)
+	v.setInt32("max-outstanding-updates", failover.MaxOutstandingUpdates)
+	v.setInt32("mclt", failover.Mclt)
+	v.setInt32("load-balance-max-secs", failover.LoadBalanceMaxSecs)
+	v.set("load-balance-hba", failover.LoadBalanceHBA)
+	v.setInt32("local-state", int32(failover.LocalState))
+	v.setInt32("partner-state", int32(failover.PartnerState))
+	v.setTime("local-stos", failover.LocalStos)
+	v.setTime("partner-stos", failover.PartnerStos)
+	// Primary is 0, so the hierarchy is always set.
+	v["hierarchy"] = int32ToBytes(int32(failover.Hierarchy))
+	v.setTime("last-packet-sent", failover.LastPacketSent)
+	v.setTime("last-timestamp-received", failover.LastTimestampReceived)
+	v.setInt32("skew", failover.Skew)
+	v.setInt32("max-response-delay", failover.MaxResponseDelay)
+	v.setInt32("cur-unacked-updates", failover.CurUnackedUpdates)
+
+	return v
+}
+
+func int32ToBytes(i int32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(i))
+
+	return b
+}
@@ -0,0 +1,383 @@
+// Package omapitest provides an in-memory OMAPI server for testing
+// code that uses the omapi package, without having to run dhcpd.
+//
+// The server is an omapi.Server, so it speaks the same wire protocol
+// as dhcpd, including HMAC-MD5 authentication, with in-memory handlers
+// for host, lease and failover-state objects. Hosts can be created,
+// updated and deleted by clients; leases and failover states can only
+// be set up by the test. Failures such as latency, dropped connections
+// and bad signatures can be injected on demand.
+//
+// Example:
+//
+//	server := omapitest.NewServer()
+//	defer server.Close()
+//
+//	server.PutLease(omapi.Lease{IP: net.ParseIP("10.0.0.5"), State: omapi.LeaseStateActive})
+//
+//	connection, err := omapi.Dial(server.Addr, "", "")
+package omapitest
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// A Fault describes a failure to inject into the handling of a single
+// message.
+type Fault struct {
+	// Delay delays the response, in addition to the server's
+	// latency.
+	Delay time.Duration
+
+	// Drop closes the connection instead of responding.
+	Drop bool
+
+	// BadSignature corrupts the signature of the response. It has no
+	// effect on unauthenticated connections.
+	BadSignature bool
+
+	// Status, if it is an error, is returned instead of handling the
+	// message. It only applies to messages for objects, not to opening
+	// an authenticator.
+	Status omapi.Status
+}
+
+// A Server is an in-memory OMAPI server listening on a loopback
+// address.
+type Server struct {
+	// Addr is the address the server listens on, suitable for
+	// omapi.Dial.
+	Addr string
+
+	server *omapi.Server
+	done   chan struct{}
+
+	mu      sync.Mutex
+	objects map[int32]*object
+	nextID  int32
+	conns   map[*faultConn]struct{}
+	faults  []Fault
+	latency time.Duration
+}
+
+// NewServer starts and returns a new server. The caller should call
+// Close when finished, to shut it down.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("omapitest: failed to listen: %v", err))
+	}
+
+	s := &Server{
+		Addr:    listener.Addr().String(),
+		server:  omapi.NewServer(),
+		done:    make(chan struct{}),
+		objects: make(map[int32]*object),
+		nextID:  1,
+		conns:   make(map[*faultConn]struct{}),
+	}
+
+	s.server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, connKey{}, c)
+	}
+	s.server.Handle("host", &handler{server: s, typeName: "host", mutable: true})
+	s.server.Handle("lease", &handler{server: s, typeName: "lease"})
+	s.server.Handle("failover-state", &handler{server: s, typeName: "failover-state"})
+
+	go func() {
+		defer close(s.done)
+		s.server.Serve(&faultListener{listener, s})
+	}()
+
+	return s
+}
+
+// Close shuts down the server and closes all connections.
+func (s *Server) Close() {
+	s.server.Close()
+	<-s.done
+}
+
+// AddKey adds a key that clients can authenticate with, given its
+// name and base64 encoded secret. Once a key has been added, the
+// server rejects unauthenticated queries.
+func (s *Server) AddKey(name, secret string) {
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		panic(fmt.Sprintf("omapitest: invalid key: %v", err))
+	}
+
+	s.server.SetKey(name, key)
+}
+
+// SetLatency delays all responses by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = d
+}
+
+// InjectFault queues a fault. Each received message consumes one
+// queued fault, in order.
+func (s *Server) InjectFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, fault)
+}
+
+// DropConnections closes all current connections.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.Conn.Close()
+	}
+}
+
+// PutHost stores a host, replacing any host of the same name.
+func (s *Server) PutHost(host omapi.Host) {
+	s.put("host", "name", hostValues(host))
+}
+
+// PutLease stores a lease, replacing any lease of the same address.
+func (s *Server) PutLease(lease omapi.Lease) {
+	s.put("lease", "ip-address", leaseValues(lease))
+}
+
+// PutFailover stores a failover state, replacing any failover state
+// of the same name.
+func (s *Server) PutFailover(failover omapi.Failover) {
+	s.put("failover-state", "name", failoverValues(failover))
+}
+
+// RemoveHost removes the host of the given name, if it exists.
+func (s *Server) RemoveHost(name string) {
+	s.remove("host", "name", []byte(name))
+}
+
+// RemoveLease removes the lease of the given address, if it exists.
+func (s *Server) RemoveLease(ip net.IP) {
+	s.remove("lease", "ip-address", ip.To4())
+}
+
+// Hosts returns all stored hosts, including their statements.
+func (s *Server) Hosts() []omapi.Host {
+	var hosts []omapi.Host
+	for _, obj := range s.list("host") {
+		host := (&omapi.Message{Object: obj.values}).ToHost()
+		host.Statements = string(obj.values["statements"])
+		hosts = append(hosts, host)
+	}
+
+	return hosts
+}
+
+// Leases returns all stored leases.
+func (s *Server) Leases() []omapi.Lease {
+	var leases []omapi.Lease
+	for _, obj := range s.list("lease") {
+		leases = append(leases, (&omapi.Message{Object: obj.values}).ToLease())
+	}
+
+	return leases
+}
+
+func (s *Server) put(typeName, key string, values objectValues) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, err := s.lookup(typeName, map[string][]byte{key: values[key]})
+	if err != nil {
+		obj = s.newObject(typeName)
+	}
+	obj.values = values
+}
+
+func (s *Server) remove(typeName, key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if obj, err := s.lookup(typeName, map[string][]byte{key: value}); err == nil {
+		delete(s.objects, obj.id)
+	}
+}
+
+// list returns copies of the objects of a type, in the order they were
+// created.
+func (s *Server) list(typeName string) []*object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var objects []*object
+	for id := int32(1); id < s.nextID; id++ {
+		if obj, ok := s.objects[id]; ok && obj.typeName == typeName {
+			objects = append(objects, &object{typeName: typeName, id: id, values: obj.copyValues()})
+		}
+	}
+
+	return objects
+}
+
+// newObject stores a new, empty object. It must be called with s.mu
+// held.
+func (s *Server) newObject(typeName string) *object {
+	obj := &object{typeName: typeName, id: s.nextID, values: make(map[string][]byte)}
+	s.objects[obj.id] = obj
+	s.nextID++
+
+	return obj
+}
+
+// lookup finds the object of the given type that matches all keys set
+// in the query. It must be called with s.mu held.
+func (s *Server) lookup(typeName string, query map[string][]byte) (*object, error) {
+	var found *object
+	for _, key := range lookupKeys[typeName] {
+		if len(query[key]) == 0 {
+			continue
+		}
+
+		var matches []*object
+		for _, obj := range s.objects {
+			if obj.typeName == typeName && obj.matches(key, query) {
+				matches = append(matches, obj)
+			}
+		}
+
+		switch {
+		case len(matches) == 0:
+			return nil, omapi.ErrNotFound
+		case len(matches) > 1:
+			return nil, omapi.ErrMultipleMatches
+		case found != nil && found != matches[0]:
+			return nil, omapi.ErrKeyConflict
+		}
+
+		found = matches[0]
+	}
+
+	if found == nil {
+		return nil, omapi.Statuses[46] // no key specified
+	}
+
+	return found, nil
+}
+
+// nextFault returns the fault for the next message and the time to
+// delay it by.
+func (s *Server) nextFault() (Fault, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var fault Fault
+	if len(s.faults) > 0 {
+		fault, s.faults = s.faults[0], s.faults[1:]
+	}
+
+	return fault, s.latency + fault.Delay
+}
+
+// handler is the omapi.ObjectHandler for the objects of one type.
+// Only mutable objects can be created and deleted by clients.
+type handler struct {
+	server   *Server
+	typeName string
+	mutable  bool
+}
+
+func (h *handler) Open(ctx context.Context, query map[string][]byte) (any, error) {
+	if err := injectedStatus(ctx); err != nil {
+		return nil, err
+	}
+
+	h.server.mu.Lock()
+	defer h.server.mu.Unlock()
+
+	return h.server.lookup(h.typeName, query)
+}
+
+func (h *handler) Create(_ context.Context, values map[string][]byte) (any, error) {
+	if !h.mutable {
+		return nil, statusWithText(omapi.Statuses[27], "objects of this type cannot be created")
+	}
+	if len(values["name"]) == 0 {
+		return nil, statusWithText(omapi.Statuses[46], "host needs a name")
+	}
+
+	h.server.mu.Lock()
+	defer h.server.mu.Unlock()
+
+	obj := h.server.newObject(h.typeName)
+	obj.update(values)
+
+	return obj, nil
+}
+
+func (h *handler) Update(ctx context.Context, obj any, values map[string][]byte) error {
+	if err := injectedStatus(ctx); err != nil {
+		return err
+	}
+
+	h.server.mu.Lock()
+	defer h.server.mu.Unlock()
+
+	o := obj.(*object)
+	if h.server.objects[o.id] != o {
+		return statusWithText(omapi.ErrNotFound, "object was removed")
+	}
+	o.update(values)
+
+	return nil
+}
+
+func (h *handler) Delete(ctx context.Context, obj any) error {
+	if err := injectedStatus(ctx); err != nil {
+		return err
+	}
+	if !h.mutable {
+		return statusWithText(omapi.Statuses[27], "object cannot be deleted")
+	}
+
+	h.server.mu.Lock()
+	defer h.server.mu.Unlock()
+
+	o := obj.(*object)
+	if h.server.objects[o.id] != o {
+		return statusWithText(omapi.ErrNotFound, "object was removed")
+	}
+	delete(h.server.objects, o.id)
+
+	return nil
+}
+
+func (h *handler) Refresh(ctx context.Context, obj any) (map[string][]byte, error) {
+	if err := injectedStatus(ctx); err != nil {
+		return nil, err
+	}
+
+	h.server.mu.Lock()
+	defer h.server.mu.Unlock()
+
+	o := obj.(*object)
+	if h.server.objects[o.id] != o {
+		return nil, statusWithText(omapi.ErrNotFound, "object was removed")
+	}
+
+	return o.visibleValues(), nil
+}
+
+func statusWithText(status omapi.Status, text string) omapi.Status {
+	status.Text = text
+
+	return status
+}
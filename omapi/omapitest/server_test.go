@@ -0,0 +1,363 @@
+package omapitest_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+const (
+	testKeyName = "omapi_key"
+	testKey     = "c2VjcmV0LWtleS1mb3ItdGVzdHM="
+)
+
+// dial connects to the server with an omapi.Dialer, authenticating
+// if the server has the test key.
+func dial(t *testing.T, server *omapitest.Server, keyName, key string) *omapi.Connection {
+	t.Helper()
+
+	var d omapi.Dialer
+	con, err := d.Dial(server.Addr, keyName, key)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { con.Close() })
+
+	return con
+}
+
+func mustMAC(t *testing.T, s string) net.HardwareAddr {
+	t.Helper()
+
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mac
+}
+
+func TestHosts(t *testing.T) {
+	for _, auth := range []bool{false, true} {
+		name := "unauthenticated"
+		if auth {
+			name = "authenticated"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			server := omapitest.NewServer()
+			defer server.Close()
+
+			keyName, key := "", ""
+			if auth {
+				server.AddKey(testKeyName, testKey)
+				keyName, key = testKeyName, testKey
+			}
+			con := dial(t, server, keyName, key)
+
+			mac := mustMAC(t, "00:11:22:33:44:55")
+			created, err := con.CreateHost(omapi.Host{Name: "printer", HardwareAddress: mac, HardwareType: 1, IP: net.ParseIP("10.0.0.5"), Statements: "ddns-hostname printer;"})
+			if err != nil {
+				t.Fatalf("CreateHost: %v", err)
+			}
+			if created.Handle == 0 {
+				t.Error("created host has no handle")
+			}
+
+			if _, err := con.CreateHost(omapi.Host{Name: "printer", HardwareAddress: mac, HardwareType: 1}); !errors.Is(err, omapi.ErrAlreadyExists) {
+				t.Errorf("creating a duplicate returned %v, want already exists", err)
+			}
+
+			// Statements are stored but, like in dhcpd, not
+			// returned.
+			hosts := server.Hosts()
+			if len(hosts) != 1 || hosts[0].Statements != "ddns-hostname printer;" {
+				t.Errorf("server has hosts %+v", hosts)
+			}
+
+			found, err := con.FindHost(omapi.Host{HardwareAddress: mac, HardwareType: 1})
+			if err != nil {
+				t.Fatalf("FindHost: %v", err)
+			}
+			if found.Name != "printer" || !found.IP.Equal(net.ParseIP("10.0.0.5")) {
+				t.Errorf("FindHost returned %+v", found)
+			}
+
+			if err := con.Update(found.Handle, map[string][]byte{"ip-address": net.ParseIP("10.0.0.6").To4()}); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+
+			refreshed, err := con.RefreshObject(found.Handle)
+			if err != nil {
+				t.Fatalf("RefreshObject: %v", err)
+			}
+			if ip := refreshed.ToHost().IP; !ip.Equal(net.ParseIP("10.0.0.6")) {
+				t.Errorf("refreshed host has IP %s, want 10.0.0.6", ip)
+			}
+
+			if err := con.Delete(found.Handle); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := con.FindHost(omapi.Host{Name: "printer"}); !errors.Is(err, omapi.ErrNotFound) {
+				t.Errorf("FindHost after Delete returned %v, want not found", err)
+			}
+			if _, err := con.RefreshObject(found.Handle); !errors.Is(err, omapi.ErrNotFound) {
+				t.Errorf("refreshing a deleted handle returned %v, want not found", err)
+			}
+		})
+	}
+}
+
+func TestLeasesAndFailover(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	ends := time.Now().Add(time.Hour).Truncate(time.Second)
+	server.PutLease(omapi.Lease{
+		IP:              net.ParseIP("10.0.0.7"),
+		State:           omapi.LeaseStateActive,
+		HardwareAddress: mustMAC(t, "00:11:22:33:44:66"),
+		HardwareType:    1,
+		Ends:            ends,
+	})
+	server.PutFailover(omapi.Failover{Name: "pair", LocalState: omapi.FailoverStateNormal})
+
+	con := dial(t, server, "", "")
+
+	found, err := con.FindLease(omapi.Lease{IP: net.ParseIP("10.0.0.7")})
+	if err != nil {
+		t.Fatalf("FindLease: %v", err)
+	}
+	if found.Handle == 0 || found.State != omapi.LeaseStateActive || !found.Ends.Equal(ends) {
+		t.Errorf("FindLease returned %+v", found)
+	}
+
+	if _, err := con.CreateObject("lease", map[string][]byte{"ip-address": net.ParseIP("10.0.0.8").To4()}); err == nil {
+		t.Error("creating a lease succeeded")
+	}
+	if err := con.Delete(found.Handle); err == nil {
+		t.Error("deleting a lease succeeded")
+	}
+
+	failover, err := con.FindFailover("pair")
+	if err != nil {
+		t.Fatalf("FindFailover: %v", err)
+	}
+	if failover.LocalState != omapi.FailoverStateNormal {
+		t.Errorf("failover state is %s, want normal", failover.LocalState)
+	}
+
+	server.RemoveLease(net.ParseIP("10.0.0.7"))
+	if _, err := con.FindLease(omapi.Lease{IP: net.ParseIP("10.0.0.7")}); !errors.Is(err, omapi.ErrNotFound) {
+		t.Errorf("FindLease after RemoveLease returned %v, want not found", err)
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:00:00:00:00:01"), HardwareType: 1, IP: net.ParseIP("10.0.0.1")})
+	server.PutHost(omapi.Host{Name: "b", HardwareAddress: mustMAC(t, "00:00:00:00:00:02"), HardwareType: 1, IP: net.ParseIP("10.0.0.1")})
+
+	con := dial(t, server, "", "")
+
+	tests := []struct {
+		name  string
+		query omapi.Host
+		want  error
+	}{
+		{"multiple matches", omapi.Host{IP: net.ParseIP("10.0.0.1")}, omapi.ErrMultipleMatches},
+		{"key conflict", omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:00:00:00:00:02"), HardwareType: 1}, omapi.ErrKeyConflict},
+		{"not found", omapi.Host{Name: "c"}, omapi.ErrNotFound},
+		{"no key", omapi.Host{}, omapi.Statuses[46]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := con.FindHost(tt.query); !errors.Is(err, tt.want) {
+				t.Errorf("FindHost returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Handles are only valid on the connection they were opened on, and
+// each open returns a new one.
+func TestHandles(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:00:00:00:00:01"), HardwareType: 1})
+	server.PutHost(omapi.Host{Name: "b", HardwareAddress: mustMAC(t, "00:00:00:00:00:02"), HardwareType: 1})
+
+	first := dial(t, server, "", "")
+	second := dial(t, server, "", "")
+
+	a, err := first.FindHost(omapi.Host{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := first.FindHost(omapi.Host{Name: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := first.FindHost(omapi.Host{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Handle == b.Handle || a.Handle == again.Handle {
+		t.Errorf("opens returned handles %d, %d and %d", a.Handle, b.Handle, again.Handle)
+	}
+
+	// Both handles of a refer to the same object.
+	if err := first.Update(a.Handle, map[string][]byte{"ip-address": net.ParseIP("10.0.0.1").To4()}); err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := first.RefreshObject(again.Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := refreshed.ToHost().IP; !ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("host a has IP %s through its other handle, want 10.0.0.1", ip)
+	}
+
+	if _, err := second.RefreshObject(b.Handle); !errors.Is(err, omapi.ErrNotFound) {
+		t.Errorf("refreshing another connection's handle returned %v, want not found", err)
+	}
+
+	// Objects deleted through one connection are gone for the other.
+	other, err := second.FindHost(omapi.Host{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Delete(a.Handle); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.RefreshObject(other.Handle); !errors.Is(err, omapi.ErrNotFound) {
+		t.Errorf("refreshing a host deleted by another connection returned %v, want not found", err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.AddKey(testKeyName, testKey)
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:00:00:00:00:01"), HardwareType: 1})
+
+	t.Run("required", func(t *testing.T) {
+		con := dial(t, server, "", "")
+		if _, err := con.FindHost(omapi.Host{Name: "a"}); !errors.Is(err, omapi.ErrPermissionDenied) {
+			t.Errorf("unauthenticated FindHost returned %v, want permission denied", err)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		var d omapi.Dialer
+		if _, err := d.Dial(server.Addr, "other_key", testKey); !errors.Is(err, omapi.ErrNotFound) {
+			t.Errorf("Dial with an unknown key returned %v, want not found", err)
+		}
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		con := dial(t, server, testKeyName, "d3Jvbmc=")
+		if _, err := con.FindHost(omapi.Host{Name: "a"}); !errors.Is(err, omapi.Statuses[48]) {
+			t.Errorf("FindHost with the wrong secret returned %v, want invalid key", err)
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		con := dial(t, server, testKeyName, testKey)
+		if con.KeyName() != testKeyName {
+			t.Errorf("KeyName is %q, want %q", con.KeyName(), testKeyName)
+		}
+		if _, err := con.FindHost(omapi.Host{Name: "a"}); err != nil {
+			t.Errorf("FindHost: %v", err)
+		}
+	})
+}
+
+// Both sides sign with the same key, so each can verify the other's
+// messages: the signature of a received message must be kept when
+// verifying it.
+func TestSignature(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.AddKey(testKeyName, testKey)
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:00:00:00:00:01"), HardwareType: 1})
+
+	con := dial(t, server, testKeyName, testKey)
+
+	server.InjectFault(omapitest.Fault{BadSignature: true})
+	if _, err := con.FindHost(omapi.Host{Name: "a"}); !errors.Is(err, omapi.Statuses[48]) {
+		t.Errorf("FindHost with a bad response signature returned %v, want invalid key", err)
+	}
+
+	// Only the one response was bad.
+	if _, err := con.FindHost(omapi.Host{Name: "a"}); err != nil {
+		t.Errorf("FindHost after a bad signature: %v", err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:00:00:00:00:01"), HardwareType: 1})
+
+	t.Run("status", func(t *testing.T) {
+		con := dial(t, server, "", "")
+
+		server.InjectFault(omapitest.Fault{Status: omapi.Statuses[17]})
+		_, err := con.FindHost(omapi.Host{Name: "a"})
+		var status omapi.Status
+		if !errors.As(err, &status) || status.Code != 17 || status.Text != "injected fault" {
+			t.Errorf("FindHost returned %#v, want lock busy: injected fault", err)
+		}
+		if _, err := con.FindHost(omapi.Host{Name: "a"}); err != nil {
+			t.Errorf("FindHost after the fault: %v", err)
+		}
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		con := dial(t, server, "", "")
+
+		server.InjectFault(omapitest.Fault{Delay: time.Second})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := con.FindHostContext(ctx, omapi.Host{Name: "a"}); !errors.Is(err, omapi.Statuses[2]) {
+			t.Errorf("FindHost returned %v, want timed out", err)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		con := dial(t, server, "", "")
+
+		server.InjectFault(omapitest.Fault{Drop: true})
+		if _, err := con.FindHost(omapi.Host{Name: "a"}); !errors.Is(err, omapi.Statuses[40]) {
+			t.Errorf("FindHost on a dropped connection returned %v, want not connected", err)
+		}
+		if con.Err() == nil {
+			t.Error("dropped connection has no error")
+		}
+	})
+
+	t.Run("drop connections", func(t *testing.T) {
+		con := dial(t, server, "", "")
+		if _, err := con.FindHost(omapi.Host{Name: "a"}); err != nil {
+			t.Fatal(err)
+		}
+
+		server.DropConnections()
+		if _, err := con.FindHost(omapi.Host{Name: "a"}); !errors.Is(err, omapi.Statuses[40]) {
+			t.Errorf("FindHost after DropConnections returned %v, want not connected", err)
+		}
+	})
+}
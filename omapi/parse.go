@@ -0,0 +1,289 @@
+package omapi
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ParseError describes a syntax error in a dhcpd configuration or
+// lease file.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind  tokenKind
+	text  string // for strings, the unquoted and unescaped value
+	line  int
+	start int // offsets into the source
+	end   int
+}
+
+func (t token) is(punct string) bool {
+	return t.kind == tokenPunct && t.text == punct
+}
+
+// tokenize splits the syntax shared by dhcpd.conf and dhcpd.leases
+// into words, quoted strings and punctuation, dropping comments.
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.IndexByte("{};=,", c) >= 0:
+			tokens = append(tokens, token{tokenPunct, string(c), line, i, i + 1})
+			i++
+		case c == '"':
+			start, startLine := i, line
+			var value strings.Builder
+			for i++; ; i++ {
+				if i >= len(src) {
+					return nil, &ParseError{startLine, "unterminated string"}
+				}
+				if src[i] == '"' {
+					i++
+					break
+				}
+				if src[i] == '\n' {
+					line++
+				}
+				if src[i] != '\\' || i+1 >= len(src) {
+					value.WriteByte(src[i])
+					continue
+				}
+
+				i++
+				switch src[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case 't':
+					value.WriteByte('\t')
+				case 'r':
+					value.WriteByte('\r')
+				case '0', '1', '2', '3', '4', '5', '6', '7':
+					// Up to three octal digits, as written by dhcpd
+					// for non-printable bytes.
+					var b byte
+					j := 0
+					for ; j < 3 && i+j < len(src) && src[i+j] >= '0' && src[i+j] <= '7'; j++ {
+						b = b*8 + src[i+j] - '0'
+					}
+					value.WriteByte(b)
+					i += j - 1
+				default:
+					value.WriteByte(src[i])
+				}
+			}
+			tokens = append(tokens, token{tokenString, value.String(), startLine, start, i})
+		default:
+			start := i
+			for i < len(src) && strings.IndexByte(" \t\r\n#{};=,\"", src[i]) < 0 {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, src[start:i], line, start, i})
+		}
+	}
+
+	return tokens, nil
+}
+
+// A statement is a single statement of a configuration or lease file,
+// either terminated by a semicolon or followed by a block of nested
+// statements.
+type statement struct {
+	args  []token // everything before the semicolon or block
+	block []*statement
+	text  string // the statement's source text
+	line  int
+}
+
+// keyword returns the statement's first word, or an empty string.
+func (s *statement) keyword() string {
+	if len(s.args) == 0 || s.args[0].kind != tokenWord {
+		return ""
+	}
+
+	return strings.ToLower(s.args[0].text)
+}
+
+func (s *statement) errorf(format string, args ...any) error {
+	return &ParseError{s.line, fmt.Sprintf(format, args...)}
+}
+
+// parseStatements reads the whole of r and parses it into a tree of
+// statements.
+func parseStatements(r io.Reader) ([]*statement, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := tokenize(string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &statementParser{src: string(src), tokens: tokens}
+	statements, err := p.parseBlock(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return statements, nil
+}
+
+type statementParser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+func (p *statementParser) parseBlock(nested bool) ([]*statement, error) {
+	var statements []*statement
+
+	for {
+		if p.pos >= len(p.tokens) {
+			if nested {
+				return nil, &ParseError{p.tokens[len(p.tokens)-1].line, "unexpected end of input, missing }"}
+			}
+			return statements, nil
+		}
+
+		first := p.tokens[p.pos]
+		switch {
+		case first.is("}"):
+			if !nested {
+				return nil, &ParseError{first.line, "unexpected }"}
+			}
+			p.pos++
+			return statements, nil
+		case first.is(";"):
+			// Empty statement
+			p.pos++
+			continue
+		}
+
+		stmt := &statement{line: first.line}
+		for {
+			if p.pos >= len(p.tokens) {
+				return nil, &ParseError{first.line, "unexpected end of input, missing ;"}
+			}
+
+			tok := p.tokens[p.pos]
+			p.pos++
+
+			if tok.is(";") {
+				stmt.text = p.src[first.start:tok.end]
+				break
+			}
+
+			if tok.is("{") {
+				block, err := p.parseBlock(true)
+				if err != nil {
+					return nil, err
+				}
+				stmt.block = block
+				if stmt.block == nil {
+					stmt.block = []*statement{}
+				}
+				stmt.text = p.src[first.start:p.tokens[p.pos-1].end]
+				break
+			}
+
+			if tok.is("}") {
+				return nil, &ParseError{tok.line, "unexpected }, missing ;"}
+			}
+
+			stmt.args = append(stmt.args, tok)
+		}
+
+		statements = append(statements, stmt)
+	}
+}
+
+// parseHardware parses the arguments of a hardware statement.
+func (s *statement) parseHardware() (HardwareType, net.HardwareAddr, error) {
+	if len(s.args) != 3 {
+		return 0, nil, s.errorf("expected hardware type and address")
+	}
+
+	hwType, err := ParseHardwareType(s.args[1].text)
+	if err != nil {
+		return 0, nil, s.errorf("unknown hardware type %q", s.args[1].text)
+	}
+
+	addr, err := ParseColonHex(s.args[2].text)
+	if err != nil {
+		return 0, nil, s.errorf("%s", err)
+	}
+
+	return hwType, net.HardwareAddr(addr), nil
+}
+
+// parseIdentifier parses a client identifier, which is either a
+// quoted string or colon-separated hex bytes.
+func (s *statement) parseIdentifier(tok token) ([]byte, error) {
+	if tok.kind == tokenString {
+		return []byte(tok.text), nil
+	}
+
+	b, err := ParseColonHex(tok.text)
+	if err != nil {
+		return nil, s.errorf("%s", err)
+	}
+
+	return b, nil
+}
+
+// parseTime parses the arguments of a lease time statement, which
+// are either "never", "epoch <seconds>" or a weekday followed by a
+// date and time in UTC. "never" is returned as the zero time.
+func (s *statement) parseTime() (time.Time, error) {
+	args := s.args[1:]
+
+	switch {
+	case len(args) == 1 && args[0].text == "never":
+		return time.Time{}, nil
+	case len(args) == 2 && args[0].text == "epoch":
+		var secs int64
+		if _, err := fmt.Sscan(args[1].text, &secs); err != nil {
+			return time.Time{}, s.errorf("invalid epoch %q", args[1].text)
+		}
+		return time.Unix(secs, 0), nil
+	case len(args) == 3:
+		t, err := time.Parse("2006/01/02 15:04:05", args[1].text+" "+args[2].text)
+		if err != nil {
+			return time.Time{}, s.errorf("invalid time %q", args[1].text+" "+args[2].text)
+		}
+		return t, nil
+	}
+
+	return time.Time{}, s.errorf("invalid time")
+}
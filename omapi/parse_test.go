@@ -0,0 +1,168 @@
+package omapi
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	type tok struct {
+		kind tokenKind
+		text string
+		line int
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want []tok
+	}{
+		{
+			name: "statement",
+			src:  "hardware ethernet 00:11:22:33:44:55;",
+			want: []tok{
+				{tokenWord, "hardware", 1},
+				{tokenWord, "ethernet", 1},
+				{tokenWord, "00:11:22:33:44:55", 1},
+				{tokenPunct, ";", 1},
+			},
+		},
+		{
+			name: "block and lines",
+			src:  "lease 10.0.0.5 {\n  starts 4 2024/05/02 10:00:00;\n}\n",
+			want: []tok{
+				{tokenWord, "lease", 1},
+				{tokenWord, "10.0.0.5", 1},
+				{tokenPunct, "{", 1},
+				{tokenWord, "starts", 2},
+				{tokenWord, "4", 2},
+				{tokenWord, "2024/05/02", 2},
+				{tokenWord, "10:00:00", 2},
+				{tokenPunct, ";", 2},
+				{tokenPunct, "}", 3},
+			},
+		},
+		{
+			name: "comments",
+			src:  "# The format of this file is documented in the dhcpd.leases(5) manual page.\nauthoring-byte-order little-endian; # trailing\n#",
+			want: []tok{
+				{tokenWord, "authoring-byte-order", 2},
+				{tokenWord, "little-endian", 2},
+				{tokenPunct, ";", 2},
+			},
+		},
+		{
+			name: "comment character in a string",
+			src:  `client-hostname "a#b";`,
+			want: []tok{
+				{tokenWord, "client-hostname", 1},
+				{tokenString, "a#b", 1},
+				{tokenPunct, ";", 1},
+			},
+		},
+		{
+			name: "escapes",
+			src:  `uid "\001\000\021\"q\\\n\tx\7";`,
+			want: []tok{
+				{tokenWord, "uid", 1},
+				{tokenString, "\x01\x00\x11\"q\\\n\tx\x07", 1},
+				{tokenPunct, ";", 1},
+			},
+		},
+		{
+			name: "multi-line string",
+			src:  "x \"a\nb\" y;",
+			want: []tok{
+				{tokenWord, "x", 1},
+				{tokenString, "a\nb", 1},
+				{tokenWord, "y", 2},
+				{tokenPunct, ";", 2},
+			},
+		},
+		{
+			name: "punctuation",
+			src:  "set ddns-fwd-name=\"a\",b;",
+			want: []tok{
+				{tokenWord, "set", 1},
+				{tokenWord, "ddns-fwd-name", 1},
+				{tokenPunct, "=", 1},
+				{tokenString, "a", 1},
+				{tokenPunct, ",", 1},
+				{tokenWord, "b", 1},
+				{tokenPunct, ";", 1},
+			},
+		},
+		{
+			name: "empty",
+			src:  " \t\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := tokenize(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []tok
+			for _, token := range tokens {
+				got = append(got, tok{token.kind, token.text, token.line})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenize(%q) =\n%v\nwant\n%v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	src := "server-duid \"\\000\\001\";\nlease 10.0.0.5 {\n  binding state active;\n  ;\n}\n"
+
+	statements, err := parseStatements(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statements) != 2 {
+		t.Fatalf("got %d statements, want 2", len(statements))
+	}
+
+	duid, lease := statements[0], statements[1]
+	if duid.keyword() != "server-duid" || duid.text != `server-duid "\000\001";` || duid.block != nil {
+		t.Errorf("first statement = %q %q, block %v", duid.keyword(), duid.text, duid.block)
+	}
+	if lease.keyword() != "lease" || lease.line != 2 || len(lease.block) != 1 {
+		t.Fatalf("second statement = %q on line %d with %d nested statements", lease.keyword(), lease.line, len(lease.block))
+	}
+	if inner := lease.block[0]; inner.text != "binding state active;" || inner.line != 3 {
+		t.Errorf("nested statement = %q on line %d", inner.text, inner.line)
+	}
+}
+
+func TestParseStatementsErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		line int
+	}{
+		{"lease 10.0.0.5 {\n  starts never;\n", 2},
+		{"lease 10.0.0.5 {\n  starts never\n}", 3},
+		{"}", 1},
+		{"client-hostname \"unterminated;\n", 1},
+		{"\n\nbinding state active", 3},
+	}
+
+	for _, tt := range tests {
+		_, err := parseStatements(strings.NewReader(tt.src))
+
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("parseStatements(%q) error = %v, want a ParseError", tt.src, err)
+			continue
+		}
+		if parseErr.Line != tt.line {
+			t.Errorf("parseStatements(%q) error on line %d, want %d: %v", tt.src, parseErr.Line, tt.line, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package omapi
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is returned by Pool.Conn after Close has been called.
+var ErrPoolClosed = errors.New("omapi: pool closed")
+
+// A Pool maintains a fixed number of connections to a single server
+// and spreads queries over them. Connections are dialed on first use
+// and redialed once they break. Because a Connection can be used
+// concurrently, connections aren't checked out exclusively; Conn
+// hands them out round-robin.
+//
+// Example:
+//
+//	pool := omapi.NewPool("dhcp1:7911", "omapi_key", key, 4)
+//	defer pool.Close()
+//
+//	con, err := pool.Conn()
+//	if err != nil {
+//		// Couldn't connect
+//	}
+//	host, err := con.FindHost(omapi.Host{Name: "printer"})
+type Pool struct {
+	// Dialer is used to dial connections, so its logger and
+	// interceptors apply to all of them.
+	Dialer Dialer
+
+	addr, username, key string
+
+	mu     sync.Mutex
+	conns  []*Connection
+	next   int
+	closed bool
+}
+
+// NewPool returns a pool of size connections to addr, authenticating
+// like Dial. Sizes below 1 mean 1. No connection is established until
+// Conn is called.
+func NewPool(addr, username, key string, size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Pool{
+		addr:     addr,
+		username: username,
+		key:      key,
+		conns:    make([]*Connection, size),
+	}
+}
+
+// Conn returns a usable connection of the pool, dialing it if it
+// doesn't exist yet or has broken. The connection must not be closed
+// by the caller. A connection can still break after it was returned,
+// in which case the next call to Conn replaces it.
+func (p *Pool) Conn() (*Connection, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+
+	slot := p.next
+	p.next = (p.next + 1) % len(p.conns)
+
+	if con := p.conns[slot]; con != nil && con.Err() == nil {
+		p.mu.Unlock()
+		return con, nil
+	}
+	p.mu.Unlock()
+
+	// Dial without holding the lock, so that a slow or unreachable
+	// server doesn't block users of the other connections.
+	con, err := p.Dialer.Dial(p.addr, p.username, p.key)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		con.Close()
+		return nil, ErrPoolClosed
+	}
+
+	// Someone else may have redialed the slot in the meantime.
+	if existing := p.conns[slot]; existing != nil && existing.Err() == nil {
+		con.Close()
+		return existing, nil
+	}
+	if existing := p.conns[slot]; existing != nil {
+		existing.Close()
+	}
+	p.conns[slot] = con
+
+	return con, nil
+}
+
+// Close closes all connections of the pool.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for i, con := range p.conns {
+		if con != nil {
+			con.Close()
+			p.conns[i] = nil
+		}
+	}
+
+	return nil
+}
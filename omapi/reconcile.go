@@ -0,0 +1,297 @@
+package omapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type ReconcileAction int
+
+const (
+	ActionCreate ReconcileAction = iota + 1
+	ActionUpdate
+	ActionDelete
+)
+
+func (action ReconcileAction) String() (ret string) {
+	switch action {
+	case ActionCreate:
+		ret = "create"
+	case ActionUpdate:
+		ret = "update"
+	case ActionDelete:
+		ret = "delete"
+	}
+
+	return
+}
+
+// A PlanStep is a single change to the server's hosts. Current is the
+// server's representation of the host for updates and deletes, Desired
+// the host to create for creates and updates.
+type PlanStep struct {
+	Action  ReconcileAction
+	Current Host
+	Desired Host
+}
+
+func (step PlanStep) String() string {
+	switch step.Action {
+	case ActionCreate:
+		return fmt.Sprintf("create host %s (%s)", step.Desired.Name, describeHost(step.Desired))
+	case ActionUpdate:
+		return fmt.Sprintf("update host %s (%s -> %s)", step.Desired.Name, describeHost(step.Current), describeHost(step.Desired))
+	case ActionDelete:
+		return fmt.Sprintf("delete host %s (%s)", step.Current.Name, describeHost(step.Current))
+	}
+
+	return ""
+}
+
+func describeHost(host Host) string {
+	var fields []string
+	if len(host.HardwareAddress) > 0 {
+		fields = append(fields, "hardware-address "+host.HardwareAddress.String())
+	}
+	if len(host.IP) > 0 {
+		fields = append(fields, "ip-address "+host.IP.String())
+	}
+	if len(host.DHCPClientIdentifier) > 0 {
+		fields = append(fields, "dhcp-client-identifier "+formatIdentifier(host.DHCPClientIdentifier))
+	}
+
+	return strings.Join(fields, ", ")
+}
+
+// A Plan is the list of steps that turn the server's hosts into the
+// desired ones. Deletes come first, then updates, then creates, so
+// that addresses freed by one host can be taken by another.
+type Plan struct {
+	Steps []PlanStep
+}
+
+// String describes the plan, one step per line, for dry runs.
+func (plan *Plan) String() string {
+	var b strings.Builder
+	for _, step := range plan.Steps {
+		b.WriteString(step.String())
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// IsEmpty returns true if the server already is in the desired state.
+func (plan *Plan) IsEmpty() bool {
+	return len(plan.Steps) == 0
+}
+
+// A Reconciler brings the hosts of a server in line with a desired
+// state, such as the reservations of an inventory system.
+//
+// OMAPI cannot update hosts in place, so updates are carried out by
+// deleting and recreating the host. Because OMAPI doesn't return a
+// host's statements, they can't be compared and aren't restored when
+// rolling back a delete.
+type Reconciler struct {
+	conn *Connection
+}
+
+func NewReconciler(con *Connection) *Reconciler {
+	return &Reconciler{conn: con}
+}
+
+// Plan compares the desired hosts with the server's, looking them up
+// by name and by hardware address. A host is created if it doesn't
+// exist yet and updated if its hardware address, IP or client
+// identifier differ. Other hosts that use a desired host's hardware
+// address are deleted.
+//
+// OMAPI cannot enumerate hosts, so hosts that should go away must be
+// named in obsolete, for example by passing the names of the
+// previously desired state. They are deleted unless they are also
+// desired.
+func (r *Reconciler) Plan(desired []Host, obsolete []string) (*Plan, error) {
+	return r.PlanContext(context.Background(), desired, obsolete)
+}
+
+// PlanContext is like Plan but takes a context, see
+// Connection.QueryContext.
+func (r *Reconciler) PlanContext(ctx context.Context, desired []Host, obsolete []string) (*Plan, error) {
+	var creates, updates, deletes []PlanStep
+
+	wanted := make(map[string]bool)
+	for _, host := range desired {
+		if host.Name == "" {
+			return nil, errors.New("desired host without a name")
+		}
+		if wanted[host.Name] {
+			return nil, fmt.Errorf("host %s is desired more than once", host.Name)
+		}
+		wanted[host.Name] = true
+	}
+
+	deleted := make(map[int32]bool)
+	deleteHost := func(host Host) {
+		if !deleted[host.Handle] {
+			deleted[host.Handle] = true
+			deletes = append(deletes, PlanStep{Action: ActionDelete, Current: host})
+		}
+	}
+
+	for _, host := range desired {
+		current, found, err := r.find(ctx, Host{Name: host.Name})
+		if err != nil {
+			return nil, fmt.Errorf("looking up host %s: %w", host.Name, err)
+		}
+
+		switch {
+		case !found:
+			creates = append(creates, PlanStep{Action: ActionCreate, Desired: host})
+		case !hostMatches(host, current):
+			updates = append(updates, PlanStep{Action: ActionUpdate, Current: current, Desired: host})
+		}
+
+		if len(host.HardwareAddress) == 0 {
+			continue
+		}
+
+		other, found, err := r.find(ctx, Host{HardwareAddress: host.HardwareAddress, HardwareType: host.HardwareType})
+		if err != nil {
+			return nil, fmt.Errorf("looking up hardware address %s: %w", host.HardwareAddress, err)
+		}
+
+		if found && other.Name != host.Name && !wanted[other.Name] {
+			deleteHost(other)
+		}
+	}
+
+	for _, name := range obsolete {
+		if wanted[name] {
+			continue
+		}
+
+		current, found, err := r.find(ctx, Host{Name: name})
+		if err != nil {
+			return nil, fmt.Errorf("looking up host %s: %w", name, err)
+		}
+
+		if found {
+			deleteHost(current)
+		}
+	}
+
+	steps := append(append(deletes, updates...), creates...)
+
+	return &Plan{Steps: steps}, nil
+}
+
+// find looks up a host, treating "not found" as a regular result.
+func (r *Reconciler) find(ctx context.Context, host Host) (Host, bool, error) {
+	current, err := r.conn.FindHostContext(ctx, host)
+	if errors.Is(err, ErrNotFound) {
+		return Host{}, false, nil
+	}
+	if err != nil {
+		return Host{}, false, err
+	}
+
+	return current, true, nil
+}
+
+func hostMatches(desired, current Host) bool {
+	if !bytes.Equal(desired.HardwareAddress, current.HardwareAddress) {
+		return false
+	}
+	if desired.HardwareType != 0 && desired.HardwareType != current.HardwareType {
+		return false
+	}
+	if len(desired.IP) > 0 || len(current.IP) > 0 {
+		if !desired.IP.Equal(current.IP) {
+			return false
+		}
+	}
+
+	return bytes.Equal(desired.DHCPClientIdentifier, current.DHCPClientIdentifier)
+}
+
+// Apply carries out a plan. If a step fails, the steps applied so far
+// are rolled back in reverse order and the error is returned, joined
+// with any errors that occurred while rolling back.
+func (r *Reconciler) Apply(plan *Plan) error {
+	return r.ApplyContext(context.Background(), plan)
+}
+
+// ApplyContext is like Apply but takes a context, see
+// Connection.QueryContext. A failed step is rolled back even if ctx
+// is done, since stopping halfway would leave the server in neither
+// state.
+func (r *Reconciler) ApplyContext(ctx context.Context, plan *Plan) error {
+	// applied records the server's hosts as they were after each
+	// step, so the steps can be undone.
+	var applied []PlanStep
+
+	for _, step := range plan.Steps {
+		done, err := r.apply(ctx, step)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", step, err)
+			return errors.Join(err, r.rollback(context.WithoutCancel(ctx), append(applied, done)))
+		}
+
+		applied = append(applied, done)
+	}
+
+	return nil
+}
+
+// apply carries out a single step. It returns the step with Desired
+// replaced by the server's representation of the created host, which
+// only has a handle if the creation succeeded.
+func (r *Reconciler) apply(ctx context.Context, step PlanStep) (PlanStep, error) {
+	done := step
+	done.Desired = Host{}
+
+	if step.Action == ActionDelete || step.Action == ActionUpdate {
+		if err := r.conn.DeleteContext(ctx, step.Current.Handle); err != nil {
+			// Nothing changed, so there's nothing to undo.
+			done.Action = 0
+			return done, err
+		}
+	}
+
+	if step.Action == ActionCreate || step.Action == ActionUpdate {
+		created, err := r.conn.CreateHostContext(ctx, step.Desired)
+		if err != nil {
+			return done, err
+		}
+		done.Desired = created
+	}
+
+	return done, nil
+}
+
+func (r *Reconciler) rollback(ctx context.Context, applied []PlanStep) error {
+	var errs []error
+
+	for i := len(applied) - 1; i >= 0; i-- {
+		step := applied[i]
+
+		if step.Desired.Handle != 0 {
+			if err := r.conn.DeleteContext(ctx, step.Desired.Handle); err != nil {
+				errs = append(errs, fmt.Errorf("rolling back %s: %w", step.Action, err))
+				continue
+			}
+		}
+
+		if step.Action == ActionDelete || step.Action == ActionUpdate {
+			if _, err := r.conn.CreateHostContext(ctx, step.Current); err != nil {
+				errs = append(errs, fmt.Errorf("rolling back %s: %w", step.Action, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,106 @@
+package omapi_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestReconcilerApply(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.PutHost(omapi.Host{Name: "old", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: 1})
+	server.PutHost(omapi.Host{Name: "moved", HardwareAddress: mustMAC(t, "00:11:22:33:44:02"), HardwareType: 1})
+
+	con, err := omapi.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	r := omapi.NewReconciler(con)
+	desired := []omapi.Host{
+		{Name: "moved", HardwareAddress: mustMAC(t, "00:11:22:33:44:03"), HardwareType: 1},
+		{Name: "new", HardwareAddress: mustMAC(t, "00:11:22:33:44:04"), HardwareType: 1, IP: net.ParseIP("10.0.0.4")},
+	}
+
+	plan, err := r.PlanContext(context.Background(), desired, []string{"old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []omapi.ReconcileAction{omapi.ActionDelete, omapi.ActionUpdate, omapi.ActionCreate}
+	if len(plan.Steps) != len(want) {
+		t.Fatalf("plan has %d steps, want %d:\n%s", len(plan.Steps), len(want), plan)
+	}
+	for i, step := range plan.Steps {
+		if step.Action != want[i] {
+			t.Errorf("step %d is %s, want %s", i, step.Action, want[i])
+		}
+	}
+
+	if err := r.ApplyContext(context.Background(), plan); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err = r.Plan(desired, []string{"old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.IsEmpty() {
+		t.Errorf("plan after applying isn't empty:\n%s", plan)
+	}
+}
+
+func TestReconcilerApplyFailedDelete(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.PutHost(omapi.Host{Name: "first", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: 1})
+	server.PutHost(omapi.Host{Name: "second", HardwareAddress: mustMAC(t, "00:11:22:33:44:02"), HardwareType: 1})
+
+	con, err := omapi.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	r := omapi.NewReconciler(con)
+	plan, err := r.Plan(nil, []string{"first", "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first delete succeeds and the second fails, so only the
+	// first host may be recreated by the rollback.
+	server.InjectFault(omapitest.Fault{})
+	server.InjectFault(omapitest.Fault{Status: omapi.Statuses[17]})
+
+	err = r.Apply(plan)
+	if !errors.Is(err, omapi.Statuses[17]) {
+		t.Fatalf("Apply returned %v, want lock busy", err)
+	}
+	if errors.Is(err, omapi.ErrAlreadyExists) {
+		t.Errorf("rollback recreated the host that wasn't deleted: %v", err)
+	}
+
+	if hosts := server.Hosts(); len(hosts) != 2 {
+		t.Errorf("server has %d hosts after rollback, want 2", len(hosts))
+	}
+}
+
+func mustMAC(t *testing.T, s string) net.HardwareAddr {
+	t.Helper()
+
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mac
+}
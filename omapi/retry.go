@@ -0,0 +1,230 @@
+package omapi
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transientCodes are the status codes that DefaultRetryable considers
+// worth retrying.
+var transientCodes = map[int32]bool{
+	2:  true, // timed out
+	3:  true, // no available threads
+	8:  true, // network unreachable
+	9:  true, // host unreachable
+	12: true, // connection refused
+	17: true, // lock busy
+	49: true, // operation in progress
+	68: true, // connection reset by peer
+}
+
+// DefaultRetryable reports whether a status is transient: the server
+// was busy or couldn't be reached, and the same query may succeed
+// later. Statuses such as "not found" or "already exists" are final.
+func DefaultRetryable(status Status) bool {
+	return transientCodes[status.Code]
+}
+
+// IsIdempotent reports whether sending a message twice has the same
+// effect as sending it once, so that it is safe to retry. Lookups,
+// refreshes and updates are; creates and deletes aren't, since a retry
+// after a lost response would fail with "already exists" or "not
+// found".
+func IsIdempotent(msg *Message) bool {
+	switch msg.Opcode {
+	case OpOpen:
+		return !isTrue(msg.Message["create"])
+	case OpRefresh, OpUpdate:
+		return true
+	}
+
+	return false
+}
+
+// A RetryBudget limits the retries of a RetryPolicy to a fraction of
+// its queries, so that retries don't pile onto a server that is
+// already struggling. Every query earns a fraction of a retry, and
+// every retry spends one. It is safe for concurrent use.
+type RetryBudget struct {
+	ratio, max float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+// NewRetryBudget returns a budget that allows ratio retries per query,
+// such as 0.1 for one retry per ten queries, saving up to max retries.
+// It starts out full.
+func NewRetryBudget(ratio float64, max int) *RetryBudget {
+	return &RetryBudget{ratio: ratio, max: float64(max), tokens: float64(max)}
+}
+
+func (b *RetryBudget) earn() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.tokens+b.ratio, b.max)
+}
+
+func (b *RetryBudget) spend() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// RetryStats counts what a RetryPolicy did.
+type RetryStats struct {
+	// Retries is the number of retries sent.
+	Retries uint64
+
+	// Exhausted is the number of queries that still failed with a
+	// transient status when MaxAttempts was reached.
+	Exhausted uint64
+
+	// Throttled is the number of retries that weren't sent because the
+	// budget was used up or the context's deadline was too close.
+	Throttled uint64
+}
+
+// A RetryPolicy retries queries that fail with a transient status,
+// waiting with exponential backoff between attempts. It only retries
+// idempotent queries.
+//
+// Network errors that break the connection, such as a reset or the
+// server going away, are out of scope: the query fails with the
+// connection's error and isn't retried, since an interceptor is bound
+// to its connection and every further attempt on it would fail right
+// away. Callers that want to retry those get a new connection, for
+// example from Pool.Conn, which replaces broken ones, and send the
+// query again. The network statuses that DefaultRetryable lists, such
+// as "connection refused", are only retried when the server reports
+// them in a status message.
+//
+// Use its Intercept method as an interceptor:
+//
+//	policy := &omapi.RetryPolicy{MaxAttempts: 4, Budget: omapi.NewRetryBudget(0.1, 10)}
+//	dialer := omapi.Dialer{Interceptors: []omapi.Interceptor{policy.Intercept}}
+//
+// Put it before a Limiter and an Auditor in the chain, so that it is
+// outside of them: then every attempt waits for the Limiter, whose
+// latency measurements don't include the waits between attempts, and
+// the Auditor records every attempt that was sent.
+//
+// A RetryPolicy must not be copied after first use.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a query is sent,
+	// including the first. It defaults to 3.
+	MaxAttempts int
+
+	// InitialBackoff is the time to wait before the first retry,
+	// doubling with each further retry up to MaxBackoff. Each wait is
+	// randomized between half and all of it. They default to 100
+	// milliseconds and 5 seconds.
+	InitialBackoff, MaxBackoff time.Duration
+
+	// Retryable reports whether a failed query should be retried. It
+	// defaults to DefaultRetryable.
+	Retryable func(status Status) bool
+
+	// Idempotent reports whether a message may be sent again. It
+	// defaults to IsIdempotent.
+	Idempotent func(msg *Message) bool
+
+	// Budget, if set, limits the retries over all queries, and may be
+	// shared between policies.
+	Budget *RetryBudget
+
+	// OnRetry, if set, is called before every retry with the status
+	// of the failed attempt, for metrics or logging.
+	OnRetry func(msg *Message, attempt int, status Status, delay time.Duration)
+
+	retries, exhausted, throttled atomic.Uint64
+}
+
+// Stats returns the policy's counters.
+func (p *RetryPolicy) Stats() RetryStats {
+	return RetryStats{
+		Retries:   p.retries.Load(),
+		Exhausted: p.exhausted.Load(),
+		Throttled: p.throttled.Load(),
+	}
+}
+
+// Intercept is an Interceptor that retries transient failures.
+func (p *RetryPolicy) Intercept(ctx context.Context, msg *Message, con *Connection, invoke Invoker) (*Message, Status) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	backoff := p.InitialBackoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 5 * time.Second
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+	idempotent := p.Idempotent
+	if idempotent == nil {
+		idempotent = IsIdempotent
+	}
+
+	if p.Budget != nil {
+		p.Budget.earn()
+	}
+
+	for attempt := 1; ; attempt++ {
+		response, status := invoke(ctx, msg)
+
+		// A query that timed out because of the context mustn't be
+		// retried, and a broken connection stays broken.
+		if !status.IsError() || !retryable(status) || !idempotent(msg) || ctx.Err() != nil || con.Err() != nil {
+			return response, status
+		}
+
+		if attempt >= maxAttempts {
+			p.exhausted.Add(1)
+			return response, status
+		}
+
+		rng.Lock()
+		delay := backoff/2 + time.Duration(rng.Int63n(int64(backoff/2)+1))
+		rng.Unlock()
+		backoff = min(2*backoff, maxBackoff)
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			p.throttled.Add(1)
+			return response, status
+		}
+		if p.Budget != nil && !p.Budget.spend() {
+			p.throttled.Add(1)
+			return response, status
+		}
+
+		if p.OnRetry != nil {
+			p.OnRetry(msg, attempt, status, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return response, status
+		}
+
+		p.retries.Add(1)
+	}
+}
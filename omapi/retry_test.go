@@ -0,0 +1,217 @@
+package omapi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	busy := omapitest.Fault{Status: omapi.Statuses[17]} // lock busy
+
+	tests := []struct {
+		name     string
+		policy   *omapi.RetryPolicy
+		faults   []omapitest.Fault
+		timeout  time.Duration
+		delete   bool // delete the host instead of looking it up
+		want     error
+		attempts int
+		stats    omapi.RetryStats
+	}{
+		{
+			name:     "success after retries",
+			policy:   &omapi.RetryPolicy{InitialBackoff: time.Millisecond},
+			faults:   []omapitest.Fault{busy, busy},
+			attempts: 3,
+			stats:    omapi.RetryStats{Retries: 2},
+		},
+		{
+			name:     "exhausted",
+			policy:   &omapi.RetryPolicy{InitialBackoff: time.Millisecond},
+			faults:   []omapitest.Fault{busy, busy, busy},
+			want:     omapi.Statuses[17],
+			attempts: 3,
+			stats:    omapi.RetryStats{Retries: 2, Exhausted: 1},
+		},
+		{
+			name:     "final status",
+			policy:   &omapi.RetryPolicy{InitialBackoff: time.Millisecond},
+			faults:   []omapitest.Fault{{Status: omapi.ErrPermissionDenied}},
+			want:     omapi.ErrPermissionDenied,
+			attempts: 1,
+		},
+		{
+			name:     "not idempotent",
+			policy:   &omapi.RetryPolicy{InitialBackoff: time.Millisecond},
+			faults:   []omapitest.Fault{busy},
+			delete:   true,
+			want:     omapi.Statuses[17],
+			attempts: 1,
+		},
+		{
+			name:     "broken connection",
+			policy:   &omapi.RetryPolicy{InitialBackoff: time.Millisecond},
+			faults:   []omapitest.Fault{{Drop: true}},
+			want:     omapi.Statuses[40],
+			attempts: 1,
+		},
+		{
+			name:     "budget",
+			policy:   &omapi.RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, Budget: omapi.NewRetryBudget(0, 1)},
+			faults:   []omapitest.Fault{busy, busy, busy},
+			want:     omapi.Statuses[17],
+			attempts: 2,
+			stats:    omapi.RetryStats{Retries: 1, Throttled: 1},
+		},
+		{
+			name:     "deadline",
+			policy:   &omapi.RetryPolicy{InitialBackoff: time.Second},
+			faults:   []omapitest.Fault{busy},
+			timeout:  100 * time.Millisecond,
+			want:     omapi.Statuses[17],
+			attempts: 1,
+			stats:    omapi.RetryStats{Throttled: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := omapitest.NewServer()
+			defer server.Close()
+
+			server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet})
+
+			// The interceptor after the policy sees every attempt.
+			var tids []int32
+			record := func(ctx context.Context, msg *omapi.Message, con *omapi.Connection, invoke omapi.Invoker) (*omapi.Message, omapi.Status) {
+				response, status := invoke(ctx, msg)
+				tids = append(tids, msg.TransactionID)
+				return response, status
+			}
+
+			dialer := &omapi.Dialer{Interceptors: []omapi.Interceptor{test.policy.Intercept, record}}
+			con, err := dialer.Dial(server.Addr, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer con.Close()
+
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+
+			var host omapi.Host
+			if test.delete {
+				if host, err = con.FindHostContext(ctx, omapi.Host{Name: "a"}); err != nil {
+					t.Fatal(err)
+				}
+				tids = nil
+			}
+			for _, fault := range test.faults {
+				server.InjectFault(fault)
+			}
+
+			start := time.Now()
+			if test.delete {
+				err = con.DeleteContext(ctx, host.Handle)
+			} else {
+				_, err = con.FindHostContext(ctx, omapi.Host{Name: "a"})
+			}
+
+			if test.want == nil && err != nil || test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+			if len(tids) != test.attempts {
+				t.Errorf("got %d attempts, want %d", len(tids), test.attempts)
+			}
+			if stats := test.policy.Stats(); stats != test.stats {
+				t.Errorf("got stats %+v, want %+v", stats, test.stats)
+			}
+			if test.timeout > 0 && time.Since(start) >= test.timeout {
+				t.Errorf("waited for a retry that the deadline didn't allow")
+			}
+
+			// Every attempt is sent with a new transaction ID.
+			seen := make(map[int32]bool)
+			for _, tid := range tids {
+				if seen[tid] {
+					t.Errorf("transaction ID %d was sent twice", tid)
+				}
+				seen[tid] = true
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	server.PutHost(omapi.Host{Name: "a", HardwareAddress: mustMAC(t, "00:11:22:33:44:01"), HardwareType: omapi.Ethernet})
+	for i := 0; i < 3; i++ {
+		server.InjectFault(omapitest.Fault{Status: omapi.Statuses[17]})
+	}
+
+	var delays []time.Duration
+	policy := &omapi.RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 20 * time.Millisecond,
+		MaxBackoff:     30 * time.Millisecond,
+		OnRetry: func(msg *omapi.Message, attempt int, status omapi.Status, delay time.Duration) {
+			delays = append(delays, delay)
+		},
+	}
+
+	con, err := (&omapi.Dialer{Interceptors: []omapi.Interceptor{policy.Intercept}}).Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	if _, err := con.FindHost(omapi.Host{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The backoff doubles up to the maximum, and each delay is between
+	// half and all of it.
+	backoffs := []time.Duration{20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond}
+	if len(delays) != len(backoffs) {
+		t.Fatalf("got %d retries, want %d", len(delays), len(backoffs))
+	}
+	for i, delay := range delays {
+		if delay < backoffs[i]/2 || delay > backoffs[i] {
+			t.Errorf("retry %d was delayed by %s, want between %s and %s", i+1, delay, backoffs[i]/2, backoffs[i])
+		}
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	refresh := omapi.NewMessage()
+	refresh.Opcode = omapi.OpRefresh
+
+	tests := []struct {
+		name string
+		msg  *omapi.Message
+		want bool
+	}{
+		{"open", omapi.NewOpenMessage("host"), true},
+		{"create", omapi.NewCreateMessage("host"), false},
+		{"refresh", refresh, true},
+		{"update", omapi.NewUpdateMessage(1), true},
+		{"delete", omapi.NewDeleteMessage(1), false},
+	}
+
+	for _, test := range tests {
+		if got := omapi.IsIdempotent(test.msg); got != test.want {
+			t.Errorf("%s: IsIdempotent returned %t, want %t", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package omapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ScanOptions configures ScanLeases.
+type ScanOptions struct {
+	// Concurrency is the maximum number of lookups in flight at the
+	// same time. Values below 1 mean 1.
+	Concurrency int
+
+	// Progress, if set, is called after each address has been looked
+	// up, with the number of addresses done so far and the total
+	// number of addresses to scan. It is never called concurrently.
+	Progress func(done, total int)
+
+	// Observe, if set, is called after each lookup with its result and
+	// how long it took. Unlike the results sent on the channel, this
+	// includes addresses without a lease, whose Err is the "not found"
+	// status. It may be called concurrently.
+	Observe func(result ScanResult, d time.Duration)
+}
+
+// ScanResult is the outcome of looking up a single address. Err is
+// set if the lookup failed for any reason other than the lease not
+// existing, in which case Lease is empty.
+type ScanResult struct {
+	Addr  netip.Addr
+	Lease Lease
+	Err   error
+}
+
+// ScanLeases looks up the lease of every address in an IPv4 prefix
+// and sends the results on the returned channel, which is closed once
+// the scan is done. Addresses without a lease are skipped. For
+// prefixes shorter than /31, the network and broadcast addresses
+// aren't looked up.
+//
+// OMAPI has no way of listing objects, so this is the only way of
+// enumerating leases. Each address costs a round trip to the server.
+//
+// The caller must either drain the channel or cancel the context.
+//
+// Example:
+//
+//	prefix := netip.MustParsePrefix("10.0.0.0/24")
+//	results, err := connection.ScanLeases(ctx, prefix, omapi.ScanOptions{Concurrency: 8})
+//	if err != nil {
+//		// Not an IPv4 prefix
+//	}
+//
+//	for result := range results {
+//		if result.Err != nil {
+//			// Couldn't look up result.Addr
+//			continue
+//		}
+//
+//		// result.Lease is the lease of result.Addr
+//	}
+func (con *Connection) ScanLeases(ctx context.Context, prefix netip.Prefix, opts ScanOptions) (<-chan ScanResult, error) {
+	if !prefix.IsValid() || !prefix.Addr().Is4() {
+		return nil, errors.New("only IPv4 prefixes can be scanned")
+	}
+
+	prefix = prefix.Masked()
+	first, total := prefix.Addr(), 1<<(32-prefix.Bits())
+	if prefix.Bits() < 31 {
+		first, total = first.Next(), total-2
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	addrs := make(chan netip.Addr)
+	lookups := make(chan ScanResult)
+	results := make(chan ScanResult)
+
+	go func() {
+		defer close(addrs)
+
+		addr := first
+		for i := 0; i < total; i++ {
+			select {
+			case addrs <- addr:
+			case <-ctx.Done():
+				return
+			}
+			addr = addr.Next()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+
+			for addr := range addrs {
+				result := ScanResult{Addr: addr}
+				if err := ctx.Err(); err != nil {
+					result.Err = err
+				} else {
+					ip := addr.As4()
+					start := time.Now()
+					result.Lease, result.Err = con.FindLeaseContext(ctx, Lease{IP: net.IP(ip[:])})
+					if opts.Observe != nil {
+						opts.Observe(result, time.Since(start))
+					}
+				}
+
+				select {
+				case lookups <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(lookups)
+	}()
+
+	go func() {
+		defer close(results)
+
+		done := 0
+		for result := range lookups {
+			done++
+			if opts.Progress != nil {
+				opts.Progress(done, total)
+			}
+
+			if errors.Is(result.Err, ErrNotFound) {
+				continue
+			}
+
+			select {
+			case results <- result:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return results, nil
+}
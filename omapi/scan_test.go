@@ -0,0 +1,297 @@
+package omapi_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+// dialScan returns a connection to a server with leases of the given
+// addresses. Its queries pass through the given interceptors.
+func dialScan(t *testing.T, addrs []string, interceptors ...omapi.Interceptor) (*omapitest.Server, *omapi.Connection) {
+	t.Helper()
+
+	server := omapitest.NewServer()
+	t.Cleanup(server.Close)
+
+	for _, addr := range addrs {
+		server.PutLease(omapi.Lease{IP: net.ParseIP(addr), State: omapi.LeaseStateActive})
+	}
+
+	dialer := &omapi.Dialer{Interceptors: interceptors}
+	con, err := dialer.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { con.Close() })
+
+	return server, con
+}
+
+func TestScanLeases(t *testing.T) {
+	tests := []struct {
+		prefix string
+		leases []string
+		want   []string
+	}{
+		{
+			// The network and broadcast addresses aren't looked up,
+			// even though the server has leases for them.
+			prefix: "10.0.0.0/28",
+			leases: []string{"10.0.0.0", "10.0.0.1", "10.0.0.5", "10.0.0.14", "10.0.0.15", "10.0.0.16"},
+			want:   []string{"10.0.0.1", "10.0.0.5", "10.0.0.14"},
+		},
+		{
+			// Unmasked prefixes are masked.
+			prefix: "10.0.0.7/29",
+			leases: []string{"10.0.0.0", "10.0.0.1", "10.0.0.5", "10.0.0.7"},
+			want:   []string{"10.0.0.1", "10.0.0.5"},
+		},
+		{
+			prefix: "10.0.0.0/31",
+			leases: []string{"10.0.0.0", "10.0.0.1"},
+			want:   []string{"10.0.0.0", "10.0.0.1"},
+		},
+		{
+			prefix: "10.0.0.5/32",
+			leases: []string{"10.0.0.5"},
+			want:   []string{"10.0.0.5"},
+		},
+		{
+			prefix: "10.0.0.0/30",
+			leases: []string{"10.0.0.0", "10.0.0.3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			_, con := dialScan(t, tt.leases)
+
+			results, err := con.ScanLeases(context.Background(), netip.MustParsePrefix(tt.prefix), omapi.ScanOptions{Concurrency: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for result := range results {
+				if result.Err != nil {
+					t.Errorf("%s: %v", result.Addr, result.Err)
+					continue
+				}
+				if !result.Lease.IP.Equal(result.Addr.AsSlice()) {
+					t.Errorf("lease of %s has address %s", result.Addr, result.Lease.IP)
+				}
+				got = append(got, result.Addr.String())
+			}
+
+			sort.Slice(got, func(i, j int) bool {
+				return netip.MustParseAddr(got[i]).Less(netip.MustParseAddr(got[j]))
+			})
+			if len(got) != len(tt.want) {
+				t.Fatalf("got leases of %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got leases of %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestScanLeasesInvalidPrefix(t *testing.T) {
+	_, con := dialScan(t, nil)
+
+	for _, prefix := range []netip.Prefix{{}, netip.MustParsePrefix("2001:db8::/126")} {
+		if _, err := con.ScanLeases(context.Background(), prefix, omapi.ScanOptions{}); err == nil {
+			t.Errorf("ScanLeases(%s) succeeded", prefix)
+		}
+	}
+}
+
+func TestScanLeasesErrors(t *testing.T) {
+	server, con := dialScan(t, []string{"10.0.0.1", "10.0.0.2"})
+
+	// The first lookup fails, the second finds a lease.
+	server.InjectFault(omapitest.Fault{Status: omapi.Statuses[2]})
+
+	results, err := con.ScanLeases(context.Background(), netip.MustParsePrefix("10.0.0.0/30"), omapi.ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []omapi.ScanResult
+	for result := range results {
+		got = append(got, result)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d results, want 2: %+v", len(got), got)
+	}
+	if got[0].Addr.String() != "10.0.0.1" || got[0].Err == nil || got[0].Lease.IP != nil {
+		t.Errorf("failed lookup = %+v", got[0])
+	}
+	if got[1].Addr.String() != "10.0.0.2" || got[1].Err != nil {
+		t.Errorf("successful lookup = %+v", got[1])
+	}
+}
+
+func TestScanLeasesCallbacks(t *testing.T) {
+	_, con := dialScan(t, []string{"10.0.0.1", "10.0.0.9"})
+
+	var (
+		mu       sync.Mutex
+		progress []int
+		observed = make(map[string]error)
+	)
+	opts := omapi.ScanOptions{
+		Concurrency: 4,
+		Progress: func(done, total int) {
+			if total != 14 {
+				t.Errorf("Progress total = %d, want 14", total)
+			}
+			progress = append(progress, done)
+		},
+		Observe: func(result omapi.ScanResult, d time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if d <= 0 {
+				t.Errorf("Observe(%s) duration = %v", result.Addr, d)
+			}
+			observed[result.Addr.String()] = result.Err
+		},
+	}
+
+	results, err := con.ScanLeases(context.Background(), netip.MustParsePrefix("10.0.0.0/28"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range results {
+	}
+
+	// Progress is called once per address, in order.
+	if len(progress) != 14 {
+		t.Fatalf("Progress called %d times, want 14", len(progress))
+	}
+	for i, done := range progress {
+		if done != i+1 {
+			t.Fatalf("Progress done = %v, want 1 to 14", progress)
+		}
+	}
+
+	// Observe also sees the addresses without a lease.
+	mu.Lock()
+	defer mu.Unlock()
+	if len(observed) != 14 {
+		t.Errorf("Observe called for %d addresses, want 14", len(observed))
+	}
+	for addr, err := range observed {
+		switch addr {
+		case "10.0.0.1", "10.0.0.9":
+			if err != nil {
+				t.Errorf("Observe(%s) error = %v", addr, err)
+			}
+		default:
+			if err != omapi.ErrNotFound {
+				t.Errorf("Observe(%s) error = %v, want %v", addr, err, omapi.ErrNotFound)
+			}
+		}
+	}
+}
+
+func TestScanLeasesConcurrency(t *testing.T) {
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+	)
+	count := func(ctx context.Context, msg *omapi.Message, _ *omapi.Connection, invoke omapi.Invoker) (*omapi.Message, omapi.Status) {
+		mu.Lock()
+		inFlight++
+		maxFlight = max(maxFlight, inFlight)
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		return invoke(ctx, msg)
+	}
+
+	server, con := dialScan(t, nil, count)
+	server.SetLatency(5 * time.Millisecond)
+
+	for _, concurrency := range []int{0, 1, 5} {
+		mu.Lock()
+		maxFlight = 0
+		mu.Unlock()
+
+		results, err := con.ScanLeases(context.Background(), netip.MustParsePrefix("10.0.0.0/27"), omapi.ScanOptions{Concurrency: concurrency})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range results {
+		}
+
+		mu.Lock()
+		if want := max(concurrency, 1); maxFlight != want {
+			t.Errorf("Concurrency %d: up to %d lookups in flight, want %d", concurrency, maxFlight, want)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestScanLeasesCancel(t *testing.T) {
+	var addrs []string
+	for i := 1; i < 255; i++ {
+		addrs = append(addrs, netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}).String())
+	}
+	_, con := dialScan(t, addrs)
+
+	// Let goroutines of earlier tests and of the connection settle.
+	time.Sleep(10 * time.Millisecond)
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results, err := con.ScanLeases(ctx, netip.MustParsePrefix("10.0.0.0/24"), omapi.ScanOptions{Concurrency: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stop reading after a few results. The channel must be closed
+	// even though most addresses haven't been looked up.
+	for i := 0; i < 3; i++ {
+		<-results
+	}
+	cancel()
+
+	timeout := time.After(time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-results:
+		case <-timeout:
+			t.Fatal("results channel not closed after cancel")
+		}
+	}
+
+	// All goroutines of the scan exit.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines after the scan, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,435 @@
+package omapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close
+// has been called.
+var ErrServerClosed = errors.New("omapi: server closed")
+
+// An ObjectHandler implements the objects of a single type for a
+// Server. Objects are whatever the handler returns from Open and
+// Create; the server hands them back to the other methods and takes
+// care of handles. Handlers must be safe for concurrent use.
+//
+// Errors that are a Status are sent to the client as is, other errors
+// as a "failure" status with the error's text as the message.
+type ObjectHandler interface {
+	// Open looks up an existing object given the values of an open
+	// message. It should return a "not found" status if there is no
+	// such object, so that the object can be created if the client
+	// asked for it.
+	Open(ctx context.Context, query map[string][]byte) (any, error)
+
+	// Create creates a new object from the values of an open message.
+	Create(ctx context.Context, values map[string][]byte) (any, error)
+
+	// Update changes an object's values.
+	Update(ctx context.Context, obj any, values map[string][]byte) error
+
+	// Delete deletes an object.
+	Delete(ctx context.Context, obj any) error
+
+	// Refresh returns an object's current values, which are sent to
+	// the client in response to open, update and refresh messages.
+	Refresh(ctx context.Context, obj any) (map[string][]byte, error)
+}
+
+// A Server serves OMAPI clients, such as omshell or Connection, using
+// ObjectHandlers for the object types it supports.
+//
+// Example:
+//
+//	server := omapi.NewServer()
+//	server.Keys["omapi_key"] = secret
+//	server.Handle("host", hostHandler)
+//
+//	err := server.ListenAndServe(":7911")
+type Server struct {
+	// Keys maps the names of keys that clients can authenticate with
+	// to their secrets. If there are any keys, unauthenticated
+	// queries are rejected.
+	Keys map[string][]byte
+
+	// Limits restrict the messages accepted from clients. Clients
+	// that exceed them are disconnected.
+	Limits Limits
+
+	// Logger, if set, receives debug records for every message and
+	// rejected client. Values and signatures are never logged.
+	Logger *slog.Logger
+
+	// ConnContext, if set, returns the context for a new connection,
+	// derived from ctx. The handlers are called with it, so it can
+	// carry values about the connection.
+	ConnContext func(ctx context.Context, c net.Conn) context.Context
+
+	mu        sync.Mutex
+	handlers  map[string]ObjectHandler
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		Keys:      make(map[string][]byte),
+		handlers:  make(map[string]ObjectHandler),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Handle registers the handler for objects of the given type, such as
+// "host" or "lease".
+func (s *Server) Handle(typeName string, handler ObjectHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[typeName] = handler
+}
+
+// SetKey adds or replaces a key that clients can authenticate with.
+// Unlike setting Keys directly, it is safe while the server is running.
+func (s *Server) SetKey(name string, secret []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Keys[name] = secret
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients.
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on the listener and serves each of them
+// in a new goroutine. It always returns a non-nil error and closes the
+// listener.
+func (s *Server) Serve(listener net.Listener) error {
+	defer listener.Close()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[listener] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, listener)
+		s.mu.Unlock()
+	}()
+
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		go s.serveConn(c)
+	}
+}
+
+// Close closes all listeners and connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+
+	return nil
+}
+
+// serverObject is an object that a client holds a handle for.
+type serverObject struct {
+	handler ObjectHandler
+	obj     any
+}
+
+// serverConn is the state of a single client connection. Handles are
+// only valid within the connection that they were handed out on.
+type serverConn struct {
+	server        *Server
+	decoder       *Decoder
+	encoder       *Encoder
+	authenticator Authenticator
+	objects       map[int32]serverObject
+	nextHandle    int32
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if s.ConnContext != nil {
+		ctx = s.ConnContext(ctx, c)
+	}
+
+	defer func() {
+		cancel()
+		c.Close()
+
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	if err := WriteStartup(c); err != nil {
+		return
+	}
+	logger := loggerOrDiscard(s.Logger).With("client", c.RemoteAddr().String())
+
+	if err := ReadStartup(c); err != nil {
+		logger.Debug("Rejecting client", "error", err)
+		return
+	}
+
+	sc := &serverConn{
+		server:        s,
+		decoder:       NewDecoder(c),
+		encoder:       NewEncoder(c),
+		authenticator: new(nullAuthenticator),
+		objects:       make(map[int32]serverObject),
+		nextHandle:    1,
+	}
+	sc.decoder.Limits = s.Limits
+
+	for {
+		message, err := sc.decoder.Decode()
+		if err != nil {
+			logger.Debug("Closing connection", "error", err)
+			return
+		}
+
+		start := time.Now()
+		response, auth := sc.handle(ctx, message)
+		response.ResponseID = message.TransactionID
+		response.Sign(sc.authenticator)
+
+		// The response to opening an authenticator isn't signed with
+		// it yet, because the client isn't using it yet either.
+		if auth != nil {
+			sc.authenticator = auth
+		}
+
+		if logger.Enabled(ctx, slog.LevelDebug) {
+			attrs := append(messageAttrs(message),
+				slog.String("status", response.ToStatus().Message),
+				slog.Duration("latency", time.Since(start)))
+			logger.LogAttrs(ctx, slog.LevelDebug, "Message", attrs...)
+		}
+
+		if err := sc.encoder.Encode(response); err != nil {
+			return
+		}
+	}
+}
+
+// handle handles a single message and returns the response. If the
+// message opened an authenticator, it is returned as well, to be used
+// for all following messages.
+func (sc *serverConn) handle(ctx context.Context, message *Message) (*Message, Authenticator) {
+	isAuthOpen := message.Opcode == OpOpen && string(message.Message["type"]) == "authenticator"
+
+	switch {
+	case message.AuthID != 0 && message.AuthID != sc.authenticator.AuthID():
+		return newServerStatusMessage(Statuses[47], "unknown authenticator"), nil
+	case message.AuthID != 0 && !message.Verify(sc.authenticator):
+		return newServerStatusMessage(Statuses[48], "invalid signature"), nil
+	case message.AuthID == 0 && !isAuthOpen && sc.server.requiresAuth():
+		return newServerStatusMessage(Statuses[6], "authentication required"), nil
+	}
+
+	if isAuthOpen {
+		return sc.openAuthenticator(message)
+	}
+
+	return sc.handleObject(ctx, message), nil
+}
+
+func (sc *serverConn) handleObject(ctx context.Context, message *Message) *Message {
+	switch message.Opcode {
+	case OpOpen:
+		return sc.open(ctx, message)
+	case OpRefresh:
+		object, ok := sc.objects[message.Handle]
+		if !ok {
+			return newServerStatusMessage(Statuses[23], "invalid handle")
+		}
+		return sc.update(ctx, message.Handle, object)
+	case OpUpdate:
+		object, ok := sc.objects[message.Handle]
+		if !ok {
+			return newServerStatusMessage(Statuses[23], "invalid handle")
+		}
+		if err := object.handler.Update(ctx, object.obj, message.Object); err != nil {
+			return newErrorMessage(err)
+		}
+		return sc.update(ctx, message.Handle, object)
+	case OpDelete:
+		object, ok := sc.objects[message.Handle]
+		if !ok {
+			return newServerStatusMessage(Statuses[23], "invalid handle")
+		}
+		if err := object.handler.Delete(ctx, object.obj); err != nil {
+			return newErrorMessage(err)
+		}
+		delete(sc.objects, message.Handle)
+		return newServerStatusMessage(Statuses[0], "")
+	}
+
+	return newServerStatusMessage(Statuses[27], "unsupported opcode")
+}
+
+func (s *Server) requiresAuth() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.Keys) > 0
+}
+
+func (sc *serverConn) openAuthenticator(message *Message) (*Message, Authenticator) {
+	sc.server.mu.Lock()
+	name := string(message.Object["name"])
+	key, ok := sc.server.Keys[name]
+	sc.server.mu.Unlock()
+
+	if !ok {
+		return newServerStatusMessage(Statuses[23], "unknown key"), nil
+	}
+
+	auth := &hmacMD5Authenticator{name, key, sc.nextHandle}
+	if !strings.EqualFold(string(message.Object["algorithm"]), string(auth.AuthObject()["algorithm"])) {
+		return newServerStatusMessage(Statuses[39], "unsupported algorithm"), nil
+	}
+	sc.nextHandle++
+
+	response := NewMessage()
+	response.Opcode = OpUpdate
+	response.Handle = auth.AuthID()
+	response.Object = auth.AuthObject()
+
+	return response, auth
+}
+
+func (sc *serverConn) open(ctx context.Context, message *Message) *Message {
+	typeName := string(message.Message["type"])
+	create := isTrue(message.Message["create"])
+	exclusive := isTrue(message.Message["exclusive"])
+	update := isTrue(message.Message["update"])
+
+	sc.server.mu.Lock()
+	handler, ok := sc.server.handlers[typeName]
+	sc.server.mu.Unlock()
+
+	if !ok {
+		return newServerStatusMessage(Statuses[23], "unknown object type")
+	}
+
+	obj, err := handler.Open(ctx, message.Object)
+	switch {
+	case errors.Is(err, ErrNotFound) && create:
+		if obj, err = handler.Create(ctx, message.Object); err != nil {
+			return newErrorMessage(err)
+		}
+	case err != nil:
+		return newErrorMessage(err)
+	case create && exclusive:
+		return newServerStatusMessage(Statuses[18], "")
+	case update:
+		if err := handler.Update(ctx, obj, message.Object); err != nil {
+			return newErrorMessage(err)
+		}
+	}
+
+	handle := sc.nextHandle
+	sc.nextHandle++
+	object := serverObject{handler, obj}
+	sc.objects[handle] = object
+
+	return sc.update(ctx, handle, object)
+}
+
+// update returns an update message with the object's current values.
+func (sc *serverConn) update(ctx context.Context, handle int32, object serverObject) *Message {
+	values, err := object.handler.Refresh(ctx, object.obj)
+	if err != nil {
+		return newErrorMessage(err)
+	}
+
+	message := NewMessage()
+	message.Opcode = OpUpdate
+	message.Handle = handle
+	for key, value := range values {
+		message.Object[key] = value
+	}
+
+	return message
+}
+
+func isTrue(value []byte) bool {
+	for _, b := range value {
+		if b != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newServerStatusMessage returns a status message with an optional
+// explanation.
+func newServerStatusMessage(status Status, text string) *Message {
+	message := NewMessage()
+	message.Opcode = OpStatus
+	message.Message["result"] = int32ToBytes(status.Code)
+	if text != "" {
+		message.Message["message"] = []byte(text)
+	}
+
+	return message
+}
+
+func newErrorMessage(err error) *Message {
+	var status Status
+	if errors.As(err, &status) {
+		return newServerStatusMessage(status, status.Text)
+	}
+
+	return newServerStatusMessage(Statuses[25], err.Error())
+}
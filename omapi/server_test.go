@@ -0,0 +1,208 @@
+package omapi_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+)
+
+// mapHandler is an ObjectHandler for objects that are looked up by
+// name and stored in a map.
+type mapHandler struct {
+	mu      sync.Mutex
+	objects map[string]map[string][]byte
+}
+
+func (h *mapHandler) Open(_ context.Context, query map[string][]byte) (any, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	name := string(query["name"])
+	if _, ok := h.objects[name]; !ok {
+		return nil, omapi.ErrNotFound
+	}
+
+	return name, nil
+}
+
+func (h *mapHandler) Create(_ context.Context, values map[string][]byte) (any, error) {
+	name := string(values["name"])
+	if name == "" {
+		return nil, errors.New("object needs a name")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.objects[name] = make(map[string][]byte)
+	for key, value := range values {
+		h.objects[name][key] = value
+	}
+
+	return name, nil
+}
+
+func (h *mapHandler) Update(_ context.Context, obj any, values map[string][]byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, value := range values {
+		h.objects[obj.(string)][key] = value
+	}
+
+	return nil
+}
+
+func (h *mapHandler) Delete(_ context.Context, obj any) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.objects, obj.(string))
+
+	return nil
+}
+
+func (h *mapHandler) Refresh(_ context.Context, obj any) (map[string][]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	values, ok := h.objects[obj.(string)]
+	if !ok {
+		return nil, omapi.ErrNotFound
+	}
+
+	return values, nil
+}
+
+// startServer serves a mapHandler for "thing" objects and returns the
+// server's address.
+func startServer(t *testing.T, server *omapi.Server) string {
+	t.Helper()
+
+	server.Handle("thing", &mapHandler{objects: make(map[string]map[string][]byte)})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(listener) }()
+
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-done; !errors.Is(err, omapi.ErrServerClosed) {
+			t.Errorf("Serve returned %v, want ErrServerClosed", err)
+		}
+	})
+
+	return listener.Addr().String()
+}
+
+func TestServer(t *testing.T) {
+	server := omapi.NewServer()
+
+	var connections sync.WaitGroup
+	connections.Add(1)
+	server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		connections.Done()
+		return ctx
+	}
+
+	addr := startServer(t, server)
+
+	con, err := omapi.Dial(addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	connections.Wait()
+
+	created, err := con.CreateObject("thing", map[string][]byte{"name": []byte("a"), "color": []byte("red")})
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+	if string(created.Object["color"]) != "red" {
+		t.Errorf("created object has values %v", created.Object)
+	}
+
+	if _, err := con.CreateObject("thing", map[string][]byte{"name": []byte("a")}); !errors.Is(err, omapi.ErrAlreadyExists) {
+		t.Errorf("creating a duplicate returned %v, want already exists", err)
+	}
+
+	// Errors that aren't a Status are sent as "failure" with the
+	// error's text.
+	_, err = con.CreateObject("thing", map[string][]byte{"color": []byte("blue")})
+	var status omapi.Status
+	if !errors.As(err, &status) || status.Code != 25 || status.Text != "object needs a name" {
+		t.Errorf("creating an object without a name returned %#v", err)
+	}
+
+	if err := con.Update(created.Handle, map[string][]byte{"color": []byte("green")}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	opened, err := con.OpenObject("thing", map[string][]byte{"name": []byte("a")})
+	if err != nil {
+		t.Fatalf("OpenObject: %v", err)
+	}
+	if string(opened.Object["color"]) != "green" {
+		t.Errorf("opened object has values %v", opened.Object)
+	}
+
+	if err := con.Delete(opened.Handle); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := con.RefreshObject(opened.Handle); !errors.Is(err, omapi.ErrNotFound) {
+		t.Errorf("refreshing a deleted handle returned %v, want not found", err)
+	}
+	if _, err := con.RefreshObject(created.Handle); !errors.Is(err, omapi.ErrNotFound) {
+		t.Errorf("refreshing a deleted object returned %v, want not found", err)
+	}
+
+	if _, err := con.OpenObject("widget", map[string][]byte{"name": []byte("a")}); !errors.Is(err, omapi.ErrNotFound) {
+		t.Errorf("opening an unknown type returned %v, want not found", err)
+	}
+}
+
+func TestServerAuthentication(t *testing.T) {
+	server := omapi.NewServer()
+	addr := startServer(t, server)
+
+	// Keys can be added while serving.
+	server.SetKey("omapi_key", []byte("secret"))
+
+	con, err := omapi.Dial(addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	if _, err := con.OpenObject("thing", map[string][]byte{"name": []byte("a")}); !errors.Is(err, omapi.ErrPermissionDenied) {
+		t.Errorf("unauthenticated open returned %v, want permission denied", err)
+	}
+
+	authenticated, err := omapi.Dial(addr, "omapi_key", "c2VjcmV0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer authenticated.Close()
+
+	if _, err := authenticated.CreateObject("thing", map[string][]byte{"name": []byte("a")}); err != nil {
+		t.Errorf("authenticated create: %v", err)
+	}
+
+	wrong, err := omapi.Dial(addr, "omapi_key", "d3Jvbmc=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wrong.Close()
+
+	if _, err := wrong.OpenObject("thing", map[string][]byte{"name": []byte("a")}); !errors.Is(err, omapi.Statuses[48]) {
+		t.Errorf("open with the wrong secret returned %v, want invalid key", err)
+	}
+}
@@ -1,88 +1,132 @@
 package omapi
 
+import "fmt"
+
+// A Status is the result of a query. Statuses that are errors can be
+// compared with errors.Is, which only compares their codes:
+//
+//	if errors.Is(err, omapi.ErrNotFound) {
+//		// There is no such object
+//	}
 type Status struct {
 	Code    int32
 	Message string
+
+	// Text is the explanation the server sent along with the status,
+	// if any, such as "no object with that handle".
+	Text string
 }
 
 var Statuses = []Status{
-	Status{0, "success"},
-	Status{1, "out of memory"},
-	Status{2, "timed out"},
-	Status{3, "no available threads"},
-	Status{4, "address not available"},
-	Status{5, "address in use"},
-	Status{6, "permission denied"},
-	Status{7, "no pending connections"},
-	Status{8, "network unreachable"},
-	Status{9, "host unreachable"},
-	Status{10, "network down"},
-	Status{11, "host down"},
-	Status{12, "connection refused"},
-	Status{13, "not enough free resources"},
-	Status{14, "end of file"},
-	Status{15, "socket already bound"},
-	Status{16, "task is done"},
-	Status{17, "lock busy"},
-	Status{18, "already exists"},
-	Status{19, "ran out of space"},
-	Status{20, "operation canceled"},
-	Status{21, "sending events is not allowed"},
-	Status{22, "shutting down"},
-	Status{23, "not found"},
-	Status{24, "unexpected end of input"},
-	Status{25, "failure"},
-	Status{26, "I/O error"},
-	Status{27, "not implemented"},
-	Status{28, "unbalanced parentheses"},
-	Status{29, "no more"},
-	Status{30, "invalid file"},
-	Status{31, "bad base64 encoding"},
-	Status{32, "unexpected token"},
-	Status{33, "quota reached"},
-	Status{34, "unexpected error"},
-	Status{35, "already running"},
-	Status{36, "host unknown"},
-	Status{37, "protocol version mismatch"},
-	Status{38, "protocol error"},
-	Status{39, "invalid argument"},
-	Status{40, "not connected"},
-	Status{41, "data not yet available"},
-	Status{42, "object unchanged"},
-	Status{43, "more than one object matches key"},
-	Status{44, "key conflict"},
-	Status{45, "parse error(s) occurred"},
-	Status{46, "no key specified"},
-	Status{47, "zone TSIG key not known"},
-	Status{48, "invalid TSIG key"},
-	Status{49, "operation in progress"},
-	Status{50, "DNS format error"},
-	Status{51, "DNS server failed"},
-	Status{52, "no such domain"},
-	Status{53, "not implemented"},
-	Status{54, "refused"},
-	Status{55, "domain already exists"},
-	Status{56, "RRset already exists"},
-	Status{57, "no such RRset"},
-	Status{58, "not authorized"},
-	Status{59, "not a zone"},
-	Status{60, "bad DNS signature"},
-	Status{61, "bad DNS key"},
-	Status{62, "clock skew too great"},
-	Status{63, "no root zone"},
-	Status{64, "destination address required"},
-	Status{65, "cross-zone update"},
-	Status{66, "no TSIG signature"},
-	Status{67, "not equal"},
-	Status{68, "connection reset by peer"},
-	Status{69, "unknown attribute"},
+	Status{0, "success", ""},
+	Status{1, "out of memory", ""},
+	Status{2, "timed out", ""},
+	Status{3, "no available threads", ""},
+	Status{4, "address not available", ""},
+	Status{5, "address in use", ""},
+	Status{6, "permission denied", ""},
+	Status{7, "no pending connections", ""},
+	Status{8, "network unreachable", ""},
+	Status{9, "host unreachable", ""},
+	Status{10, "network down", ""},
+	Status{11, "host down", ""},
+	Status{12, "connection refused", ""},
+	Status{13, "not enough free resources", ""},
+	Status{14, "end of file", ""},
+	Status{15, "socket already bound", ""},
+	Status{16, "task is done", ""},
+	Status{17, "lock busy", ""},
+	Status{18, "already exists", ""},
+	Status{19, "ran out of space", ""},
+	Status{20, "operation canceled", ""},
+	Status{21, "sending events is not allowed", ""},
+	Status{22, "shutting down", ""},
+	Status{23, "not found", ""},
+	Status{24, "unexpected end of input", ""},
+	Status{25, "failure", ""},
+	Status{26, "I/O error", ""},
+	Status{27, "not implemented", ""},
+	Status{28, "unbalanced parentheses", ""},
+	Status{29, "no more", ""},
+	Status{30, "invalid file", ""},
+	Status{31, "bad base64 encoding", ""},
+	Status{32, "unexpected token", ""},
+	Status{33, "quota reached", ""},
+	Status{34, "unexpected error", ""},
+	Status{35, "already running", ""},
+	Status{36, "host unknown", ""},
+	Status{37, "protocol version mismatch", ""},
+	Status{38, "protocol error", ""},
+	Status{39, "invalid argument", ""},
+	Status{40, "not connected", ""},
+	Status{41, "data not yet available", ""},
+	Status{42, "object unchanged", ""},
+	Status{43, "more than one object matches key", ""},
+	Status{44, "key conflict", ""},
+	Status{45, "parse error(s) occurred", ""},
+	Status{46, "no key specified", ""},
+	Status{47, "zone TSIG key not known", ""},
+	Status{48, "invalid TSIG key", ""},
+	Status{49, "operation in progress", ""},
+	Status{50, "DNS format error", ""},
+	Status{51, "DNS server failed", ""},
+	Status{52, "no such domain", ""},
+	Status{53, "not implemented", ""},
+	Status{54, "refused", ""},
+	Status{55, "domain already exists", ""},
+	Status{56, "RRset already exists", ""},
+	Status{57, "no such RRset", ""},
+	Status{58, "not authorized", ""},
+	Status{59, "not a zone", ""},
+	Status{60, "bad DNS signature", ""},
+	Status{61, "bad DNS key", ""},
+	Status{62, "clock skew too great", ""},
+	Status{63, "no root zone", ""},
+	Status{64, "destination address required", ""},
+	Status{65, "cross-zone update", ""},
+	Status{66, "no TSIG signature", ""},
+	Status{67, "not equal", ""},
+	Status{68, "connection reset by peer", ""},
+	Status{69, "unknown attribute", ""},
+}
+
+// Errors for the statuses that callers commonly need to tell apart.
+var (
+	ErrPermissionDenied = Statuses[6]
+	ErrAlreadyExists    = Statuses[18]
+	ErrNotFound         = Statuses[23]
+	ErrMultipleMatches  = Statuses[43] // more than one object matches key
+	ErrKeyConflict      = Statuses[44]
+)
+
+// StatusForCode returns the status with the given code. Codes that
+// this package doesn't know, which newer servers may send, get a
+// generic message.
+func StatusForCode(code int32) Status {
+	if code >= 0 && int(code) < len(Statuses) {
+		return Statuses[code]
+	}
+
+	return Status{Code: code, Message: fmt.Sprintf("unknown status %d", code)}
 }
 
 // IsError returns true if the status is describing an error.
 func (s Status) IsError() bool {
-	return s.Code > 0
+	return s.Code != 0
 }
 
 func (s Status) Error() string {
+	if s.Text != "" {
+		return s.Message + ": " + s.Text
+	}
+
 	return s.Message
 }
+
+// Is reports whether target is a Status with the same code, so that
+// statuses with different texts match.
+func (s Status) Is(target error) bool {
+	status, ok := target.(Status)
+
+	return ok && status.Code == s.Code
+}
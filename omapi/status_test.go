@@ -0,0 +1,69 @@
+package omapi_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/loopinternet/dhcp-management/omapi"
+	"github.com/loopinternet/dhcp-management/omapi/omapitest"
+)
+
+func TestStatusIs(t *testing.T) {
+	withText := omapi.ErrNotFound
+	withText.Text = "no such host"
+
+	tests := []struct {
+		name   string
+		err    error
+		target error
+		want   bool
+	}{
+		{"same", omapi.ErrNotFound, omapi.ErrNotFound, true},
+		{"text", withText, omapi.ErrNotFound, true},
+		{"wrapped", fmt.Errorf("looking up host: %w", withText), omapi.ErrNotFound, true},
+		{"other code", withText, omapi.ErrAlreadyExists, false},
+		{"unknown code", omapi.StatusForCode(1000), omapi.StatusForCode(1000), true},
+		{"not a status", errors.New("not found"), omapi.ErrNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(tt.err, tt.target); got != tt.want {
+				t.Errorf("errors.Is(%v, %v) = %v, want %v", tt.err, tt.target, got, tt.want)
+			}
+		})
+	}
+
+	if got := withText.Error(); got != "not found: no such host" {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := omapi.StatusForCode(1000).Error(); got != "unknown status 1000" {
+		t.Errorf("Error() of an unknown code = %q", got)
+	}
+}
+
+// The server's explanation of a status is kept, and doesn't keep the
+// status from matching its sentinel.
+func TestStatusText(t *testing.T) {
+	server := omapitest.NewServer()
+	defer server.Close()
+
+	con, err := omapi.Dial(server.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	_, err = con.CreateObject("lease", map[string][]byte{"ip-address": {10, 0, 0, 1}})
+
+	var status omapi.Status
+	if !errors.As(err, &status) || status.Text != "objects of this type cannot be created" {
+		t.Fatalf("CreateObject returned %#v", err)
+	}
+
+	server.InjectFault(omapitest.Fault{Status: omapi.ErrNotFound})
+	if _, err := con.FindHost(omapi.Host{Name: "a"}); !errors.Is(err, omapi.ErrNotFound) || err.Error() != "not found: injected fault" {
+		t.Errorf("FindHost returned %v, want not found: injected fault", err)
+	}
+}